@@ -0,0 +1,89 @@
+package main
+
+import (
+	"crypto/tls"
+	"fmt"
+	"net/http"
+	"os"
+	"time"
+
+	"github.com/spf13/viper"
+	"golang.org/x/crypto/acme"
+	"golang.org/x/crypto/acme/autocert"
+)
+
+// defaultACMECacheDir is where account keys and certificates are kept when
+// acme.cacheDir is not set.
+const defaultACMECacheDir = "acme"
+
+// acmeConfig holds the [acme] settings. ACME is enabled when domains is
+// not empty.
+type acmeConfig struct {
+	Domains     []string
+	Email       string
+	Directory   string
+	DirectoryCA string
+	CacheDir    string
+	RenewBefore time.Duration
+}
+
+// acmeEnabled reports whether certificates are obtained through ACME.
+func acmeEnabled(v *viper.Viper) bool {
+	return len(v.GetStringSlice("acme.domains")) > 0
+}
+
+// loadACME reads the [acme] table. It returns nil when ACME is disabled.
+func loadACME(v *viper.Viper) (*acmeConfig, error) {
+	if !acmeEnabled(v) {
+		return nil, nil
+	}
+	c := &acmeConfig{
+		Domains:     v.GetStringSlice("acme.domains"),
+		Email:       v.GetString("acme.email"),
+		Directory:   v.GetString("acme.directory"),
+		DirectoryCA: v.GetString("acme.directoryCA"),
+		CacheDir:    v.GetString("acme.cacheDir"),
+		RenewBefore: v.GetDuration("acme.renewBefore"),
+	}
+	if c.Directory == "" {
+		c.Directory = autocert.DefaultACMEDirectory
+	}
+	if c.CacheDir == "" {
+		c.CacheDir = defaultACMECacheDir
+	}
+	if c.RenewBefore < 0 {
+		return nil, fmt.Errorf("acme: renewBefore %s is negative", c.RenewBefore)
+	}
+	return c, nil
+}
+
+// newACMEManager creates the certificate manager for c. Certificates are
+// requested on the first handshake for one of the domains, cached in the
+// cache directory and renewed in the background before they expire.
+func newACMEManager(c *acmeConfig) (*autocert.Manager, error) {
+	if err := os.MkdirAll(c.CacheDir, 0o700); err != nil {
+		return nil, fmt.Errorf("acme: %w", err)
+	}
+	client := &acme.Client{DirectoryURL: c.Directory}
+	if c.DirectoryCA != "" {
+		// A test CA such as Pebble serves its directory with a
+		// certificate of its own.
+		pool, err := loadCertPool(c.DirectoryCA)
+		if err != nil {
+			return nil, fmt.Errorf("acme: %w", err)
+		}
+		transport := http.DefaultTransport.(*http.Transport).Clone()
+		transport.TLSClientConfig = &tls.Config{RootCAs: pool}
+		client.HTTPClient = &http.Client{Transport: transport}
+	}
+	return &autocert.Manager{
+		// Configuring [acme] is taken as agreeing to the terms of
+		// service of the CA.
+		Prompt:      autocert.AcceptTOS,
+		Cache:       autocert.DirCache(c.CacheDir),
+		HostPolicy:  autocert.HostWhitelist(c.Domains...),
+		RenewBefore: c.RenewBefore,
+		Client:      client,
+		Email:       c.Email,
+	}, nil
+}
@@ -0,0 +1,157 @@
+package main
+
+import (
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/tuggan/goip/logger"
+	"golang.org/x/crypto/acme/autocert"
+)
+
+func TestLoadACME(t *testing.T) {
+	c, err := loadACME(configFromTOML(t, ``))
+	if err != nil || c != nil {
+		t.Fatalf("expected ACME to be disabled without domains, got %+v, %v", c, err)
+	}
+
+	c, err = loadACME(configFromTOML(t, `
+[acme]
+domains = ["ip.example.com"]
+`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if c.Directory != autocert.DefaultACMEDirectory || c.CacheDir != defaultACMECacheDir {
+		t.Errorf("expected the default directory and cache, got %+v", c)
+	}
+
+	c, err = loadACME(configFromTOML(t, `
+[acme]
+domains = ["ip.example.com"]
+email = "ops@example.com"
+directory = "https://localhost:14000/dir"
+cacheDir = "/var/lib/goip/acme"
+renewBefore = "240h"
+`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if c.Email != "ops@example.com" || c.Directory != "https://localhost:14000/dir" ||
+		c.CacheDir != "/var/lib/goip/acme" || c.RenewBefore != 240*time.Hour {
+		t.Errorf("unexpected config %+v", c)
+	}
+
+	if _, err := loadACME(configFromTOML(t, `
+[acme]
+domains = ["ip.example.com"]
+renewBefore = "-1h"
+`)); err == nil {
+		t.Error("expected a negative renewBefore to fail")
+	}
+}
+
+func TestNewACMEManager_DirectoryCA(t *testing.T) {
+	dir := t.TempDir()
+	c := &acmeConfig{
+		Domains:     []string{"ip.example.com"},
+		CacheDir:    filepath.Join(dir, "cache"),
+		DirectoryCA: filepath.Join(dir, "ca.pem"),
+	}
+	if err := os.WriteFile(c.DirectoryCA, []byte("not a certificate"), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := newACMEManager(c); err == nil {
+		t.Error("expected a directory CA without certificates to fail")
+	}
+	if fi, err := os.Stat(c.CacheDir); err != nil || !fi.IsDir() {
+		t.Errorf("expected the cache directory to be created, got %v", err)
+	}
+}
+
+func TestLoadListeners_ACME(t *testing.T) {
+	v := configFromTOML(t, `
+endpoint = ["127.0.0.1:80"]
+tlsEndpoint = ["127.0.0.1:443"]
+
+[acme]
+domains = ["ip.example.com"]
+
+[[listener]]
+name = "internal"
+address = "127.0.0.1:8443"
+tlsCert = "internal.crt"
+tlsKey = "internal.key"
+`)
+	listeners, err := loadListeners(v)
+	if err != nil {
+		t.Fatal(err)
+	}
+	acme := make(map[string]bool)
+	for _, l := range listeners {
+		acme[l.Name] = l.ACME
+	}
+	if acme["http"] || !acme["https"] || acme["internal"] {
+		t.Errorf("expected only the TLS listener without a certificate to use ACME, got %v", acme)
+	}
+
+	if _, err := loadListeners(configFromTOML(t, `tlsEndpoint = ["127.0.0.1:443"]`)); err == nil {
+		t.Error("expected a TLS listener without certificate or ACME to fail")
+	}
+}
+
+func TestNewListener_ACME(t *testing.T) {
+	logger.Init(io.Discard, io.Discard, io.Discard, io.Discard)
+	cache := t.TempDir()
+	m, err := newACMEManager(&acmeConfig{Domains: []string{"ip.example.com"}, CacheDir: cache})
+	if err != nil {
+		t.Fatal(err)
+	}
+	themes, err := loadThemes(configFromTOML(t, ``), templateFS(""), nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	opts := handlerOptions{themes: themes, staticDir: "static", acme: m}
+
+	l, err := newListener(listenerConfig{Name: "https", Address: "127.0.0.1:0", TLS: true, ACME: true}, opts)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer l.rl.Stop()
+	if l.srv.TLSConfig == nil || l.srv.TLSConfig.GetCertificate == nil ||
+		!slices.Contains(l.srv.TLSConfig.NextProtos, "acme-tls/1") {
+		t.Error("expected the TLS listener to get its certificates from the ACME manager")
+	}
+
+	l, err = newListener(listenerConfig{Name: "http", Address: "127.0.0.1:0"}, opts)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer l.rl.Stop()
+	if err := os.WriteFile(filepath.Join(cache, "token+http-01"), []byte("token.key"), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	get := func(host, path string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(http.MethodGet, path, nil)
+		req.Host = host
+		req.RemoteAddr = "1.2.3.4:5678"
+		w := httptest.NewRecorder()
+		l.srv.Handler.ServeHTTP(w, req)
+		return w
+	}
+	if w := get("ip.example.com", "/.well-known/acme-challenge/token"); w.Body.String() != "token.key" {
+		t.Errorf("expected the HTTP-01 challenge response, got %d %q", w.Code, w.Body.String())
+	}
+	if w := get("other.example.com", "/.well-known/acme-challenge/token"); w.Code != http.StatusForbidden {
+		t.Errorf("expected challenges for other hosts to be refused, got %d", w.Code)
+	}
+	if w := get("ip.example.com", "/"); !strings.Contains(w.Body.String(), "<html") {
+		t.Error("expected other paths to be served as usual")
+	}
+}
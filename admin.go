@@ -0,0 +1,388 @@
+package main
+
+import (
+	"crypto/sha256"
+	"crypto/subtle"
+	"crypto/tls"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"maps"
+	"net"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/spf13/viper"
+	"github.com/tuggan/goip/logger"
+	"github.com/tuggan/goip/web"
+	"golang.org/x/crypto/bcrypt"
+)
+
+// adminConfig describes the admin listener that serves the operational
+// endpoints, separate from the public listeners.
+type adminConfig struct {
+	listenerConfig
+	// ClientCA requires clients to present a certificate signed by it.
+	ClientCA string
+	// Tokens are the SHA-256 sums of the accepted bearer tokens.
+	Tokens [][]byte
+	// Users maps basic auth user names to bcrypt password hashes.
+	Users map[string][]byte
+	// Allow limits the clients that may connect, any when empty.
+	Allow     []*net.IPNet
+	AllowUnix bool
+	// Debug serves the profiling and debug endpoints.
+	Debug bool
+}
+
+// loadAdminConfig reads adminEndpoint and the [admin] section. It returns
+// nil when no admin endpoint is configured.
+func loadAdminConfig(v *viper.Viper) (*adminConfig, error) {
+	addr := v.GetString("adminEndpoint")
+	if addr == "" {
+		return nil, nil
+	}
+	c := &adminConfig{listenerConfig: listenerConfig{
+		Name:           "admin",
+		Network:        "tcp",
+		Address:        addr,
+		RateLimitBurst: 1,
+		Limits:         loadServerLimits(v, "admin.", loadServerLimits(v, "", defaultServerLimits())),
+	}}
+	if err := c.Limits.validate(); err != nil {
+		return nil, fmt.Errorf("admin: %w", err)
+	}
+	if strings.HasPrefix(addr, unixPrefix) {
+		c.Network = "unix"
+		c.Address = strings.TrimPrefix(addr, unixPrefix)
+		mode, err := parseSocketMode(v.Get("admin.socketMode"))
+		if err != nil {
+			return nil, fmt.Errorf("admin: %w", err)
+		}
+		c.SocketMode = mode
+		c.SocketOwner = v.GetString("admin.socketOwner")
+		c.SocketGroup = v.GetString("admin.socketGroup")
+	}
+
+	c.TLSCert = v.GetString("admin.tlsCert")
+	c.TLSKey = v.GetString("admin.tlsKey")
+	c.ClientCA = v.GetString("admin.clientCA")
+	c.TLS = c.TLSCert != "" || c.TLSKey != "" || c.ClientCA != ""
+	if c.TLS && (c.TLSCert == "" || c.TLSKey == "") {
+		return nil, errors.New("admin: both tlsCert and tlsKey must be set for TLS")
+	}
+	policy, err := loadTLSPolicy(v, "", defaultTLSPolicyConfig())
+	if err != nil {
+		return nil, err
+	}
+	if c.TLSPolicy, err = loadTLSPolicy(v, "admin.", policy); err != nil {
+		return nil, err
+	}
+
+	for _, t := range v.GetStringSlice("admin.tokens") {
+		sum, err := hex.DecodeString(strings.TrimPrefix(t, "sha256:"))
+		if err != nil || len(sum) != sha256.Size {
+			return nil, fmt.Errorf("admin: token %q is not a hex encoded SHA-256 sum", t)
+		}
+		c.Tokens = append(c.Tokens, sum)
+	}
+	users := v.GetStringMapString("admin.users")
+	if len(users) > 0 {
+		c.Users = make(map[string][]byte, len(users))
+	}
+	for user, hash := range users {
+		if _, err := bcrypt.Cost([]byte(hash)); err != nil {
+			return nil, fmt.Errorf("admin: password of user %q is not a bcrypt hash: %w", user, err)
+		}
+		c.Users[user] = []byte(hash)
+	}
+	if len(c.Tokens) == 0 && len(c.Users) == 0 && c.ClientCA == "" {
+		return nil, errors.New("admin: tokens, users or clientCA must be set")
+	}
+
+	for _, a := range v.GetStringSlice("admin.allow") {
+		if a == "unix" {
+			c.AllowUnix = true
+			continue
+		}
+		ipnet, err := parseIPNet(a)
+		if err != nil {
+			return nil, fmt.Errorf("admin: allow: %w", err)
+		}
+		c.Allow = append(c.Allow, ipnet)
+	}
+	c.Debug = v.GetBool("admin.debug")
+	if c.Debug && !v.IsSet("admin.writeTimeout") {
+		// CPU profiles and traces stream for as long as requested.
+		c.Limits.WriteTimeout = 0
+	}
+
+	if err := validateListener(c.listenerConfig); err != nil {
+		return nil, err
+	}
+	return c, nil
+}
+
+// parseIPNet parses an IP address or CIDR range. A single address becomes
+// a /32 or /128 network.
+func parseIPNet(s string) (*net.IPNet, error) {
+	if _, ipnet, err := net.ParseCIDR(s); err == nil {
+		return ipnet, nil
+	}
+	ip := net.ParseIP(s)
+	if ip == nil {
+		return nil, fmt.Errorf("invalid IP address or CIDR range %q", s)
+	}
+	bits := 128
+	if ip.To4() != nil {
+		ip = ip.To4()
+		bits = 32
+	}
+	return &net.IPNet{IP: ip, Mask: net.CIDRMask(bits, bits)}, nil
+}
+
+// allowed reports whether the client of r may use the admin listener.
+func (c *adminConfig) allowed(r *http.Request) bool {
+	if len(c.Allow) == 0 && !c.AllowUnix {
+		return true
+	}
+	if info := web.ConnInfoFromContext(r.Context()); info != nil && info.Unix {
+		return c.AllowUnix
+	}
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		return false
+	}
+	ip := net.ParseIP(host)
+	for _, ipnet := range c.Allow {
+		if ip != nil && ipnet.Contains(ip) {
+			return true
+		}
+	}
+	return false
+}
+
+// authenticated reports whether r carries a valid bearer token or basic
+// auth credentials. Without tokens and users the client certificate
+// verified during the TLS handshake is enough.
+func (c *adminConfig) authenticated(r *http.Request) bool {
+	if len(c.Tokens) == 0 && len(c.Users) == 0 {
+		return true
+	}
+	auth := r.Header.Get("Authorization")
+	if token, ok := strings.CutPrefix(auth, "Bearer "); ok {
+		sum := sha256.Sum256([]byte(token))
+		for _, t := range c.Tokens {
+			if subtle.ConstantTimeCompare(sum[:], t) == 1 {
+				return true
+			}
+		}
+		return false
+	}
+	if user, password, ok := r.BasicAuth(); ok {
+		hash, found := c.Users[user]
+		if !found {
+			return false
+		}
+		return bcrypt.CompareHashAndPassword(hash, []byte(password)) == nil
+	}
+	return false
+}
+
+// authMiddleware rejects clients outside the allowlist and requests
+// without valid credentials.
+func (c *adminConfig) authMiddleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if !c.allowed(r) {
+			http.Error(w, "403 Forbidden", http.StatusForbidden)
+			logger.Access(r, http.StatusForbidden)
+			return
+		}
+		if !c.authenticated(r) {
+			if len(c.Users) > 0 {
+				w.Header().Set("WWW-Authenticate", `Basic realm="GoIP admin", charset="UTF-8"`)
+			} else {
+				w.Header().Set("WWW-Authenticate", `Bearer realm="GoIP admin"`)
+			}
+			http.Error(w, "401 Unauthorized", http.StatusUnauthorized)
+			logger.Access(r, http.StatusUnauthorized)
+			return
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+// tlsConfig returns the server TLS configuration requiring client
+// certificates when ClientCA is set.
+func (c *adminConfig) tlsConfig() (*tls.Config, error) {
+	if c.ClientCA == "" {
+		return nil, nil
+	}
+	pool, err := loadCertPool(c.ClientCA)
+	if err != nil {
+		return nil, fmt.Errorf("admin: %w", err)
+	}
+	return &tls.Config{
+		ClientAuth: tls.RequireAndVerifyClientCert,
+		ClientCAs:  pool,
+	}, nil
+}
+
+// newAdminListener creates the server for the admin listener. It has no
+// rate limit and serves only the given routes.
+func newAdminListener(c *adminConfig, routes map[string]http.HandlerFunc) (*listener, error) {
+	tlsConfig, err := c.tlsConfig()
+	if err != nil {
+		return nil, err
+	}
+	var certs *certStore
+	if c.TLS {
+		if certs, err = newCertStore(c.Name, c.certPairs()); err != nil {
+			return nil, err
+		}
+		if tlsConfig == nil {
+			tlsConfig = &tls.Config{}
+		}
+		tlsConfig.GetCertificate = certs.GetCertificate
+	}
+	mux := http.NewServeMux()
+	for pattern, h := range routes {
+		mux.HandleFunc(pattern, h)
+	}
+	srv := &http.Server{
+		Handler:     metricsMiddleware(c.Name, recoveryMiddleware(c.authMiddleware(securityHeadersMiddleware(mux)))),
+		ErrorLog:    log.New(serverErrorLog{listener: c.Name}, "", 0),
+		ConnContext: connContext(c.Name, nil),
+		TLSConfig:   tlsConfig,
+	}
+	c.Limits.apply(srv)
+	var tickets *ticketKeys
+	if tlsConfig != nil {
+		tickets = c.TLSPolicy.apply(srv)
+	}
+	return &listener{cfg: c.listenerConfig, srv: srv, rl: web.NewRateLimiter(0, 1, 0), certs: certs, tickets: tickets, conns: trackConns(srv)}, nil
+}
+
+// adminRoutes returns the operational endpoints served on the admin
+// listener, including the debug endpoints when they are enabled.
+func adminRoutes(c *adminConfig, v *viper.Viper, bans *web.BanList, listeners func() []*listener) map[string]http.HandlerFunc {
+	routes := map[string]http.HandlerFunc{
+		"GET /metrics":          metricsRegistry.Handler(),
+		"GET /config":           configHandler(v),
+		"GET /bans":             listBansHandler(bans),
+		"POST /bans":            banHandler(bans),
+		"DELETE /bans/{client}": unbanHandler(bans),
+	}
+	if c.Debug {
+		maps.Copy(routes, debugRoutes(listeners))
+	}
+	return routes
+}
+
+// redactedKeys are the parts of setting names whose values are replaced in
+// the config dump.
+var redactedKeys = []string{"token", "password", "secret", "users", "headers"}
+
+// redact returns a copy of settings with secret values replaced.
+func redact(settings map[string]any) map[string]any {
+	out := make(map[string]any, len(settings))
+	for k, v := range settings {
+		lk := strings.ToLower(k)
+		secret := false
+		for _, r := range redactedKeys {
+			if strings.Contains(lk, r) {
+				secret = true
+				break
+			}
+		}
+		switch {
+		case secret:
+			out[k] = "REDACTED"
+		case isMap(v):
+			out[k] = redact(v.(map[string]any))
+		default:
+			out[k] = v
+		}
+	}
+	return out
+}
+
+func isMap(v any) bool {
+	_, ok := v.(map[string]any)
+	return ok
+}
+
+// configHandler dumps the effective configuration as JSON with secrets
+// redacted.
+func configHandler(v *viper.Viper) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		writeJSON(w, r, http.StatusOK, redact(v.AllSettings()))
+	}
+}
+
+func listBansHandler(bans *web.BanList) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		writeJSON(w, r, http.StatusOK, bans.List())
+	}
+}
+
+// banHandler bans the client given in the JSON body, e.g.
+// {"client": "192.0.2.1", "duration": "1h"}. Without a duration the ban
+// lasts until the client is unbanned.
+func banHandler(bans *web.BanList) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		var req struct {
+			Client   string `json:"client"`
+			Duration string `json:"duration"`
+		}
+		if err := json.NewDecoder(http.MaxBytesReader(w, r.Body, 1<<16)).Decode(&req); err != nil {
+			writeJSON(w, r, http.StatusBadRequest, map[string]string{"error": err.Error()})
+			return
+		}
+		if req.Client == "" {
+			writeJSON(w, r, http.StatusBadRequest, map[string]string{"error": "client is required"})
+			return
+		}
+		var d time.Duration
+		if req.Duration != "" {
+			var err error
+			if d, err = time.ParseDuration(req.Duration); err != nil || d < 0 {
+				writeJSON(w, r, http.StatusBadRequest, map[string]string{"error": "invalid duration " + req.Duration})
+				return
+			}
+		}
+		bans.Ban(req.Client, d)
+		if d > 0 {
+			logger.Info("Banned %s for %s", req.Client, d)
+		} else {
+			logger.Info("Banned %s", req.Client)
+		}
+		writeJSON(w, r, http.StatusCreated, bans.List())
+	}
+}
+
+func unbanHandler(bans *web.BanList) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		client := r.PathValue("client")
+		if !bans.Unban(client) {
+			writeJSON(w, r, http.StatusNotFound, map[string]string{"error": client + " is not banned"})
+			return
+		}
+		logger.Info("Unbanned %s", client)
+		w.WriteHeader(http.StatusNoContent)
+		logger.Access(r, http.StatusNoContent)
+	}
+}
+
+// writeJSON writes v as indented JSON with the status code.
+func writeJSON(w http.ResponseWriter, r *http.Request, code int, v any) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(code)
+	enc := json.NewEncoder(w)
+	enc.SetIndent("", "  ")
+	enc.Encode(v)
+	logger.Access(r, code)
+}
@@ -0,0 +1,160 @@
+package main
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/tuggan/goip/logger"
+	"github.com/tuggan/goip/web"
+	"golang.org/x/crypto/bcrypt"
+)
+
+func tokenHash(token string) string {
+	sum := sha256.Sum256([]byte(token))
+	return hex.EncodeToString(sum[:])
+}
+
+func testAdminConfig(t *testing.T) *adminConfig {
+	t.Helper()
+	hash, err := bcrypt.GenerateFromPassword([]byte("hunter2"), bcrypt.MinCost)
+	if err != nil {
+		t.Fatalf("failed to hash password: %v", err)
+	}
+	c, err := loadAdminConfig(configFromTOML(t, `
+adminEndpoint = "127.0.0.1:9000"
+[admin]
+tokens = ["sha256:`+tokenHash("s3cret")+`"]
+allow = ["127.0.0.1", "10.0.0.0/8"]
+[admin.users]
+alice = "`+string(hash)+`"
+`))
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	return c
+}
+
+func TestLoadAdminConfig(t *testing.T) {
+	if c, err := loadAdminConfig(configFromTOML(t, ``)); c != nil || err != nil {
+		t.Errorf("expected the admin listener to be disabled by default, got %v, %v", c, err)
+	}
+
+	c := testAdminConfig(t)
+	if c.Name != "admin" || c.Address != "127.0.0.1:9000" || len(c.Tokens) != 1 || len(c.Users) != 1 || len(c.Allow) != 2 {
+		t.Errorf("unexpected config %+v", c)
+	}
+
+	invalid := map[string]string{
+		"no credentials":   `adminEndpoint = "127.0.0.1:9000"`,
+		"bad token":        "adminEndpoint = \"127.0.0.1:9000\"\n[admin]\ntokens = [\"s3cret\"]",
+		"plain password":   "adminEndpoint = \"127.0.0.1:9000\"\n[admin.users]\nalice = \"hunter2\"",
+		"bad allow":        "adminEndpoint = \"127.0.0.1:9000\"\n[admin]\ntokens = [\"" + tokenHash("x") + "\"]\nallow = [\"nope\"]",
+		"key without cert": "adminEndpoint = \"127.0.0.1:9000\"\n[admin]\ntokens = [\"" + tokenHash("x") + "\"]\ntlsKey = \"admin.key\"",
+	}
+	for name, doc := range invalid {
+		if _, err := loadAdminConfig(configFromTOML(t, doc)); err == nil {
+			t.Errorf("%s: expected an error", name)
+		}
+	}
+}
+
+func TestAdminAuthMiddleware(t *testing.T) {
+	logger.Init(io.Discard, io.Discard, io.Discard, io.Discard)
+	c := testAdminConfig(t)
+	h := c.authMiddleware(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		io.WriteString(w, "ok")
+	}))
+
+	tests := []struct {
+		name       string
+		remoteAddr string
+		setup      func(r *http.Request)
+		code       int
+	}{
+		{"bearer token", "127.0.0.1:1234", func(r *http.Request) { r.Header.Set("Authorization", "Bearer s3cret") }, http.StatusOK},
+		{"wrong token", "127.0.0.1:1234", func(r *http.Request) { r.Header.Set("Authorization", "Bearer wrong") }, http.StatusUnauthorized},
+		{"basic auth", "10.1.2.3:1234", func(r *http.Request) { r.SetBasicAuth("alice", "hunter2") }, http.StatusOK},
+		{"wrong password", "10.1.2.3:1234", func(r *http.Request) { r.SetBasicAuth("alice", "wrong") }, http.StatusUnauthorized},
+		{"unknown user", "10.1.2.3:1234", func(r *http.Request) { r.SetBasicAuth("bob", "hunter2") }, http.StatusUnauthorized},
+		{"no credentials", "127.0.0.1:1234", func(r *http.Request) {}, http.StatusUnauthorized},
+		{"not allowed", "192.0.2.1:1234", func(r *http.Request) { r.Header.Set("Authorization", "Bearer s3cret") }, http.StatusForbidden},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req := httptest.NewRequest(http.MethodGet, "/metrics", nil)
+			req.RemoteAddr = tt.remoteAddr
+			tt.setup(req)
+			w := httptest.NewRecorder()
+			h.ServeHTTP(w, req)
+			if w.Code != tt.code {
+				t.Errorf("expected %d, got %d", tt.code, w.Code)
+			}
+			if w.Code == http.StatusUnauthorized && w.Header().Get("WWW-Authenticate") == "" {
+				t.Error("expected a WWW-Authenticate challenge")
+			}
+		})
+	}
+}
+
+func TestRedact(t *testing.T) {
+	got := redact(map[string]any{
+		"endpoint": "0.0.0.0:3000",
+		"admin": map[string]any{
+			"tokens": []string{"abc"},
+			"users":  map[string]any{"alice": "$2a$..."},
+			"allow":  []string{"127.0.0.1"},
+		},
+		"tracing": map[string]any{"headers": map[string]any{"authorization": "Bearer x"}},
+	})
+	admin := got["admin"].(map[string]any)
+	if admin["tokens"] != "REDACTED" || admin["users"] != "REDACTED" {
+		t.Errorf("expected credentials to be redacted, got %v", admin)
+	}
+	if got["tracing"].(map[string]any)["headers"] != "REDACTED" {
+		t.Errorf("expected tracing headers to be redacted, got %v", got["tracing"])
+	}
+	if got["endpoint"] != "0.0.0.0:3000" || admin["allow"] == "REDACTED" {
+		t.Errorf("expected other settings to be kept, got %v", got)
+	}
+}
+
+func TestAdminRoutes_Bans(t *testing.T) {
+	logger.Init(io.Discard, io.Discard, io.Discard, io.Discard)
+	bans := web.NewBanList()
+	mux := http.NewServeMux()
+	for pattern, h := range adminRoutes(&adminConfig{}, configFromTOML(t, ``), bans, nil) {
+		mux.HandleFunc(pattern, h)
+	}
+	do := func(method, target, body string) *httptest.ResponseRecorder {
+		w := httptest.NewRecorder()
+		mux.ServeHTTP(w, httptest.NewRequest(method, target, strings.NewReader(body)))
+		return w
+	}
+
+	if w := do(http.MethodPost, "/bans", `{"client": "192.0.2.1", "duration": "1h"}`); w.Code != http.StatusCreated {
+		t.Fatalf("expected 201, got %d: %s", w.Code, w.Body)
+	}
+	if !bans.Banned("192.0.2.1") {
+		t.Error("expected the client to be banned")
+	}
+	var list []web.Ban
+	json.NewDecoder(do(http.MethodGet, "/bans", "").Body).Decode(&list)
+	if len(list) != 1 || list[0].Client != "192.0.2.1" {
+		t.Errorf("unexpected ban list %+v", list)
+	}
+	if w := do(http.MethodPost, "/bans", `{"client": "192.0.2.2", "duration": "soon"}`); w.Code != http.StatusBadRequest {
+		t.Errorf("expected 400 for an invalid duration, got %d", w.Code)
+	}
+	if w := do(http.MethodDelete, "/bans/192.0.2.1", ""); w.Code != http.StatusNoContent {
+		t.Errorf("expected 204, got %d", w.Code)
+	}
+	if w := do(http.MethodDelete, "/bans/192.0.2.1", ""); w.Code != http.StatusNotFound {
+		t.Errorf("expected 404 for a client that is not banned, got %d", w.Code)
+	}
+}
@@ -0,0 +1,29 @@
+package main
+
+import (
+	"embed"
+	"io/fs"
+	"os"
+
+	"github.com/tuggan/goip/web"
+)
+
+// The default templates and files, so the binary works without html/ on
+// disk.
+//
+//go:embed html
+var embeddedHTML embed.FS
+
+// templateFS returns the file system the handlers read templates and files
+// from. Files in dir override the built in ones with the same name, so a
+// template directory only has to contain the files it changes.
+func templateFS(dir string) fs.FS {
+	builtin, err := fs.Sub(embeddedHTML, "html")
+	if err != nil {
+		panic(err)
+	}
+	if dir == "" {
+		return builtin
+	}
+	return web.NewOverlayFS(os.DirFS(dir), builtin)
+}
@@ -0,0 +1,72 @@
+package main
+
+import (
+	"io/fs"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/tuggan/goip/web"
+)
+
+func TestTemplateFS_Builtin(t *testing.T) {
+	for _, dir := range []string{"", filepath.Join(t.TempDir(), "missing")} {
+		fsys := templateFS(dir)
+		if err := web.CheckTemplates(fsys); err != nil {
+			t.Errorf("templateFS(%q): expected built in templates to parse, got %v", dir, err)
+		}
+		for _, name := range []string{"static/favicon.ico", "static/robots.txt"} {
+			if _, err := fs.Stat(fsys, name); err != nil {
+				t.Errorf("templateFS(%q): expected built in %s, got %v", dir, name, err)
+			}
+		}
+	}
+}
+
+func TestTemplateFS_Override(t *testing.T) {
+	dir := t.TempDir()
+	if err := os.Mkdir(filepath.Join(dir, "static"), 0o755); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(filepath.Join(dir, "static", "robots.txt"), []byte("User-agent: *\nDisallow: /\n"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	fsys := templateFS(dir)
+
+	b, err := fs.ReadFile(fsys, "static/robots.txt")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.Contains(string(b), "Disallow: /") {
+		t.Errorf("expected robots.txt from the template directory, got %q", b)
+	}
+	if err := web.CheckTemplates(fsys); err != nil {
+		t.Errorf("expected templates missing from the directory to fall back, got %v", err)
+	}
+}
+
+func TestTemplateFS_PartialLocales(t *testing.T) {
+	dir := t.TempDir()
+	if err := os.Mkdir(filepath.Join(dir, "locales"), 0o755); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(filepath.Join(dir, "locales", "de.json"), []byte(`{"index.yourIP": "Deine IP-Adresse"}`), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	tmpls, err := web.LoadTemplates(templateFS(dir))
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, lang := range []string{"en", "sv", "de"} {
+		if !tmpls.HasLanguage(lang) {
+			t.Errorf("expected a catalog for %s", lang)
+		}
+	}
+	if got := tmpls.Translator(web.DefaultLanguage)("index.yourIP"); got != "Your IP address" {
+		t.Errorf("expected the built in English catalog to be kept, got %q", got)
+	}
+	if got := tmpls.Translator("de")("index.yourIP"); got != "Deine IP-Adresse" {
+		t.Errorf("expected the catalog from the template directory, got %q", got)
+	}
+}
@@ -0,0 +1,275 @@
+package main
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/fsnotify/fsnotify"
+	"github.com/spf13/viper"
+	"github.com/tuggan/goip/logger"
+)
+
+// certExpiryWarning is how long before expiry a certificate is warned
+// about. Let's Encrypt certificates are renewed 30 days before they
+// expire, so one this close has missed its renewal.
+const certExpiryWarning = 14 * 24 * time.Hour
+
+// certReloadDelay is how long the certificate watcher waits for further
+// changes before reloading, as the certificate and key are often written
+// one after the other.
+const certReloadDelay = 500 * time.Millisecond
+
+// certPair is a certificate chain file and its private key file.
+type certPair struct {
+	Cert string
+	Key  string
+}
+
+// loadCertPairs reads the [[certificate]] tables of v.
+func loadCertPairs(v *viper.Viper) ([]certPair, error) {
+	tables, err := configTables(v, "certificate")
+	if err != nil {
+		return nil, err
+	}
+	var pairs []certPair
+	for i, t := range tables {
+		p := certPair{Cert: t.GetString("cert"), Key: t.GetString("key")}
+		if p.Cert == "" || p.Key == "" {
+			return nil, fmt.Errorf("certificate %d: both cert and key must be set", i)
+		}
+		pairs = append(pairs, p)
+	}
+	return pairs, nil
+}
+
+// loadCertPool reads the PEM encoded certificates in file, such as a CA
+// bundle, into a pool.
+func loadCertPool(file string) (*x509.CertPool, error) {
+	pem, err := os.ReadFile(file)
+	if err != nil {
+		return nil, err
+	}
+	pool := x509.NewCertPool()
+	if !pool.AppendCertsFromPEM(pem) {
+		return nil, fmt.Errorf("no certificates found in %s", file)
+	}
+	return pool, nil
+}
+
+// certStore holds the certificates of a TLS listener and picks the one to
+// serve by the server name the client asks for. It is safe for concurrent
+// use.
+type certStore struct {
+	name  string
+	pairs []certPair
+
+	mu    sync.RWMutex
+	certs []*tls.Certificate
+	// names maps the DNS names of the certificates, including wildcards
+	// such as "*.example.com", to the certificates valid for them.
+	names map[string][]*tls.Certificate
+	// err is the error of the last reload, which kept the certificates
+	// loaded before it.
+	err error
+}
+
+// newCertStore loads the certificates of the listener name. The first pair
+// is served to clients that send no or an unknown server name.
+func newCertStore(name string, pairs []certPair) (*certStore, error) {
+	s := &certStore{name: name, pairs: pairs}
+	if err := s.Reload(); err != nil {
+		return nil, fmt.Errorf("listener %q: %w", name, err)
+	}
+	return s, nil
+}
+
+// Reload loads every certificate again. If one of them fails to load the
+// previously loaded certificates are kept.
+func (s *certStore) Reload() error {
+	certs := make([]*tls.Certificate, 0, len(s.pairs))
+	names := make(map[string][]*tls.Certificate)
+	for _, p := range s.pairs {
+		cert, err := tls.LoadX509KeyPair(p.Cert, p.Key)
+		if err != nil {
+			s.mu.Lock()
+			s.err = err
+			s.mu.Unlock()
+			return err
+		}
+		certs = append(certs, &cert)
+		for _, n := range certNames(cert.Leaf) {
+			names[n] = append(names[n], &cert)
+		}
+	}
+	s.mu.Lock()
+	s.certs = certs
+	s.names = names
+	s.err = nil
+	s.mu.Unlock()
+	s.warnExpiry(time.Now())
+	return nil
+}
+
+// certNames returns the lower case DNS names leaf is valid for. The common
+// name only counts for certificates without DNS names.
+func certNames(leaf *x509.Certificate) []string {
+	names := leaf.DNSNames
+	if len(names) == 0 && leaf.Subject.CommonName != "" {
+		names = []string{leaf.Subject.CommonName}
+	}
+	lower := make([]string, len(names))
+	for i, n := range names {
+		lower[i] = strings.ToLower(n)
+	}
+	return lower
+}
+
+// GetCertificate is the tls.Config callback choosing the certificate for
+// hello. Among the certificates for the server name the first one the
+// client supports wins, so e.g. an ECDSA and an RSA certificate can be
+// served for the same name.
+func (s *certStore) GetCertificate(hello *tls.ClientHelloInfo) (*tls.Certificate, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	if len(s.certs) == 0 {
+		return nil, errors.New("no certificates loaded")
+	}
+	name := strings.ToLower(strings.TrimSuffix(hello.ServerName, "."))
+	candidates := s.names[name]
+	if i := strings.IndexByte(name, '.'); len(candidates) == 0 && i > 0 {
+		candidates = s.names["*"+name[i:]]
+	}
+	for _, c := range candidates {
+		if hello.SupportsCertificate(c) == nil {
+			return c, nil
+		}
+	}
+	if len(candidates) > 0 {
+		return candidates[0], nil
+	}
+	return s.certs[0], nil
+}
+
+// leaves returns the loaded certificates and the error of the last reload.
+func (s *certStore) leaves() ([]*x509.Certificate, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	leaves := make([]*x509.Certificate, len(s.certs))
+	for i, c := range s.certs {
+		leaves[i] = c.Leaf
+	}
+	return leaves, s.err
+}
+
+// warnExpiry logs a warning for every certificate expiring within
+// certExpiryWarning of now.
+func (s *certStore) warnExpiry(now time.Time) {
+	leaves, _ := s.leaves()
+	for _, leaf := range leaves {
+		if left := leaf.NotAfter.Sub(now); left < certExpiryWarning {
+			logger.Warning("Listener %s: certificate for %s expires at %s, in %s",
+				s.name, strings.Join(certNames(leaf), ", "), leaf.NotAfter.Format(time.RFC3339), left.Round(time.Minute))
+		}
+	}
+}
+
+// Watch reloads the certificates whenever one of their files changes and
+// checks them for expiry once a day. The directories of the files are
+// watched, so certificates replaced by renaming or by updating a symlink,
+// like certbot and Kubernetes secrets do, are picked up too. Closing the
+// returned watcher stops it and waits for a reload in progress.
+func (s *certStore) Watch() (io.Closer, error) {
+	w, err := fsnotify.NewWatcher()
+	if err != nil {
+		return nil, err
+	}
+	var dirs []string
+	files := make(map[string]bool)
+	for _, p := range s.pairs {
+		for _, f := range []string{p.Cert, p.Key} {
+			files[filepath.Clean(f)] = true
+			if dir := filepath.Dir(f); !slices.Contains(dirs, dir) {
+				dirs = append(dirs, dir)
+			}
+		}
+	}
+	for _, dir := range dirs {
+		if err := w.Add(dir); err != nil && !errors.Is(err, os.ErrNotExist) {
+			w.Close()
+			return nil, err
+		}
+	}
+
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		daily := time.NewTicker(24 * time.Hour)
+		defer daily.Stop()
+		var reload <-chan time.Time
+		for {
+			select {
+			case ev, ok := <-w.Events:
+				if !ok {
+					return
+				}
+				if ev.Has(fsnotify.Chmod) && !ev.Has(fsnotify.Write) {
+					continue
+				}
+				// Other files in the directories are ignored, except
+				// for the "..data" symlink Kubernetes swaps to update
+				// a mounted secret.
+				if !files[filepath.Clean(ev.Name)] && !strings.HasPrefix(filepath.Base(ev.Name), "..") {
+					continue
+				}
+				reload = time.After(certReloadDelay)
+			case <-reload:
+				reload = nil
+				if err := s.Reload(); err != nil {
+					logger.Error("Listener %s: failed to reload certificates: %v", s.name, err)
+					continue
+				}
+				logger.Info("Listener %s: reloaded certificates", s.name)
+			case now := <-daily.C:
+				s.warnExpiry(now)
+			case err, ok := <-w.Errors:
+				if !ok {
+					return
+				}
+				logger.Warning("Listener %s: certificate watcher: %v", s.name, err)
+			}
+		}
+	}()
+	return closerFunc(func() error {
+		err := w.Close()
+		<-done
+		return err
+	}), nil
+}
+
+// closerFunc turns a function into an io.Closer.
+type closerFunc func() error
+
+func (f closerFunc) Close() error { return f() }
+
+// reloadCertificates reloads the certificates of every listener.
+func reloadCertificates(listeners []*listener) {
+	for _, l := range listeners {
+		if l.certs == nil {
+			continue
+		}
+		if err := l.certs.Reload(); err != nil {
+			logger.Error("Listener %s: failed to reload certificates: %v", l.cfg.Name, err)
+			continue
+		}
+		logger.Info("Listener %s: reloaded certificates", l.cfg.Name)
+	}
+}
@@ -0,0 +1,316 @@
+package main
+
+import (
+	"context"
+	"crypto/tls"
+	"encoding/json"
+	"io"
+	"net"
+	"net/http"
+	"os"
+	"slices"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/tuggan/goip/logger"
+)
+
+func TestLoadListeners_Certificates(t *testing.T) {
+	v := configFromTOML(t, `
+tlsEndpoint = ["127.0.0.1:443"]
+tlsCert = "main.crt"
+tlsKey = "main.key"
+
+[[certificate]]
+cert = "other.crt"
+key = "other.key"
+
+[[listener]]
+name = "internal"
+address = "127.0.0.1:8443"
+[[listener.certificate]]
+cert = "internal.crt"
+key = "internal.key"
+`)
+	listeners, err := loadListeners(v)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := map[string][]certPair{
+		"https":    {{"main.crt", "main.key"}, {"other.crt", "other.key"}},
+		"internal": {{"main.crt", "main.key"}, {"internal.crt", "internal.key"}},
+	}
+	for _, l := range listeners {
+		if !l.isTLS() || !slices.Equal(l.certPairs(), want[l.Name]) {
+			t.Errorf("listener %s: expected certificates %v, got %v", l.Name, want[l.Name], l.certPairs())
+		}
+	}
+
+	for _, doc := range []string{
+		"tlsEndpoint = [\"127.0.0.1:443\"]\n[[certificate]]\ncert = \"a.crt\"\n",
+		"tlsEndpoint = [\"127.0.0.1:443\"]\ntlsCert = \"a.crt\"\n[[certificate]]\ncert = \"b.crt\"\nkey = \"b.key\"\n",
+	} {
+		if _, err := loadListeners(configFromTOML(t, doc)); err == nil {
+			t.Errorf("expected an error for:\n%s", doc)
+		}
+	}
+
+	// [[certificate]] tables alone are enough.
+	listeners, err = loadListeners(configFromTOML(t, `
+tlsEndpoint = ["127.0.0.1:443"]
+[[certificate]]
+cert = "a.crt"
+key = "a.key"
+`))
+	if err != nil || len(listeners[len(listeners)-1].certPairs()) != 1 {
+		t.Errorf("expected a listener with one certificate, got %v, %v", listeners, err)
+	}
+}
+
+func TestCertStore_SNI(t *testing.T) {
+	logger.Init(io.Discard, io.Discard, io.Discard, io.Discard)
+	now := time.Now()
+	aCert, aKey := writeTestCert(t, now.Add(-time.Hour), now.Add(90*24*time.Hour), "a.example.com")
+	bCert, bKey := writeTestCert(t, now.Add(-time.Hour), now.Add(90*24*time.Hour), "*.b.example.com", "b.example.com")
+	s, err := newCertStore("https", []certPair{{aCert, aKey}, {bCert, bKey}})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for name, want := range map[string]string{
+		"a.example.com":      "a.example.com",
+		"A.Example.Com.":     "a.example.com",
+		"b.example.com":      "*.b.example.com",
+		"ip.b.example.com":   "*.b.example.com",
+		"x.ip.b.example.com": "a.example.com",
+		"other.example":      "a.example.com",
+		"":                   "a.example.com",
+	} {
+		cert, err := s.GetCertificate(&tls.ClientHelloInfo{ServerName: name})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got := cert.Leaf.DNSNames[0]; got != want {
+			t.Errorf("%q: expected the certificate for %s, got %s", name, want, got)
+		}
+	}
+
+	if _, err := newCertStore("https", []certPair{{aCert, bKey}}); err == nil {
+		t.Error("expected a key not matching the certificate to fail")
+	}
+}
+
+func TestCertStore_Watch(t *testing.T) {
+	logger.Init(io.Discard, io.Discard, io.Discard, io.Discard)
+	now := time.Now()
+	certFile, keyFile := writeTestCert(t, now.Add(-time.Hour), now.Add(90*24*time.Hour), "old.example.com")
+	s, err := newCertStore("https", []certPair{{certFile, keyFile}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	w, err := s.Watch()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer w.Close()
+
+	newCert, newKey := writeTestCert(t, now.Add(-time.Hour), now.Add(90*24*time.Hour), "new.example.com")
+	for _, f := range [][2]string{{newCert, certFile}, {newKey, keyFile}} {
+		b, err := os.ReadFile(f[0])
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(f[1], b, 0o600); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	deadline := time.Now().Add(5 * time.Second)
+	for {
+		leaves, _ := s.leaves()
+		if leaves[0].DNSNames[0] == "new.example.com" {
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatal("expected the changed certificate to be reloaded")
+		}
+		time.Sleep(50 * time.Millisecond)
+	}
+}
+
+func TestNewListener_TLS(t *testing.T) {
+	logger.Init(io.Discard, io.Discard, io.Discard, io.Discard)
+	now := time.Now()
+	certFile, keyFile := writeTestCert(t, now.Add(-time.Hour), now.Add(90*24*time.Hour), "ip.example.com")
+	themes, err := loadThemes(configFromTOML(t, ``), templateFS(""), nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	l, err := newListener(listenerConfig{Name: "https", Address: "127.0.0.1:0", TLS: true, TLSCert: certFile, TLSKey: keyFile},
+		handlerOptions{themes: themes, staticDir: "static"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer l.rl.Stop()
+
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	var wg sync.WaitGroup
+	wg.Add(1)
+	go serve(&wg, make(chan error, 1), l.srv, ln)
+	defer func() {
+		l.srv.Shutdown(context.Background())
+		wg.Wait()
+	}()
+
+	client := &http.Client{Transport: &http.Transport{
+		TLSClientConfig: &tls.Config{ServerName: "ip.example.com", InsecureSkipVerify: true},
+	}}
+	defer client.CloseIdleConnections()
+	resp, err := client.Get("https://" + ln.Addr().String() + "/ip")
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+	if names := resp.TLS.PeerCertificates[0].DNSNames; !slices.Equal(names, []string{"ip.example.com"}) {
+		t.Errorf("expected the configured certificate, got %v", names)
+	}
+}
+
+func TestLoadListeners_ClientAuth(t *testing.T) {
+	v := configFromTOML(t, `
+tlsEndpoint = ["127.0.0.1:443"]
+tlsCert = "main.crt"
+tlsKey = "main.key"
+tlsClientAuth = "request"
+
+[[listener]]
+name = "mesh"
+address = "127.0.0.1:8443"
+tls = true
+tlsClientCA = "mesh-ca.pem"
+
+[[listener]]
+name = "optional"
+address = "127.0.0.1:9443"
+tls = true
+tlsClientCA = "mesh-ca.pem"
+tlsClientAuth = "verify-if-given"
+`)
+	listeners, err := loadListeners(v)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := map[string]tls.ClientAuthType{
+		"https":    tls.RequestClientCert,
+		"mesh":     tls.RequestClientCert,
+		"optional": tls.VerifyClientCertIfGiven,
+	}
+	for _, l := range listeners {
+		if l.ClientAuth != want[l.Name] {
+			t.Errorf("listener %s: expected client auth %s, got %s", l.Name, want[l.Name], l.ClientAuth)
+		}
+	}
+
+	// A CA alone verifies client certificates.
+	listeners, err = loadListeners(configFromTOML(t, `
+tlsEndpoint = ["127.0.0.1:443"]
+tlsCert = "main.crt"
+tlsKey = "main.key"
+tlsClientCA = "ca.pem"
+`))
+	if err != nil || listeners[len(listeners)-1].ClientAuth != tls.RequireAndVerifyClientCert {
+		t.Errorf("expected client certificates to be verified, got %v, %v", listeners, err)
+	}
+
+	for _, doc := range []string{
+		"tlsEndpoint = [\"127.0.0.1:443\"]\ntlsCert = \"a.crt\"\ntlsKey = \"a.key\"\ntlsClientAuth = \"always\"\n",
+		"tlsEndpoint = [\"127.0.0.1:443\"]\ntlsCert = \"a.crt\"\ntlsKey = \"a.key\"\ntlsClientAuth = \"verify\"\n",
+	} {
+		if _, err := loadListeners(configFromTOML(t, doc)); err == nil {
+			t.Errorf("expected an error for:\n%s", doc)
+		}
+	}
+}
+
+func TestNewListener_ClientAuth(t *testing.T) {
+	logger.Init(io.Discard, io.Discard, io.Discard, io.Discard)
+	now := time.Now()
+	certFile, keyFile := writeTestCert(t, now.Add(-time.Hour), now.Add(90*24*time.Hour), "ip.example.com")
+	clientCert, clientKey := writeTestCert(t, now.Add(-time.Hour), now.Add(90*24*time.Hour), "client.example.com")
+	otherCert, otherKey := writeTestCert(t, now.Add(-time.Hour), now.Add(90*24*time.Hour), "other.example.com")
+	themes, err := loadThemes(configFromTOML(t, ``), templateFS(""), nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// The self-signed client certificate is its own CA.
+	l, err := newListener(listenerConfig{Name: "https", Address: "127.0.0.1:0", TLS: true, TLSCert: certFile, TLSKey: keyFile,
+		TLSPolicy: defaultTLSPolicyConfig(), ClientAuth: tls.RequireAndVerifyClientCert, ClientCA: clientCert},
+		handlerOptions{themes: themes, staticDir: "static"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer l.rl.Stop()
+
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	var wg sync.WaitGroup
+	wg.Add(1)
+	go serve(&wg, make(chan error, 1), l.srv, ln)
+	defer func() {
+		l.srv.Shutdown(context.Background())
+		wg.Wait()
+	}()
+
+	get := func(certFile, keyFile string) (*http.Response, error) {
+		cfg := &tls.Config{ServerName: "ip.example.com", InsecureSkipVerify: true}
+		if certFile != "" {
+			cert, err := tls.LoadX509KeyPair(certFile, keyFile)
+			if err != nil {
+				t.Fatal(err)
+			}
+			cfg.Certificates = []tls.Certificate{cert}
+		}
+		client := &http.Client{Transport: &http.Transport{TLSClientConfig: cfg}}
+		defer client.CloseIdleConnections()
+		return client.Get("https://" + ln.Addr().String() + "/tls/client-certificate")
+	}
+
+	resp, err := get(clientCert, clientKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+	var report struct {
+		Presented    bool `json:"presented"`
+		Verified     bool `json:"verified"`
+		Certificates []struct {
+			Subject  string   `json:"subject"`
+			DNSNames []string `json:"dnsNames"`
+		} `json:"certificates"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&report); err != nil {
+		t.Fatal(err)
+	}
+	if !report.Presented || !report.Verified || len(report.Certificates) != 1 ||
+		report.Certificates[0].Subject != "CN=client.example.com" {
+		t.Errorf("unexpected report %+v", report)
+	}
+
+	for _, pair := range [][2]string{{"", ""}, {otherCert, otherKey}} {
+		if resp, err := get(pair[0], pair[1]); err == nil {
+			resp.Body.Close()
+			t.Errorf("expected %q to be refused", pair[0])
+		}
+	}
+
+	if _, err := newListener(listenerConfig{Name: "https", Address: "127.0.0.1:0", TLS: true, TLSCert: certFile, TLSKey: keyFile,
+		ClientAuth: tls.RequireAndVerifyClientCert, ClientCA: keyFile}, handlerOptions{themes: themes, staticDir: "static"}); err == nil {
+		t.Error("expected a client CA without certificates to fail")
+	}
+}
@@ -0,0 +1,107 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+	"sync/atomic"
+	"time"
+
+	"github.com/spf13/viper"
+	"github.com/tuggan/goip/health"
+	"github.com/tuggan/goip/web"
+)
+
+// readinessState is the process state the readiness checks look at.
+type readinessState struct {
+	configErr error
+	themes    *web.Themes
+	listeners []*listener
+	bound     *atomic.Bool
+	draining  *atomic.Bool
+}
+
+// registerLivenessChecks adds the checks that fail only when the process
+// has to be restarted.
+func registerLivenessChecks(r *health.Registry) {
+	r.Register("ping", func(ctx context.Context) error { return nil })
+}
+
+// registerReadinessChecks adds the checks that fail while the process
+// should not receive traffic.
+func registerReadinessChecks(r *health.Registry, s readinessState) {
+	r.Register("ping", func(ctx context.Context) error { return nil })
+	r.Register("config", func(ctx context.Context) error {
+		return configCheck(s.configErr)
+	})
+	r.Register("templates", func(ctx context.Context) error {
+		return s.themes.Check()
+	})
+	r.Register("tls", func(ctx context.Context) error {
+		return certCheck(s.listeners, time.Now())
+	})
+	r.Register("listeners", func(ctx context.Context) error {
+		if !s.bound.Load() {
+			return errors.New("not all listeners are bound")
+		}
+		return nil
+	})
+	r.Register("ratelimit", func(ctx context.Context) error {
+		for _, l := range s.listeners {
+			if err := l.rl.Check(ctx); err != nil {
+				return fmt.Errorf("listener %s: %w", l.cfg.Name, err)
+			}
+		}
+		return nil
+	})
+	r.Register("shutdown", func(ctx context.Context) error {
+		if s.draining.Load() {
+			return errors.New("draining")
+		}
+		return nil
+	})
+}
+
+// configCheck fails when the config file exists but could not be read.
+// Running without a config file is fine.
+func configCheck(err error) error {
+	if err == nil || errors.As(err, &viper.ConfigFileNotFoundError{}) {
+		return nil
+	}
+	return err
+}
+
+// certCheck fails when the certificates of a TLS listener could not be
+// reloaded, or one is outside its validity period at now. Certificates
+// expiring within certExpiryWarning are reported as a warning. Certificates
+// obtained through ACME are renewed by the certificate manager and are not
+// checked.
+func certCheck(listeners []*listener, now time.Time) error {
+	var warnings []string
+	for _, l := range listeners {
+		if l.certs == nil {
+			continue
+		}
+		leaves, err := l.certs.leaves()
+		if err != nil {
+			return fmt.Errorf("listener %s: %w", l.cfg.Name, err)
+		}
+		for _, leaf := range leaves {
+			if now.Before(leaf.NotBefore) {
+				return fmt.Errorf("listener %s: certificate not valid before %s", l.cfg.Name, leaf.NotBefore.Format(time.RFC3339))
+			}
+			if now.After(leaf.NotAfter) {
+				return fmt.Errorf("listener %s: certificate expired at %s", l.cfg.Name, leaf.NotAfter.Format(time.RFC3339))
+			}
+			if leaf.NotAfter.Sub(now) < certExpiryWarning {
+				warnings = append(warnings, fmt.Sprintf("listener %s: certificate for %s expires at %s",
+					l.cfg.Name, strings.Join(certNames(leaf), ", "), leaf.NotAfter.Format(time.RFC3339)))
+			}
+		}
+	}
+	if len(warnings) > 0 {
+		return health.Warning(strings.Join(warnings, "; "))
+	}
+	return nil
+}
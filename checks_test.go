@@ -0,0 +1,117 @@
+package main
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"errors"
+	"io"
+	"math/big"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/spf13/viper"
+	"github.com/tuggan/goip/health"
+	"github.com/tuggan/goip/logger"
+)
+
+// writeTestCert writes a self-signed certificate for dnsNames, localhost
+// if none are given, valid between notBefore and notAfter and returns the
+// certificate and key paths.
+func writeTestCert(t *testing.T, notBefore, notAfter time.Time, dnsNames ...string) (string, string) {
+	t.Helper()
+	if len(dnsNames) == 0 {
+		dnsNames = []string{"localhost"}
+	}
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatalf("failed to generate key: %v", err)
+	}
+	tmpl := &x509.Certificate{
+		SerialNumber: big.NewInt(1),
+		Subject:      pkix.Name{CommonName: dnsNames[0]},
+		DNSNames:     dnsNames,
+		NotBefore:    notBefore,
+		NotAfter:     notAfter,
+	}
+	der, err := x509.CreateCertificate(rand.Reader, tmpl, tmpl, &key.PublicKey, key)
+	if err != nil {
+		t.Fatalf("failed to create certificate: %v", err)
+	}
+	keyDER, err := x509.MarshalECPrivateKey(key)
+	if err != nil {
+		t.Fatalf("failed to marshal key: %v", err)
+	}
+	dir := t.TempDir()
+	certFile := filepath.Join(dir, "cert.pem")
+	keyFile := filepath.Join(dir, "key.pem")
+	os.WriteFile(certFile, pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der}), 0600)
+	os.WriteFile(keyFile, pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: keyDER}), 0600)
+	return certFile, keyFile
+}
+
+func TestCertCheck(t *testing.T) {
+	logger.Init(io.Discard, io.Discard, io.Discard, io.Discard)
+	now := time.Now()
+	validCert, validKey := writeTestCert(t, now.Add(-time.Hour), now.Add(90*24*time.Hour))
+	expiringCert, expiringKey := writeTestCert(t, now.Add(-time.Hour), now.Add(time.Hour))
+	expiredCert, expiredKey := writeTestCert(t, now.Add(-2*time.Hour), now.Add(-time.Hour))
+	missingCert, missingKey := writeTestCert(t, now.Add(-time.Hour), now.Add(90*24*time.Hour))
+
+	tests := []struct {
+		name      string
+		cert, key string
+		remove    bool
+		wantErr   string
+	}{
+		{"valid", validCert, validKey, false, ""},
+		{"expiring", expiringCert, expiringKey, false, "expires at"},
+		{"expired", expiredCert, expiredKey, false, "expired"},
+		{"missing", missingCert, missingKey, true, "no such file"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			certs, err := newCertStore("https", []certPair{{Cert: tt.cert, Key: tt.key}})
+			if err != nil {
+				t.Fatal(err)
+			}
+			if tt.remove {
+				// The certificates loaded before are kept, but the
+				// failed reload fails the check.
+				os.Remove(tt.cert)
+				if certs.Reload() == nil {
+					t.Fatal("expected the reload to fail")
+				}
+			}
+			l := &listener{cfg: listenerConfig{Name: "https", TLS: true}, certs: certs}
+			err = certCheck([]*listener{l}, now)
+			if tt.name == "expiring" && !errors.As(err, new(health.Warning)) {
+				t.Errorf("expected a warning, got %v", err)
+			}
+			if tt.wantErr == "" {
+				if err != nil {
+					t.Errorf("unexpected error: %v", err)
+				}
+				return
+			}
+			if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+				t.Errorf("expected error containing %q, got %v", tt.wantErr, err)
+			}
+		})
+	}
+}
+
+func TestConfigCheck(t *testing.T) {
+	if err := configCheck(viper.ConfigFileNotFoundError{}); err != nil {
+		t.Errorf("expected a missing config file to be fine, got %v", err)
+	}
+	if err := configCheck(errors.New("parse error")); err == nil {
+		t.Error("expected a broken config file to fail the check")
+	}
+}
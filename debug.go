@@ -0,0 +1,142 @@
+package main
+
+import (
+	"expvar"
+	"net"
+	"net/http"
+	"net/http/pprof"
+	"runtime"
+	rpprof "runtime/pprof"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/tuggan/goip/logger"
+)
+
+// startTime is used to report the uptime.
+var startTime = time.Now()
+
+// trackConns makes srv count its open connections. Hijacked connections
+// leave the count, enableH2C counts the ones it upgrades itself.
+func trackConns(srv *http.Server) *atomic.Int64 {
+	open := new(atomic.Int64)
+	srv.ConnState = func(c net.Conn, state http.ConnState) {
+		switch state {
+		case http.StateNew:
+			open.Add(1)
+		case http.StateHijacked, http.StateClosed:
+			open.Add(-1)
+		}
+	}
+	return open
+}
+
+// runtimeStats is the JSON served on /debug/runtime and published as the
+// "goip" expvar.
+type runtimeStats struct {
+	GoVersion  string          `json:"goVersion"`
+	Uptime     string          `json:"uptime"`
+	Goroutines int             `json:"goroutines"`
+	GOMAXPROCS int             `json:"gomaxprocs"`
+	NumCPU     int             `json:"numCPU"`
+	Heap       heapStats       `json:"heap"`
+	GC         gcStats         `json:"gc"`
+	Listeners  []listenerStats `json:"listeners"`
+}
+
+type heapStats struct {
+	Alloc    uint64 `json:"alloc"`
+	Sys      uint64 `json:"sys"`
+	InUse    uint64 `json:"inUse"`
+	Objects  uint64 `json:"objects"`
+	Released uint64 `json:"released"`
+}
+
+type gcStats struct {
+	Count      uint32    `json:"count"`
+	PauseTotal string    `json:"pauseTotal"`
+	LastGC     time.Time `json:"lastGC,omitzero"`
+	NextGC     uint64    `json:"nextGC"`
+	CPUFrac    float64   `json:"cpuFraction"`
+}
+
+type listenerStats struct {
+	Name        string `json:"name"`
+	Address     string `json:"address"`
+	Connections int64  `json:"connections"`
+	Visitors    int    `json:"visitors"`
+}
+
+func collectRuntimeStats(listeners []*listener) runtimeStats {
+	var m runtime.MemStats
+	runtime.ReadMemStats(&m)
+	s := runtimeStats{
+		GoVersion:  runtime.Version(),
+		Uptime:     time.Since(startTime).Round(time.Second).String(),
+		Goroutines: runtime.NumGoroutine(),
+		GOMAXPROCS: runtime.GOMAXPROCS(0),
+		NumCPU:     runtime.NumCPU(),
+		Heap: heapStats{
+			Alloc:    m.HeapAlloc,
+			Sys:      m.HeapSys,
+			InUse:    m.HeapInuse,
+			Objects:  m.HeapObjects,
+			Released: m.HeapReleased,
+		},
+		GC: gcStats{
+			Count:      m.NumGC,
+			PauseTotal: time.Duration(m.PauseTotalNs).String(),
+			NextGC:     m.NextGC,
+			CPUFrac:    m.GCCPUFraction,
+		},
+		Listeners: []listenerStats{},
+	}
+	if m.LastGC > 0 {
+		s.GC.LastGC = time.Unix(0, int64(m.LastGC))
+	}
+	for _, l := range listeners {
+		ls := listenerStats{Name: l.cfg.Name, Address: l.cfg.url(), Visitors: l.rl.Visitors()}
+		if l.conns != nil {
+			ls.Connections = l.conns.Load()
+		}
+		s.Listeners = append(s.Listeners, ls)
+	}
+	return s
+}
+
+// publishExpvar is guarded so the "goip" variable is only published once,
+// expvar panics on duplicates.
+var publishExpvar sync.Once
+
+// debugRoutes returns the profiling and debug endpoints served on the
+// admin listener when admin.debug is enabled. listeners is called on every
+// request since the listener list is built after the routes.
+func debugRoutes(listeners func() []*listener) map[string]http.HandlerFunc {
+	publishExpvar.Do(func() {
+		expvar.Publish("goip", expvar.Func(func() any {
+			return collectRuntimeStats(listeners())
+		}))
+	})
+	return map[string]http.HandlerFunc{
+		"GET /debug/pprof/":        pprof.Index,
+		"GET /debug/pprof/cmdline": pprof.Cmdline,
+		"GET /debug/pprof/profile": pprof.Profile,
+		"GET /debug/pprof/symbol":  pprof.Symbol,
+		"POST /debug/pprof/symbol": pprof.Symbol,
+		"GET /debug/pprof/trace":   pprof.Trace,
+		"GET /debug/vars":          expvar.Handler().ServeHTTP,
+		"GET /debug/goroutines":    goroutinesHandler,
+		"GET /debug/runtime": func(w http.ResponseWriter, r *http.Request) {
+			writeJSON(w, r, http.StatusOK, collectRuntimeStats(listeners()))
+		},
+	}
+}
+
+// goroutinesHandler dumps the stack of every goroutine in the format of an
+// unrecovered panic.
+func goroutinesHandler(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "text/plain; charset=utf-8")
+	rpprof.Lookup("goroutine").WriteTo(w, 2)
+	logger.Access(r, http.StatusOK)
+}
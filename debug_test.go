@@ -0,0 +1,88 @@
+package main
+
+import (
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/tuggan/goip/logger"
+	"github.com/tuggan/goip/web"
+)
+
+func TestTrackConns(t *testing.T) {
+	srv := httptest.NewUnstartedServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+	open := trackConns(srv.Config)
+	srv.Start()
+	defer srv.Close()
+
+	resp, err := http.Get(srv.URL)
+	if err != nil {
+		t.Fatalf("request failed: %v", err)
+	}
+	resp.Body.Close()
+	if n := open.Load(); n != 1 {
+		t.Errorf("expected one open keep-alive connection, got %d", n)
+	}
+	http.DefaultClient.CloseIdleConnections()
+	for i := 0; i < 100 && open.Load() != 0; i++ {
+		time.Sleep(10 * time.Millisecond)
+	}
+	if n := open.Load(); n != 0 {
+		t.Errorf("expected the connection to be closed, got %d open", n)
+	}
+}
+
+func TestAdminRoutes_Debug(t *testing.T) {
+	logger.Init(io.Discard, io.Discard, io.Discard, io.Discard)
+	l := &listener{
+		cfg: listenerConfig{Name: "public", Network: "tcp", Address: "127.0.0.1:3000"},
+		rl:  web.NewRateLimiter(1, 1, 0),
+	}
+	l.rl.Allow("192.0.2.1")
+	listeners := func() []*listener { return []*listener{l} }
+
+	if _, ok := adminRoutes(&adminConfig{}, configFromTOML(t, ``), web.NewBanList(), listeners)["GET /debug/runtime"]; ok {
+		t.Error("expected debug endpoints to be disabled by default")
+	}
+
+	mux := http.NewServeMux()
+	for pattern, h := range adminRoutes(&adminConfig{Debug: true}, configFromTOML(t, ``), web.NewBanList(), listeners) {
+		mux.HandleFunc(pattern, h)
+	}
+	get := func(target string) *httptest.ResponseRecorder {
+		w := httptest.NewRecorder()
+		mux.ServeHTTP(w, httptest.NewRequest(http.MethodGet, target, nil))
+		return w
+	}
+
+	var stats runtimeStats
+	if err := json.NewDecoder(get("/debug/runtime").Body).Decode(&stats); err != nil {
+		t.Fatalf("failed to decode runtime stats: %v", err)
+	}
+	if stats.Goroutines == 0 || len(stats.Listeners) != 1 || stats.Listeners[0].Visitors != 1 {
+		t.Errorf("unexpected runtime stats %+v", stats)
+	}
+	if body := get("/debug/goroutines").Body.String(); !strings.Contains(body, "goroutine ") {
+		t.Errorf("expected a goroutine dump, got %q", body)
+	}
+	if body := get("/debug/vars").Body.String(); !strings.Contains(body, `"goip"`) || !strings.Contains(body, `"memstats"`) {
+		t.Error("expected expvar output with the goip variable")
+	}
+	if w := get("/debug/pprof/"); w.Code != http.StatusOK {
+		t.Errorf("expected the pprof index, got %d", w.Code)
+	}
+}
+
+func TestLoadAdminConfig_Debug(t *testing.T) {
+	c, err := loadAdminConfig(configFromTOML(t, "adminEndpoint = \"127.0.0.1:9000\"\n[admin]\ndebug = true\ntokens = [\""+tokenHash("x")+"\"]"))
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if !c.Debug || c.Limits.WriteTimeout != 0 {
+		t.Errorf("expected debug with no write timeout, got debug=%v writeTimeout=%s", c.Debug, c.Limits.WriteTimeout)
+	}
+}
@@ -0,0 +1,71 @@
+package main
+
+import (
+	"bufio"
+	"net"
+	"net/http"
+	"sync/atomic"
+
+	"golang.org/x/net/http/httpguts"
+	"golang.org/x/net/http2"
+	"golang.org/x/net/http2/h2c"
+)
+
+// h2cMaxUpgradeBody limits the body of a request asking for an h2c
+// upgrade, which is read into memory before the connection switches to
+// HTTP/2.
+const h2cMaxUpgradeBody = 1 << 20
+
+// enableH2C makes the plain HTTP server srv speak HTTP/2 without TLS as
+// well, both to clients starting with the HTTP/2 preface and to clients
+// upgrading from HTTP/1.1 with "Upgrade: h2c". It must be called after
+// srv.Handler and the limits are set. Upgraded connections are counted in
+// conns, the count of trackConns, until they close.
+func enableH2C(srv *http.Server, limits serverLimits, conns *atomic.Int64) {
+	srv.Protocols = new(http.Protocols)
+	srv.Protocols.SetHTTP1(true)
+	srv.Protocols.SetUnencryptedHTTP2(true)
+
+	// net/http handles prior knowledge itself but not the upgrade, which
+	// RFC 9113 deprecated. Upgraded connections take the HTTP/2 settings
+	// from srv, only the idle timeout has to be passed on.
+	idle := limits.IdleTimeout
+	if idle == 0 {
+		idle = limits.ReadTimeout
+	}
+	h := h2c.NewHandler(srv.Handler, &http2.Server{IdleTimeout: idle})
+	srv.Handler = http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if !httpguts.HeaderValuesContainsToken(r.Header["Upgrade"], "h2c") {
+			h.ServeHTTP(w, r)
+			return
+		}
+		r.Body = http.MaxBytesReader(w, r.Body, h2cMaxUpgradeBody)
+		uw := &upgradeWriter{ResponseWriter: w, conns: conns}
+		h.ServeHTTP(uw, r)
+		// The upgraded connection is served until h returns.
+		if uw.hijacked {
+			conns.Add(-1)
+		}
+	})
+}
+
+// upgradeWriter counts the connection of an h2c upgrade in conns once it
+// is hijacked, which takes it out of the count of trackConns.
+type upgradeWriter struct {
+	http.ResponseWriter
+	conns    *atomic.Int64
+	hijacked bool
+}
+
+func (w *upgradeWriter) Hijack() (net.Conn, *bufio.ReadWriter, error) {
+	conn, rw, err := http.NewResponseController(w.ResponseWriter).Hijack()
+	if err == nil {
+		w.hijacked = true
+		w.conns.Add(1)
+	}
+	return conn, rw, err
+}
+
+func (w *upgradeWriter) Unwrap() http.ResponseWriter {
+	return w.ResponseWriter
+}
@@ -0,0 +1,255 @@
+package main
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/tuggan/goip/logger"
+	"golang.org/x/net/http2"
+)
+
+// startH2CListener serves a plain listener with h2c set as given and
+// returns its address.
+func startH2CListener(t *testing.T, enabled bool) string {
+	addr, _ := startH2CServer(t, enabled)
+	return addr
+}
+
+// startH2CServer is startH2CListener also returning the listener.
+func startH2CServer(t *testing.T, enabled bool) (string, *listener) {
+	t.Helper()
+	themes, err := loadThemes(configFromTOML(t, ``), templateFS(""), nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	l, err := newListener(listenerConfig{Name: "http", Address: "127.0.0.1:0", H2C: enabled, Limits: defaultServerLimits()},
+		handlerOptions{themes: themes, staticDir: "static"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	var wg sync.WaitGroup
+	wg.Add(1)
+	go serve(&wg, make(chan error, 1), l.srv, ln)
+	t.Cleanup(func() {
+		l.srv.Close()
+		wg.Wait()
+		l.rl.Stop()
+	})
+	return ln.Addr().String(), l
+}
+
+func TestNewListener_H2CPriorKnowledge(t *testing.T) {
+	logger.Init(io.Discard, io.Discard, io.Discard, io.Discard)
+	addr := startH2CListener(t, true)
+
+	tr := &http.Transport{Protocols: new(http.Protocols)}
+	tr.Protocols.SetUnencryptedHTTP2(true)
+	client := &http.Client{Transport: tr}
+	defer client.CloseIdleConnections()
+	resp, err := client.Get("http://" + addr + "/proto")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+	body, _ := io.ReadAll(resp.Body)
+	if resp.ProtoMajor != 2 || string(body) != "HTTP/2.0" {
+		t.Errorf("expected the request to be served over HTTP/2, got %s %q", resp.Proto, body)
+	}
+
+	resp, err = http.Get("http://" + addr + "/proto")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+	if body, _ := io.ReadAll(resp.Body); string(body) != "HTTP/1.1" {
+		t.Errorf("expected HTTP/1.1 to keep working, got %q", body)
+	}
+}
+
+// upgradeH2C sends an HTTP/1.1 request for /proto asking for an h2c
+// upgrade and returns the status line of the response and, after a
+// switch, the body of the response sent over HTTP/2.
+func upgradeH2C(t *testing.T, addr string) (status, body string) {
+	t.Helper()
+	conn, err := net.Dial("tcp", addr)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	return upgradeH2CConn(t, conn)
+}
+
+// upgradeH2CConn is upgradeH2C on conn, which is left open.
+func upgradeH2CConn(t *testing.T, conn net.Conn) (status, body string) {
+	t.Helper()
+	conn.SetDeadline(time.Now().Add(5 * time.Second))
+	io.WriteString(conn, "GET /proto HTTP/1.1\r\nHost: ip.example.com\r\n"+
+		"Connection: Upgrade, HTTP2-Settings\r\nUpgrade: h2c\r\nHTTP2-Settings: AAMAAABkAAQAAP__\r\n\r\n")
+	br := bufio.NewReader(conn)
+	resp, err := http.ReadResponse(br, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if resp.StatusCode != http.StatusSwitchingProtocols {
+		b, _ := io.ReadAll(resp.Body)
+		return resp.Status, string(b)
+	}
+
+	io.WriteString(conn, http2.ClientPreface)
+	fr := http2.NewFramer(conn, br)
+	if err := fr.WriteSettings(); err != nil {
+		t.Fatal(err)
+	}
+	var data strings.Builder
+	for {
+		f, err := fr.ReadFrame()
+		if err != nil {
+			t.Fatal(err)
+		}
+		switch f := f.(type) {
+		case *http2.SettingsFrame:
+			if !f.IsAck() {
+				fr.WriteSettingsAck()
+			}
+		case *http2.DataFrame:
+			if f.StreamID == 1 {
+				data.Write(f.Data())
+				if f.StreamEnded() {
+					return resp.Status, data.String()
+				}
+			}
+		case *http2.GoAwayFrame:
+			t.Fatalf("server closed the connection: %v", f.ErrCode)
+		}
+	}
+}
+
+func TestNewListener_H2CUpgrade(t *testing.T) {
+	logger.Init(io.Discard, io.Discard, io.Discard, io.Discard)
+
+	status, body := upgradeH2C(t, startH2CListener(t, true))
+	if status != "101 Switching Protocols" || body != "HTTP/2.0" {
+		t.Errorf("expected the upgraded request to be answered over HTTP/2, got %s %q", status, body)
+	}
+
+	status, body = upgradeH2C(t, startH2CListener(t, false))
+	if status != "200 OK" || body != "HTTP/1.1" {
+		t.Errorf("expected the upgrade to be ignored without h2c, got %s %q", status, body)
+	}
+}
+
+func TestNewListener_H2CUpgradeConns(t *testing.T) {
+	logger.Init(io.Discard, io.Discard, io.Discard, io.Discard)
+	addr, l := startH2CServer(t, true)
+	waitConns := func(want int64) {
+		t.Helper()
+		deadline := time.Now().Add(5 * time.Second)
+		for l.conns.Load() != want && time.Now().Before(deadline) {
+			time.Sleep(time.Millisecond)
+		}
+		if got := l.conns.Load(); got != want {
+			t.Fatalf("expected %d open connections, got %d", want, got)
+		}
+	}
+
+	conn, err := net.Dial("tcp", addr)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	if status, _ := upgradeH2CConn(t, conn); status != "101 Switching Protocols" {
+		t.Fatalf("expected the connection to be upgraded, got %s", status)
+	}
+	waitConns(1)
+	conn.Close()
+	waitConns(0)
+}
+
+func TestEnableH2C_UpgradeBodyLimit(t *testing.T) {
+	srv := &http.Server{Handler: http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		n, err := io.Copy(io.Discard, r.Body)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusRequestEntityTooLarge)
+			return
+		}
+		fmt.Fprint(w, n)
+	})}
+	enableH2C(srv, defaultServerLimits(), new(atomic.Int64))
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	go srv.Serve(ln)
+	defer srv.Close()
+
+	body := strings.Repeat("x", h2cMaxUpgradeBody+1)
+	post := func(upgrade bool) *http.Response {
+		t.Helper()
+		req, err := http.NewRequest(http.MethodPost, "http://"+ln.Addr().String()+"/", strings.NewReader(body))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if upgrade {
+			req.Header.Set("Connection", "Upgrade, HTTP2-Settings")
+			req.Header.Set("Upgrade", "h2c")
+			req.Header.Set("HTTP2-Settings", "AAMAAABkAAQAAP__")
+		}
+		resp, err := http.DefaultClient.Do(req)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return resp
+	}
+
+	resp := post(false)
+	got, _ := io.ReadAll(resp.Body)
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusOK || string(got) != strconv.Itoa(len(body)) {
+		t.Errorf("expected ordinary requests to have no body limit, got %d %q", resp.StatusCode, got)
+	}
+	resp = post(true)
+	resp.Body.Close()
+	if resp.StatusCode == http.StatusSwitchingProtocols {
+		t.Error("expected an upgrade with a body over the limit to be refused")
+	}
+}
+
+func TestLoadListeners_H2C(t *testing.T) {
+	v := configFromTOML(t, `
+h2c = true
+endpoint = ["127.0.0.1:80"]
+
+[[listener]]
+address = "127.0.0.1:81"
+h2c = false
+http2MaxConcurrentStreams = 10
+`)
+	got, err := loadListeners(v)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if len(got) != 2 || !got[0].H2C || got[1].H2C {
+		t.Fatalf("expected only the first listener to serve h2c, got %+v", got)
+	}
+	if got[1].Limits.HTTP2MaxConcurrentStreams != 10 {
+		t.Errorf("expected the listener stream limit, got %d", got[1].Limits.HTTP2MaxConcurrentStreams)
+	}
+
+	v = configFromTOML(t, "endpoint = [\"127.0.0.1:80\"]\n[http]\nhttp2MaxReadFrameSize = 1024")
+	if _, err := loadListeners(v); err == nil {
+		t.Error("expected an invalid frame size to be refused")
+	}
+}
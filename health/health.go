@@ -0,0 +1,243 @@
+// Package health implements liveness and readiness endpoints backed by a
+// registry of named checks, in the style of the Kubernetes component health
+// endpoints.
+//
+// GET /readyz answers "ok" when every check passes. With ?verbose the
+// result of every check is returned as JSON, ?exclude=name skips a check
+// and /readyz/name runs a single check. The reasons of failures and
+// warnings are only given by the endpoints of Routes, those of PublicRoutes
+// leave them out.
+package health
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"slices"
+	"strings"
+	"sync"
+
+	"github.com/tuggan/goip/logger"
+)
+
+// Check reports a component as unhealthy by returning an error.
+type Check func(ctx context.Context) error
+
+// Warning is returned by a check that passes but has something to report,
+// e.g. a certificate that expires soon. The check does not fail.
+type Warning string
+
+func (w Warning) Error() string { return string(w) }
+
+// Result statuses.
+const (
+	StatusOK       = "ok"
+	StatusFailed   = "failed"
+	StatusExcluded = "excluded"
+)
+
+// Result is the outcome of one check.
+type Result struct {
+	Name    string `json:"name"`
+	Status  string `json:"status"`
+	Error   string `json:"error,omitempty"`
+	Warning string `json:"warning,omitempty"`
+}
+
+// Report is the verbose response of a registry endpoint.
+type Report struct {
+	Status string   `json:"status"`
+	Checks []Result `json:"checks"`
+}
+
+type namedCheck struct {
+	name  string
+	check Check
+}
+
+// Registry is a named, ordered set of checks. It is safe for concurrent use.
+type Registry struct {
+	name   string
+	mu     sync.RWMutex
+	checks []namedCheck
+}
+
+// NewRegistry creates an empty registry served under "/"+name, e.g.
+// "readyz".
+func NewRegistry(name string) *Registry {
+	return &Registry{name: name}
+}
+
+// Register adds a check. Registering a name twice replaces the earlier
+// check.
+func (r *Registry) Register(name string, check Check) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	for i, c := range r.checks {
+		if c.name == name {
+			r.checks[i].check = check
+			return
+		}
+	}
+	r.checks = append(r.checks, namedCheck{name: name, check: check})
+}
+
+// Names returns the names of the registered checks in registration order.
+func (r *Registry) Names() []string {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	names := make([]string, len(r.checks))
+	for i, c := range r.checks {
+		names[i] = c.name
+	}
+	return names
+}
+
+// Run executes every check not in exclude and returns the report.
+func (r *Registry) Run(ctx context.Context, exclude []string) Report {
+	r.mu.RLock()
+	checks := append([]namedCheck(nil), r.checks...)
+	r.mu.RUnlock()
+
+	report := Report{Status: StatusOK, Checks: make([]Result, 0, len(checks))}
+	for _, c := range checks {
+		res := Result{Name: c.name, Status: StatusOK}
+		if slices.Contains(exclude, c.name) {
+			res.Status = StatusExcluded
+		} else if err := c.check(ctx); errors.As(err, new(Warning)) {
+			res.Warning = err.Error()
+		} else if err != nil {
+			res.Status = StatusFailed
+			res.Error = err.Error()
+			report.Status = StatusFailed
+		}
+		report.Checks = append(report.Checks, res)
+	}
+	return report
+}
+
+// Routes returns the registry endpoints keyed by the pattern they should
+// be registered under on an http.ServeMux: "/name" for all checks and
+// "/name/" for individual ones.
+func (r *Registry) Routes() map[string]http.HandlerFunc {
+	return map[string]http.HandlerFunc{
+		"/" + r.name:       r.ServeHTTP,
+		"/" + r.name + "/": r.serveCheck,
+	}
+}
+
+// PublicRoutes returns the endpoints of Routes without the reasons of
+// failures and warnings, which may name files and other internals, for
+// serving to anyone.
+func (r *Registry) PublicRoutes() map[string]http.HandlerFunc {
+	return map[string]http.HandlerFunc{
+		"/" + r.name: func(w http.ResponseWriter, req *http.Request) {
+			r.serve(w, req, false)
+		},
+		"/" + r.name + "/": func(w http.ResponseWriter, req *http.Request) {
+			r.serveSingle(w, req, false)
+		},
+	}
+}
+
+// ServeHTTP runs every check and answers 200 when all of them pass and 503
+// otherwise.
+func (r *Registry) ServeHTTP(w http.ResponseWriter, req *http.Request) {
+	r.serve(w, req, true)
+}
+
+func (r *Registry) serve(w http.ResponseWriter, req *http.Request, reasons bool) {
+	var exclude []string
+	for _, e := range req.URL.Query()["exclude"] {
+		exclude = append(exclude, strings.Split(e, ",")...)
+	}
+	report := r.Run(req.Context(), exclude)
+	if !reasons {
+		for i := range report.Checks {
+			report.Checks[i].Error = ""
+			report.Checks[i].Warning = ""
+		}
+	}
+
+	code := http.StatusOK
+	if report.Status != StatusOK {
+		code = http.StatusServiceUnavailable
+	}
+
+	if req.URL.Query().Has("verbose") {
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(code)
+		enc := json.NewEncoder(w)
+		enc.SetIndent("", "  ")
+		enc.Encode(report)
+		logger.Access(req, code)
+		return
+	}
+
+	w.Header().Set("Content-Type", "text/plain; charset=utf-8")
+	w.WriteHeader(code)
+	if code == http.StatusOK {
+		io.WriteString(w, "ok\n")
+	} else {
+		// Like Kubernetes, only name the failed checks. The reasons
+		// are available with ?verbose.
+		for _, res := range report.Checks {
+			if res.Status == StatusFailed {
+				fmt.Fprintf(w, "[-]%s failed\n", res.Name)
+			}
+		}
+		fmt.Fprintf(w, "%s check failed\n", r.name)
+	}
+	logger.Access(req, code)
+}
+
+// serveCheck runs the single check named by the last path element.
+func (r *Registry) serveCheck(w http.ResponseWriter, req *http.Request) {
+	r.serveSingle(w, req, true)
+}
+
+func (r *Registry) serveSingle(w http.ResponseWriter, req *http.Request, reasons bool) {
+	name := strings.TrimPrefix(req.URL.Path, "/"+r.name+"/")
+
+	var check Check
+	r.mu.RLock()
+	for _, c := range r.checks {
+		if c.name == name {
+			check = c.check
+		}
+	}
+	r.mu.RUnlock()
+
+	w.Header().Set("Content-Type", "text/plain; charset=utf-8")
+	if check == nil {
+		w.WriteHeader(http.StatusNotFound)
+		fmt.Fprintf(w, "%s check %q not found\n", r.name, name)
+		logger.Access(req, http.StatusNotFound)
+		return
+	}
+	err := check(req.Context())
+	if errors.As(err, new(Warning)) {
+		if reasons {
+			fmt.Fprintf(w, "ok: %s\n", err)
+		} else {
+			io.WriteString(w, "ok\n")
+		}
+		logger.Access(req, http.StatusOK)
+		return
+	}
+	if err != nil {
+		w.WriteHeader(http.StatusServiceUnavailable)
+		if reasons {
+			fmt.Fprintf(w, "%s failed: %s\n", name, err)
+		} else {
+			fmt.Fprintf(w, "%s failed\n", name)
+		}
+		logger.Access(req, http.StatusServiceUnavailable)
+		return
+	}
+	io.WriteString(w, "ok\n")
+	logger.Access(req, http.StatusOK)
+}
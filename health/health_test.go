@@ -0,0 +1,175 @@
+package health
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/tuggan/goip/logger"
+)
+
+func testRegistry() *Registry {
+	r := NewRegistry("readyz")
+	r.Register("ping", func(ctx context.Context) error { return nil })
+	r.Register("tls", func(ctx context.Context) error { return errors.New("certificate expired") })
+	return r
+}
+
+func serve(r *Registry, target string) *http.Response {
+	logger.Init(io.Discard, io.Discard, io.Discard, io.Discard)
+	mux := http.NewServeMux()
+	for pattern, h := range r.Routes() {
+		mux.HandleFunc(pattern, h)
+	}
+	w := httptest.NewRecorder()
+	mux.ServeHTTP(w, httptest.NewRequest(http.MethodGet, target, nil))
+	return w.Result()
+}
+
+func TestRegistry_OK(t *testing.T) {
+	r := NewRegistry("livez")
+	r.Register("ping", func(ctx context.Context) error { return nil })
+
+	resp := serve(r, "/livez")
+	body, _ := io.ReadAll(resp.Body)
+	if resp.StatusCode != http.StatusOK {
+		t.Errorf("expected 200, got %d", resp.StatusCode)
+	}
+	if string(body) != "ok\n" {
+		t.Errorf("expected %q, got %q", "ok\n", body)
+	}
+}
+
+func TestRegistry_Failed(t *testing.T) {
+	resp := serve(testRegistry(), "/readyz")
+	body, _ := io.ReadAll(resp.Body)
+	if resp.StatusCode != http.StatusServiceUnavailable {
+		t.Errorf("expected 503, got %d", resp.StatusCode)
+	}
+	if !strings.Contains(string(body), "[-]tls failed") {
+		t.Errorf("expected failed check to be named, got %q", body)
+	}
+	if strings.Contains(string(body), "expired") {
+		t.Errorf("expected failure reason to be withheld without ?verbose, got %q", body)
+	}
+}
+
+func TestRegistry_Verbose(t *testing.T) {
+	resp := serve(testRegistry(), "/readyz?verbose")
+	if resp.StatusCode != http.StatusServiceUnavailable {
+		t.Errorf("expected 503, got %d", resp.StatusCode)
+	}
+	if ct := resp.Header.Get("Content-Type"); ct != "application/json" {
+		t.Errorf("expected JSON, got %q", ct)
+	}
+	var report Report
+	if err := json.NewDecoder(resp.Body).Decode(&report); err != nil {
+		t.Fatalf("failed to decode report: %v", err)
+	}
+	want := []Result{
+		{Name: "ping", Status: StatusOK},
+		{Name: "tls", Status: StatusFailed, Error: "certificate expired"},
+	}
+	if report.Status != StatusFailed || len(report.Checks) != len(want) {
+		t.Fatalf("unexpected report %+v", report)
+	}
+	for i, res := range report.Checks {
+		if res != want[i] {
+			t.Errorf("check %d: expected %+v, got %+v", i, want[i], res)
+		}
+	}
+}
+
+func TestRegistry_PublicRoutes(t *testing.T) {
+	logger.Init(io.Discard, io.Discard, io.Discard, io.Discard)
+	r := testRegistry()
+	r.Register("cert", func(ctx context.Context) error { return Warning("expires soon") })
+	mux := http.NewServeMux()
+	for pattern, h := range r.PublicRoutes() {
+		mux.HandleFunc(pattern, h)
+	}
+	for _, target := range []string{"/readyz", "/readyz?verbose", "/readyz/tls", "/readyz/cert"} {
+		w := httptest.NewRecorder()
+		mux.ServeHTTP(w, httptest.NewRequest(http.MethodGet, target, nil))
+		if body := w.Body.String(); strings.Contains(body, "expired") || strings.Contains(body, "soon") {
+			t.Errorf("%s: expected reasons to be withheld, got %q", target, body)
+		}
+	}
+
+	w := httptest.NewRecorder()
+	mux.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/readyz?verbose", nil))
+	var report Report
+	if err := json.NewDecoder(w.Body).Decode(&report); err != nil {
+		t.Fatal(err)
+	}
+	if w.Code != http.StatusServiceUnavailable || report.Checks[1] != (Result{Name: "tls", Status: StatusFailed}) {
+		t.Errorf("expected the failed check to be named, got %d %+v", w.Code, report)
+	}
+}
+
+func TestRegistry_Exclude(t *testing.T) {
+	resp := serve(testRegistry(), "/readyz?exclude=tls&verbose")
+	if resp.StatusCode != http.StatusOK {
+		t.Errorf("expected 200 with the failing check excluded, got %d", resp.StatusCode)
+	}
+	var report Report
+	json.NewDecoder(resp.Body).Decode(&report)
+	if report.Checks[1].Status != StatusExcluded {
+		t.Errorf("expected tls to be excluded, got %+v", report.Checks[1])
+	}
+}
+
+func TestRegistry_SingleCheck(t *testing.T) {
+	r := testRegistry()
+	tests := []struct {
+		target string
+		code   int
+	}{
+		{"/readyz/ping", http.StatusOK},
+		{"/readyz/tls", http.StatusServiceUnavailable},
+		{"/readyz/missing", http.StatusNotFound},
+	}
+	for _, tt := range tests {
+		if resp := serve(r, tt.target); resp.StatusCode != tt.code {
+			t.Errorf("%s: expected %d, got %d", tt.target, tt.code, resp.StatusCode)
+		}
+	}
+}
+
+func TestRegistry_RegisterReplaces(t *testing.T) {
+	r := testRegistry()
+	r.Register("tls", func(ctx context.Context) error { return nil })
+	if names := r.Names(); len(names) != 2 {
+		t.Errorf("expected 2 checks, got %v", names)
+	}
+	if report := r.Run(context.Background(), nil); report.Status != StatusOK {
+		t.Errorf("expected replaced check to pass, got %+v", report)
+	}
+}
+
+func TestRegistry_Warning(t *testing.T) {
+	r := NewRegistry("readyz")
+	r.Register("tls", func(ctx context.Context) error {
+		return fmt.Errorf("listener https: %w", Warning("certificate expires in 72h0m0s"))
+	})
+
+	report := r.Run(context.Background(), nil)
+	if report.Status != StatusOK || report.Checks[0].Status != StatusOK {
+		t.Errorf("expected a warning not to fail the check, got %+v", report)
+	}
+	if w := report.Checks[0].Warning; w != "listener https: certificate expires in 72h0m0s" {
+		t.Errorf("expected the warning in the result, got %q", w)
+	}
+
+	resp := serve(r, "/readyz/tls")
+	body, _ := io.ReadAll(resp.Body)
+	if resp.StatusCode != http.StatusOK || !strings.HasPrefix(string(body), "ok: listener https") {
+		t.Errorf("expected ok with the warning, got %d %q", resp.StatusCode, body)
+	}
+}
@@ -0,0 +1,60 @@
+package main
+
+import (
+	"crypto/tls"
+	"net"
+	"net/http"
+	"sync"
+
+	"github.com/tuggan/goip/web"
+)
+
+// helloCapture records what TLS clients offer in their ClientHello so the
+// handlers can report it. The handshake only sees the underlying
+// connection, so the web.ConnInfo of every connection is kept by it until
+// the handshake fills in the ClientHello or the connection closes.
+type helloCapture struct {
+	conns sync.Map // net.Conn -> *web.ConnInfo
+}
+
+// captureHellos makes srv, whose TLSConfig must be set, capture the
+// ClientHello of every connection of the listener name. It replaces
+// srv.ConnContext and must be called after srv.ConnState is set.
+func captureHellos(srv *http.Server, name string) {
+	c := &helloCapture{}
+	srv.ConnContext = connContext(name, c)
+
+	next := srv.TLSConfig.GetConfigForClient
+	srv.TLSConfig.GetConfigForClient = func(hello *tls.ClientHelloInfo) (*tls.Config, error) {
+		if info, ok := c.conns.LoadAndDelete(hello.Conn); ok {
+			info.(*web.ConnInfo).Hello = web.NewClientHello(hello)
+		}
+		if next != nil {
+			return next(hello)
+		}
+		return nil, nil
+	}
+
+	state := srv.ConnState
+	srv.ConnState = func(conn net.Conn, s http.ConnState) {
+		if s == http.StateClosed || s == http.StateHijacked {
+			c.forget(conn)
+		}
+		if state != nil {
+			state(conn, s)
+		}
+	}
+}
+
+// track keeps info until the handshake of conn.
+func (c *helloCapture) track(conn net.Conn, info *web.ConnInfo) {
+	c.conns.Store(conn, info)
+}
+
+// forget drops conn if it closed before its handshake.
+func (c *helloCapture) forget(conn net.Conn) {
+	if tc, ok := conn.(*tls.Conn); ok {
+		conn = tc.NetConn()
+	}
+	c.conns.Delete(conn)
+}
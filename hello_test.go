@@ -0,0 +1,148 @@
+package main
+
+import (
+	"context"
+	"crypto/tls"
+	"encoding/json"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"slices"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/tuggan/goip/logger"
+	"github.com/tuggan/goip/web"
+)
+
+func TestNewListener_ClientHello(t *testing.T) {
+	logger.Init(io.Discard, io.Discard, io.Discard, io.Discard)
+	now := time.Now()
+	certFile, keyFile := writeTestCert(t, now.Add(-time.Hour), now.Add(90*24*time.Hour), "ip.example.com")
+	themes, err := loadThemes(configFromTOML(t, ``), templateFS(""), nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	l, err := newListener(listenerConfig{Name: "https", Address: "127.0.0.1:0", TLS: true, TLSCert: certFile, TLSKey: keyFile, TLSPolicy: defaultTLSPolicyConfig()},
+		handlerOptions{themes: themes, staticDir: "static"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer l.rl.Stop()
+
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	var wg sync.WaitGroup
+	wg.Add(1)
+	go serve(&wg, make(chan error, 1), l.srv, ln)
+	defer func() {
+		l.srv.Shutdown(context.Background())
+		wg.Wait()
+	}()
+
+	suites := []uint16{tls.TLS_ECDHE_ECDSA_WITH_AES_256_GCM_SHA384}
+	client := &http.Client{Transport: &http.Transport{
+		TLSClientConfig: &tls.Config{
+			ServerName:         "ip.example.com",
+			InsecureSkipVerify: true,
+			MaxVersion:         tls.VersionTLS12,
+			CipherSuites:       suites,
+		},
+		ForceAttemptHTTP2: true,
+	}}
+	defer client.CloseIdleConnections()
+	resp, err := client.Get("https://" + ln.Addr().String() + "/tls")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+	var report struct {
+		Version     string `json:"version"`
+		CipherSuite string `json:"cipherSuite"`
+		ServerName  string `json:"serverName"`
+		ALPN        string `json:"alpn"`
+		ClientHello *struct {
+			CipherSuites []struct {
+				ID uint16 `json:"id"`
+			} `json:"cipherSuites"`
+			ALPN []string `json:"alpn"`
+		} `json:"clientHello"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&report); err != nil {
+		t.Fatal(err)
+	}
+	if report.Version != "TLS 1.2" || report.CipherSuite != "TLS_ECDHE_ECDSA_WITH_AES_256_GCM_SHA384" ||
+		report.ServerName != "ip.example.com" || report.ALPN != "h2" {
+		t.Errorf("unexpected session %+v", report)
+	}
+	if report.ClientHello == nil {
+		t.Fatal("expected the ClientHello to be captured")
+	}
+	var offered []uint16
+	for _, s := range report.ClientHello.CipherSuites {
+		offered = append(offered, s.ID)
+	}
+	// Clients also offer their TLS 1.3 suites and the renegotiation SCSV.
+	for _, s := range suites {
+		if !slices.Contains(offered, s) {
+			t.Errorf("expected the offered cipher suites to contain %s, got %v", tls.CipherSuiteName(s), offered)
+		}
+	}
+	if !slices.Equal(report.ClientHello.ALPN, []string{"h2", "http/1.1"}) {
+		t.Errorf("expected the offered protocols, got %v", report.ClientHello.ALPN)
+	}
+
+	resp, err = client.Get("https://" + ln.Addr().String() + "/tls/fingerprint")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+	var fp struct {
+		JA3Hash string `json:"ja3Hash"`
+		JA4     string `json:"ja4"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&fp); err != nil {
+		t.Fatal(err)
+	}
+	if len(fp.JA3Hash) != 32 || !strings.HasPrefix(fp.JA4, "t12d01") || !strings.Contains(fp.JA4, "h2_") {
+		t.Errorf("unexpected fingerprint %+v", fp)
+	}
+}
+
+func TestRateLimitKey(t *testing.T) {
+	for s, want := range map[string][]string{
+		"":       {"ip"},
+		"ip":     {"ip"},
+		"JA4":    {"ja4"},
+		"ip+ja3": {"ip", "ja3"},
+	} {
+		got, err := parseRateLimitKey(s)
+		if err != nil || !slices.Equal(got, want) {
+			t.Errorf("%q: expected %v, got %v, %v", s, want, got, err)
+		}
+	}
+	for _, s := range []string{"ua", "ip+", "ja4+ja4"} {
+		if _, err := parseRateLimitKey(s); err == nil {
+			t.Errorf("%q: expected an error", s)
+		}
+	}
+
+	hello := &web.ClientHello{Fingerprint: web.Fingerprint{JA3Hash: "ada70206e40642a3e4461f35503241d5", JA4: "t13d1516h2_8daaf6152771_e5627efa2ab1"}}
+	req := httptest.NewRequest(http.MethodGet, "/", nil)
+	key := rateLimitKeyFunc([]string{"ip", "ja4"})
+	if got := key(req, "1.2.3.4"); got != "1.2.3.4" {
+		t.Errorf("expected requests without fingerprint to be keyed by IP, got %q", got)
+	}
+	req = req.WithContext(web.WithConnInfo(req.Context(), &web.ConnInfo{Listener: "https", Hello: hello}))
+	if got := key(req, "1.2.3.4"); got != "1.2.3.4|t13d1516h2_8daaf6152771_e5627efa2ab1" {
+		t.Errorf("unexpected key %q", got)
+	}
+	if got := rateLimitKeyFunc([]string{"ja3"})(req, "1.2.3.4"); got != "ada70206e40642a3e4461f35503241d5" {
+		t.Errorf("unexpected key %q", got)
+	}
+}
@@ -0,0 +1,629 @@
+package main
+
+import (
+	"context"
+	"crypto/tls"
+	"fmt"
+	"log"
+	"maps"
+	"math"
+	"net"
+	"net/http"
+	"os"
+	"slices"
+	"strings"
+	"sync/atomic"
+	"time"
+
+	"github.com/spf13/viper"
+	"github.com/tuggan/goip/health"
+	"github.com/tuggan/goip/logger"
+	"github.com/tuggan/goip/tracing"
+	"github.com/tuggan/goip/web"
+	"golang.org/x/crypto/acme/autocert"
+)
+
+// listenerConfig describes a single address GoIP listens on together with
+// the settings that only apply to connections accepted on it.
+type listenerConfig struct {
+	Name          string
+	Network       string
+	Address       string
+	TLS           bool
+	TLSCert       string
+	TLSKey        string
+	Certs         []certPair
+	ACME          bool
+	TLSPolicy     tlsPolicy
+	ClientAuth    tls.ClientAuthType
+	ClientCA      string
+	HTTPSRedirect httpsRedirect
+	// H2C serves HTTP/2 without TLS on plain listeners.
+	H2C            bool
+	TrustedProxies []string
+	RateLimit      float64
+	RateLimitBurst int
+	RateLimitKey   []string
+	Routes         []string
+	Limits         serverLimits
+	SocketMode     os.FileMode
+	SocketOwner    string
+	SocketGroup    string
+}
+
+// listener is a configured listener together with the server that serves
+// it and the sockets it accepts connections on.
+type listener struct {
+	cfg listenerConfig
+	srv *http.Server
+	rl  *web.RateLimiter
+	lns []net.Listener
+	// certs holds the certificates of TLS listeners not using ACME.
+	certs *certStore
+	// tickets rotates the session ticket keys of TLS listeners that
+	// configure a rotation period.
+	tickets *ticketKeys
+	// conns counts the open connections.
+	conns *atomic.Int64
+}
+
+// handlerOptions holds the settings and state shared by the handlers of
+// every listener.
+type handlerOptions struct {
+	gzip         bool
+	themes       *web.Themes
+	vhosts       *web.VHosts
+	staticDir    string
+	staticMaxAge time.Duration
+	draining     *atomic.Bool
+	livez        *health.Registry
+	readyz       *health.Registry
+	tracer       *tracing.Tracer
+	bans         *web.BanList
+	acme         *autocert.Manager
+}
+
+// newListener creates the handler, rate limiter and server for c. The
+// sockets are bound separately.
+func newListener(c listenerConfig, opts handlerOptions) (*listener, error) {
+	h := web.NewHandler(opts.gzip, "", Version, Branch, Date, author, email, c.TrustedProxies)
+	h.SetThemes(opts.themes)
+	if err := h.SetStatic(opts.staticDir, opts.staticMaxAge); err != nil {
+		return nil, fmt.Errorf("listener %q: %w", c.Name, err)
+	}
+	h.SetDrainFlag(opts.draining)
+	routes := h.Routes()
+	for _, r := range []*health.Registry{opts.livez, opts.readyz} {
+		if r != nil {
+			maps.Copy(routes, r.PublicRoutes())
+		}
+	}
+	if opts.vhosts != nil {
+		for p, hf := range routes {
+			routes[p] = vhostRoute(p, hf, h.NotFoundHandler)
+		}
+	}
+	mux, err := newListenerMux(c, routes)
+	if err != nil {
+		return nil, err
+	}
+	var next http.Handler = mux
+	if opts.vhosts != nil {
+		next = opts.vhosts.Middleware(mux)
+	}
+	if c.HTTPSRedirect.Enabled && !c.isTLS() {
+		// Health checks keep probing the plain listener itself.
+		exempt := []string{"/health"}
+		for _, r := range []*health.Registry{opts.livez, opts.readyz} {
+			if r != nil {
+				exempt = slices.AppendSeq(exempt, maps.Keys(r.PublicRoutes()))
+			}
+		}
+		next = c.HTTPSRedirect.middleware(exempt, next)
+	}
+	if c.Network == "unix" && c.RateLimit > 0 && !slices.Contains(c.TrustedProxies, "unix") {
+		// Without the client address from the proxy every peer on the
+		// socket is the same client to the rate limiter.
+		logger.Warning("Listener %s: unix socket peers are not trusted proxies, all of them share one rate limit; add \"unix\" to trustedProxy", c.Name)
+	}
+	rateLimiter := web.NewRateLimiter(c.RateLimit, c.RateLimitBurst, 10*time.Minute)
+	rateLimiter.SetKeyFunc(h.ClientIP)
+	rateLimiter.SetBanList(opts.bans)
+	if len(c.RateLimitKey) > 0 && !slices.Equal(c.RateLimitKey, []string{"ip"}) {
+		rateLimiter.SetLimitKeyFunc(rateLimitKeyFunc(c.RateLimitKey))
+	}
+
+	// Wrap the mux with tracing, metrics, rate limiting, panic recovery,
+	// security headers and virtual hosts.
+	handler := metricsMiddleware(c.Name, recoveryMiddleware(rateLimiter.Middleware(securityHeadersMiddleware(next))))
+	if opts.acme != nil && !c.isTLS() {
+		// HTTP-01 challenges are answered on the plain listeners.
+		handler = opts.acme.HTTPHandler(handler)
+	}
+	if opts.tracer != nil {
+		handler = tracingMiddleware(opts.tracer, c.Name, h.TrustedPeer, h.ClientIP, handler)
+	}
+	srv := &http.Server{
+		Handler:     handler,
+		ErrorLog:    log.New(serverErrorLog{listener: c.Name}, "", 0),
+		ConnContext: connContext(c.Name, nil),
+	}
+	c.Limits.apply(srv)
+	var certs *certStore
+	switch {
+	case c.ACME:
+		if opts.acme == nil {
+			return nil, fmt.Errorf("listener %q: acme is not configured", c.Name)
+		}
+		// Also answers TLS-ALPN-01 challenges.
+		srv.TLSConfig = opts.acme.TLSConfig()
+	case c.isTLS():
+		if certs, err = newCertStore(c.Name, c.certPairs()); err != nil {
+			return nil, err
+		}
+		srv.TLSConfig = &tls.Config{GetCertificate: certs.GetCertificate}
+	}
+	conns := trackConns(srv)
+	if c.H2C && !c.isTLS() {
+		enableH2C(srv, c.Limits, conns)
+	}
+	if srv.TLSConfig != nil && c.ClientAuth != tls.NoClientCert {
+		srv.TLSConfig.ClientAuth = c.ClientAuth
+		if c.ClientCA != "" {
+			if srv.TLSConfig.ClientCAs, err = loadCertPool(c.ClientCA); err != nil {
+				return nil, fmt.Errorf("listener %q: tlsClientCA: %w", c.Name, err)
+			}
+		}
+	}
+	var tickets *ticketKeys
+	if srv.TLSConfig != nil {
+		tickets = c.TLSPolicy.apply(srv)
+		captureHellos(srv, c.Name)
+	}
+	return &listener{cfg: c, srv: srv, rl: rateLimiter, certs: certs, tickets: tickets, conns: conns}, nil
+}
+
+// connContext returns an http.Server ConnContext function that attaches
+// web.ConnInfo for the listener to every connection. Inherited sockets may
+// differ from the configured network, so the connection itself tells
+// whether it came in on a unix socket. With hellos set every connection
+// gets its own ConnInfo to receive its ClientHello.
+func connContext(name string, hellos *helloCapture) func(context.Context, net.Conn) context.Context {
+	tcpInfo := &web.ConnInfo{Listener: name}
+	unixInfo := &web.ConnInfo{Listener: name, Unix: true}
+	return func(ctx context.Context, conn net.Conn) context.Context {
+		if tc, ok := conn.(*tls.Conn); ok {
+			conn = tc.NetConn()
+		}
+		_, unix := conn.(*net.UnixConn)
+		if hellos != nil {
+			info := &web.ConnInfo{Listener: name, Unix: unix}
+			hellos.track(conn, info)
+			return web.WithConnInfo(ctx, info)
+		}
+		if unix {
+			return web.WithConnInfo(ctx, unixInfo)
+		}
+		return web.WithConnInfo(ctx, tcpInfo)
+	}
+}
+
+// isTLS reports whether the listener serves HTTPS.
+func (c listenerConfig) isTLS() bool {
+	return c.TLS
+}
+
+// certPairs returns the certificates of the listener, tlsCert and tlsKey
+// first.
+func (c listenerConfig) certPairs() []certPair {
+	var pairs []certPair
+	if c.TLSCert != "" && c.TLSKey != "" {
+		pairs = append(pairs, certPair{Cert: c.TLSCert, Key: c.TLSKey})
+	}
+	return append(pairs, c.Certs...)
+}
+
+// url returns a printable URL for the listener, used in log messages.
+func (c listenerConfig) url() string {
+	scheme := "http"
+	if c.isTLS() {
+		scheme = "https"
+	}
+	if c.Network == "unix" {
+		scheme += "+unix"
+	}
+	return scheme + "://" + c.Address
+}
+
+// rateLimitBurst returns burst, or the rate rounded up when burst is not
+// set. The result is never below 1.
+func rateLimitBurst(rate float64, burst int) int {
+	if burst > 0 {
+		return burst
+	}
+	burst = int(math.Ceil(rate))
+	if burst < 1 {
+		burst = 1
+	}
+	return burst
+}
+
+// rateLimitKeys are the parts a rate limit key can be made of.
+var rateLimitKeys = []string{"ip", "ja3", "ja4"}
+
+// parseRateLimitKey parses a rate limit key such as "ip" or "ip+ja4". An
+// empty key is the client IP.
+func parseRateLimitKey(s string) ([]string, error) {
+	if s == "" {
+		return []string{"ip"}, nil
+	}
+	parts := strings.Split(strings.ToLower(s), "+")
+	for i, p := range parts {
+		if !slices.Contains(rateLimitKeys, p) {
+			return nil, fmt.Errorf("rateLimitKey %q: unknown part %q, expected ip, ja3 or ja4", s, p)
+		}
+		if slices.Contains(parts[:i], p) {
+			return nil, fmt.Errorf("rateLimitKey %q: %s is repeated", s, p)
+		}
+	}
+	return parts, nil
+}
+
+// rateLimitKeyFunc returns the rate limiter key function combining parts.
+// Requests without TLS fingerprints fall back to the client IP, so plain
+// HTTP clients do not share one bucket.
+func rateLimitKeyFunc(parts []string) func(*http.Request, string) string {
+	return func(r *http.Request, ip string) string {
+		fp := web.RequestFingerprint(r)
+		if fp == nil {
+			return ip
+		}
+		values := make([]string, len(parts))
+		for i, p := range parts {
+			switch p {
+			case "ip":
+				values[i] = ip
+			case "ja3":
+				values[i] = fp.JA3Hash
+			case "ja4":
+				values[i] = fp.JA4
+			}
+		}
+		return strings.Join(values, "|")
+	}
+}
+
+// loadListeners builds the listener list from the configuration. The flat
+// endpoint and tlsEndpoint lists are turned into listeners sharing the
+// global settings, and every [[listener]] table adds one listener that
+// falls back to the global settings for anything it does not set itself.
+func loadListeners(v *viper.Viper) ([]listenerConfig, error) {
+	limits := loadServerLimits(v, "", defaultServerLimits())
+	base, err := baseListener(v)
+	if err != nil {
+		return nil, err
+	}
+	certs, err := loadCertPairs(v)
+	if err != nil {
+		return nil, err
+	}
+
+	tables, err := configTables(v, "listener")
+	if err != nil {
+		return nil, err
+	}
+
+	var listeners []listenerConfig
+
+	// The default endpoint is only used when no [[listener]] tables are
+	// configured, otherwise it would be bound in addition to them.
+	if len(tables) == 0 || v.IsSet("endpoint") {
+		for _, addr := range v.GetStringSlice("endpoint") {
+			c := base
+			c.Name = "http"
+			c.Address = addr
+			c.Limits = loadServerLimits(v, "http.", limits)
+			listeners = append(listeners, c)
+		}
+	}
+
+	for _, addr := range v.GetStringSlice("tlsEndpoint") {
+		c := base
+		c.Name = "https"
+		c.Address = addr
+		setGlobalTLS(v, &c, certs, limits)
+		listeners = append(listeners, c)
+	}
+
+	for i, t := range tables {
+		c := base
+		c.Name = t.GetString("name")
+		if c.Name == "" {
+			c.Name = fmt.Sprintf("listener-%d", i)
+		}
+		c.Address = t.GetString("address")
+		if t.IsSet("network") {
+			c.Network = t.GetString("network")
+		}
+		if t.GetBool("tls") || t.IsSet("tlsCert") || t.IsSet("tlsKey") || t.IsSet("certificate") {
+			c.TLS = true
+			c.TLSCert = v.GetString("tlsCert")
+			c.TLSKey = v.GetString("tlsKey")
+			c.Certs = certs
+			if t.IsSet("tlsCert") {
+				c.TLSCert = t.GetString("tlsCert")
+			}
+			if t.IsSet("tlsKey") {
+				c.TLSKey = t.GetString("tlsKey")
+			}
+			if t.IsSet("certificate") {
+				if c.Certs, err = loadCertPairs(t); err != nil {
+					return nil, fmt.Errorf("listener %q: %w", c.Name, err)
+				}
+			}
+			if c.TLSPolicy, err = loadTLSPolicy(t, "", c.TLSPolicy); err != nil {
+				return nil, fmt.Errorf("listener %q: %w", c.Name, err)
+			}
+			if err := loadClientAuth(t, &c); err != nil {
+				return nil, fmt.Errorf("listener %q: %w", c.Name, err)
+			}
+		}
+		if c.HTTPSRedirect, err = loadHTTPSRedirect(t, c.HTTPSRedirect); err != nil {
+			return nil, fmt.Errorf("listener %q: %w", c.Name, err)
+		}
+		if t.IsSet("h2c") {
+			c.H2C = t.GetBool("h2c")
+		}
+		if t.IsSet("trustedProxies") {
+			c.TrustedProxies = t.GetStringSlice("trustedProxies")
+		}
+		if t.IsSet("rateLimit") {
+			c.RateLimit = t.GetFloat64("rateLimit")
+		}
+		if t.IsSet("rateLimitBurst") {
+			c.RateLimitBurst = t.GetInt("rateLimitBurst")
+		}
+		if t.IsSet("rateLimitKey") {
+			if c.RateLimitKey, err = parseRateLimitKey(t.GetString("rateLimitKey")); err != nil {
+				return nil, fmt.Errorf("listener %q: %w", c.Name, err)
+			}
+		}
+		if t.IsSet("routes") {
+			c.Routes = t.GetStringSlice("routes")
+		}
+		if t.IsSet("socketMode") {
+			if c.SocketMode, err = parseSocketMode(t.Get("socketMode")); err != nil {
+				return nil, fmt.Errorf("listener %q: %w", c.Name, err)
+			}
+		}
+		if t.IsSet("socketOwner") {
+			c.SocketOwner = t.GetString("socketOwner")
+		}
+		if t.IsSet("socketGroup") {
+			c.SocketGroup = t.GetString("socketGroup")
+		}
+		if c.isTLS() {
+			c.Limits = loadServerLimits(t, "", loadServerLimits(v, "https.", limits))
+		} else {
+			c.Limits = loadServerLimits(t, "", loadServerLimits(v, "http.", limits))
+		}
+		listeners = append(listeners, c)
+	}
+
+	acme := acmeEnabled(v)
+	for i := range listeners {
+		// TLS listeners without a certificate of their own use ACME.
+		listeners[i].ACME = acme && listeners[i].isTLS() && len(listeners[i].certPairs()) == 0
+		if strings.HasPrefix(listeners[i].Address, unixPrefix) {
+			listeners[i].Network = "unix"
+			listeners[i].Address = strings.TrimPrefix(listeners[i].Address, unixPrefix)
+		}
+		listeners[i].RateLimitBurst = rateLimitBurst(listeners[i].RateLimit, listeners[i].RateLimitBurst)
+		if err := validateListener(listeners[i]); err != nil {
+			return nil, err
+		}
+	}
+	// Sockets passed in by systemd are only known later, the global
+	// certificate is enough for those named https.
+	https := acme || v.GetString("tlsCert") != "" || len(certs) > 0
+	if err := validateHTTPSRedirect(listeners, https); err != nil {
+		return nil, err
+	}
+
+	return listeners, nil
+}
+
+// validateHTTPSRedirect fails when a plain listener redirects to HTTPS but
+// there is no TLS listener to redirect to and https, whether HTTPS may be
+// served otherwise, is false.
+func validateHTTPSRedirect(listeners []listenerConfig, https bool) error {
+	if https || slices.ContainsFunc(listeners, listenerConfig.isTLS) {
+		return nil
+	}
+	for _, c := range listeners {
+		if c.HTTPSRedirect.Enabled {
+			return fmt.Errorf("listener %q: httpsRedirect needs a TLS listener, ACME or a global tlsCert", c.Name)
+		}
+	}
+	return nil
+}
+
+// baseListener returns a listener carrying the global settings every
+// listener starts out with.
+func baseListener(v *viper.Viper) (listenerConfig, error) {
+	socketMode, err := parseSocketMode(v.Get("socketMode"))
+	if err != nil {
+		return listenerConfig{}, err
+	}
+	policy, err := loadTLSPolicy(v, "", defaultTLSPolicyConfig())
+	if err != nil {
+		return listenerConfig{}, err
+	}
+	rateLimitKey, err := parseRateLimitKey(v.GetString("rateLimitKey"))
+	if err != nil {
+		return listenerConfig{}, err
+	}
+	redirect, err := loadHTTPSRedirect(v, defaultHTTPSRedirect())
+	if err != nil {
+		return listenerConfig{}, err
+	}
+	c := listenerConfig{
+		Network:        "tcp",
+		TrustedProxies: v.GetStringSlice("trustedProxy"),
+		RateLimit:      v.GetFloat64("rateLimit"),
+		RateLimitBurst: v.GetInt("rateLimitBurst"),
+		RateLimitKey:   rateLimitKey,
+		SocketMode:     socketMode,
+		SocketOwner:    v.GetString("socketOwner"),
+		SocketGroup:    v.GetString("socketGroup"),
+		TLSPolicy:      policy,
+		HTTPSRedirect:  redirect,
+		H2C:            v.GetBool("h2c"),
+	}
+	if err := loadClientAuth(v, &c); err != nil {
+		return listenerConfig{}, err
+	}
+	return c, nil
+}
+
+// clientAuthModes maps the tlsClientAuth settings to the client
+// certificate policies of crypto/tls.
+var clientAuthModes = map[string]tls.ClientAuthType{
+	"none":            tls.NoClientCert,
+	"request":         tls.RequestClientCert,
+	"require":         tls.RequireAnyClientCert,
+	"verify-if-given": tls.VerifyClientCertIfGiven,
+	"verify":          tls.RequireAndVerifyClientCert,
+}
+
+// loadClientAuth applies the client certificate settings of v to c.
+// Setting tlsClientCA without tlsClientAuth requires client certificates
+// signed by it.
+func loadClientAuth(v *viper.Viper, c *listenerConfig) error {
+	if v.IsSet("tlsClientCA") {
+		c.ClientCA = v.GetString("tlsClientCA")
+		if !v.IsSet("tlsClientAuth") && c.ClientAuth == tls.NoClientCert {
+			c.ClientAuth = tls.RequireAndVerifyClientCert
+		}
+	}
+	if v.IsSet("tlsClientAuth") {
+		mode, ok := clientAuthModes[strings.ToLower(v.GetString("tlsClientAuth"))]
+		if !ok {
+			return fmt.Errorf("tlsClientAuth: unknown mode %q, expected none, request, require, verify-if-given or verify", v.GetString("tlsClientAuth"))
+		}
+		c.ClientAuth = mode
+	}
+	return nil
+}
+
+// setGlobalTLS turns c into a TLS listener using the global certificates.
+func setGlobalTLS(v *viper.Viper, c *listenerConfig, certs []certPair, limits serverLimits) {
+	c.TLS = true
+	c.TLSCert = v.GetString("tlsCert")
+	c.TLSKey = v.GetString("tlsKey")
+	c.Certs = certs
+	c.Limits = loadServerLimits(v, "https.", limits)
+}
+
+// activatedListener returns the configuration for sockets passed in by
+// systemd under a name that matches no configured listener. Names
+// starting with "https" or "tls" are served with the global TLS settings,
+// everything else as plain HTTP.
+func activatedListener(v *viper.Viper, name string) (listenerConfig, error) {
+	limits := loadServerLimits(v, "", defaultServerLimits())
+	c, err := baseListener(v)
+	if err != nil {
+		return c, err
+	}
+	c.Name = name
+	c.Limits = loadServerLimits(v, "http.", limits)
+	if strings.HasPrefix(name, "https") || strings.HasPrefix(name, "tls") {
+		certs, err := loadCertPairs(v)
+		if err != nil {
+			return c, err
+		}
+		setGlobalTLS(v, &c, certs, limits)
+	}
+	c.RateLimitBurst = rateLimitBurst(c.RateLimit, c.RateLimitBurst)
+	c.ACME = acmeEnabled(v) && c.isTLS() && len(c.certPairs()) == 0
+	if err := validateListener(c); err != nil {
+		return c, err
+	}
+	return c, nil
+}
+
+// configTables returns one viper instance per table in the array of tables
+// key, e.g. [[listener]], so the tables can be read with the same helpers
+// as the top level config.
+func configTables(v *viper.Viper, key string) ([]*viper.Viper, error) {
+	raw := v.Get(key)
+	if raw == nil {
+		return nil, nil
+	}
+
+	var entries []map[string]interface{}
+	switch t := raw.(type) {
+	case []map[string]interface{}:
+		entries = t
+	case []interface{}:
+		for _, e := range t {
+			m, ok := e.(map[string]interface{})
+			if !ok {
+				return nil, fmt.Errorf("%s: expected a table, got %T", key, e)
+			}
+			entries = append(entries, m)
+		}
+	default:
+		return nil, fmt.Errorf("%s: expected an array of tables, got %T", key, raw)
+	}
+
+	tables := make([]*viper.Viper, 0, len(entries))
+	for _, m := range entries {
+		t := viper.New()
+		if err := t.MergeConfigMap(m); err != nil {
+			return nil, err
+		}
+		tables = append(tables, t)
+	}
+	return tables, nil
+}
+
+// validateListener checks a listener for settings that cannot work.
+func validateListener(c listenerConfig) error {
+	switch c.Network {
+	case "tcp", "tcp4", "tcp6", "unix":
+	default:
+		return fmt.Errorf("listener %q: unsupported network %q", c.Name, c.Network)
+	}
+	if c.Address == "" {
+		return fmt.Errorf("listener %q: address must be set", c.Name)
+	}
+	if c.isTLS() && !c.ACME && ((c.TLSCert == "") != (c.TLSKey == "") || len(c.certPairs()) == 0) {
+		return fmt.Errorf("listener %q: both tlsCert and tlsKey must be set", c.Name)
+	}
+	if c.isTLS() && c.ClientAuth >= tls.VerifyClientCertIfGiven && c.ClientCA == "" {
+		return fmt.Errorf("listener %q: verifying client certificates requires tlsClientCA", c.Name)
+	}
+	if err := c.Limits.validate(); err != nil {
+		return fmt.Errorf("listener %q: %w", c.Name, err)
+	}
+	return nil
+}
+
+// newListenerMux registers the routes enabled for the listener on a new
+// ServeMux. A listener without a route list gets every route.
+func newListenerMux(c listenerConfig, handlers map[string]http.HandlerFunc) (*http.ServeMux, error) {
+	routes := c.Routes
+	if routes == nil {
+		routes = slices.Sorted(maps.Keys(handlers))
+	}
+	mux := http.NewServeMux()
+	for _, r := range routes {
+		hf, ok := handlers[r]
+		if !ok {
+			return nil, fmt.Errorf("listener %q: unknown route %q", c.Name, r)
+		}
+		mux.HandleFunc(r, hf)
+	}
+	return mux, nil
+}
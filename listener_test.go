@@ -0,0 +1,209 @@
+package main
+
+import (
+	"bytes"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/spf13/viper"
+	"github.com/tuggan/goip/logger"
+)
+
+// configFromTOML returns a viper instance holding the given TOML document.
+func configFromTOML(t *testing.T, doc string) *viper.Viper {
+	t.Helper()
+	v := viper.New()
+	v.SetConfigType("toml")
+	if err := v.ReadConfig(strings.NewReader(doc)); err != nil {
+		t.Fatalf("failed to read config: %v", err)
+	}
+	return v
+}
+
+func TestLoadListeners_LegacyEndpoints(t *testing.T) {
+	v := configFromTOML(t, `
+endpoint = ["127.0.0.1:3000", "127.0.0.1:3001"]
+tlsEndpoint = "127.0.0.1:3443"
+tlsCert = "cert.pem"
+tlsKey = "key.pem"
+rateLimit = 2.5
+[https]
+writeTimeout = "1m"
+`)
+	got, err := loadListeners(v)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if len(got) != 3 {
+		t.Fatalf("expected 3 listeners, got %d", len(got))
+	}
+	if got[0].Name != "http" || got[0].Address != "127.0.0.1:3000" || got[0].isTLS() {
+		t.Errorf("unexpected first listener %+v", got[0])
+	}
+	if got[2].Name != "https" || !got[2].isTLS() || got[2].TLSCert != "cert.pem" {
+		t.Errorf("unexpected TLS listener %+v", got[2])
+	}
+	if got[2].Limits.WriteTimeout != time.Minute {
+		t.Errorf("expected https writeTimeout 1m, got %s", got[2].Limits.WriteTimeout)
+	}
+	if got[0].Limits.WriteTimeout != 10*time.Second {
+		t.Errorf("expected http writeTimeout to stay at the default, got %s", got[0].Limits.WriteTimeout)
+	}
+	if got[0].RateLimitBurst != 3 {
+		t.Errorf("expected burst to default to ceil(rateLimit) = 3, got %d", got[0].RateLimitBurst)
+	}
+}
+
+func TestLoadListeners_Tables(t *testing.T) {
+	v := configFromTOML(t, `
+trustedProxy = ["10.0.0.1"]
+rateLimit = 10
+tlsCert = "global.crt"
+tlsKey = "global.key"
+
+[[listener]]
+name = "public"
+address = "0.0.0.0:80"
+network = "tcp4"
+routes = ["/", "/health"]
+
+[[listener]]
+name = "internal"
+address = "127.0.0.1:8443"
+tls = true
+tlsCert = "internal.crt"
+trustedProxies = []
+rateLimit = 0
+idleTimeout = "5m"
+`)
+	got, err := loadListeners(v)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if len(got) != 2 {
+		t.Fatalf("expected only the two tables to be used, got %d listeners", len(got))
+	}
+
+	public := got[0]
+	if public.Network != "tcp4" || public.isTLS() {
+		t.Errorf("unexpected public listener %+v", public)
+	}
+	if len(public.TrustedProxies) != 1 || public.RateLimit != 10 {
+		t.Errorf("expected public listener to inherit global settings, got %+v", public)
+	}
+	if len(public.Routes) != 2 {
+		t.Errorf("expected 2 routes, got %v", public.Routes)
+	}
+
+	internal := got[1]
+	if !internal.isTLS() || internal.TLSCert != "internal.crt" || internal.TLSKey != "global.key" {
+		t.Errorf("expected table cert with global key, got %+v", internal)
+	}
+	if len(internal.TrustedProxies) != 0 || internal.RateLimit != 0 {
+		t.Errorf("expected internal listener to override global settings, got %+v", internal)
+	}
+	if internal.Limits.IdleTimeout != 5*time.Minute {
+		t.Errorf("expected idleTimeout 5m, got %s", internal.Limits.IdleTimeout)
+	}
+	if internal.Routes != nil {
+		t.Errorf("expected all routes when none are listed, got %v", internal.Routes)
+	}
+}
+
+func TestLoadListeners_Invalid(t *testing.T) {
+	tests := map[string]string{
+		"network": `
+[[listener]]
+address = "127.0.0.1:80"
+network = "udp"
+`,
+		"address": `
+[[listener]]
+name = "empty"
+`,
+		"tls": `
+[[listener]]
+address = "127.0.0.1:443"
+tls = true
+`,
+	}
+	for name, doc := range tests {
+		t.Run(name, func(t *testing.T) {
+			if _, err := loadListeners(configFromTOML(t, doc)); err == nil {
+				t.Error("expected an error")
+			}
+		})
+	}
+}
+
+func TestNewListenerMux(t *testing.T) {
+	handlers := map[string]http.HandlerFunc{
+		"/a": func(w http.ResponseWriter, r *http.Request) { w.WriteHeader(http.StatusOK) },
+		"/b": func(w http.ResponseWriter, r *http.Request) { w.WriteHeader(http.StatusAccepted) },
+	}
+
+	mux, err := newListenerMux(listenerConfig{Routes: []string{"/b"}}, handlers)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	for path, want := range map[string]int{"/a": http.StatusNotFound, "/b": http.StatusAccepted} {
+		w := httptest.NewRecorder()
+		mux.ServeHTTP(w, httptest.NewRequest(http.MethodGet, path, nil))
+		if w.Code != want {
+			t.Errorf("%s: expected %d, got %d", path, want, w.Code)
+		}
+	}
+
+	if _, err := newListenerMux(listenerConfig{Routes: []string{"/c"}}, handlers); err == nil {
+		t.Error("expected an error for an unknown route")
+	}
+}
+
+func TestLoadListeners_UnixEndpoint(t *testing.T) {
+	v := configFromTOML(t, `
+endpoint = ["unix:/run/goip/goip.sock"]
+socketMode = "0660"
+socketGroup = "www-data"
+`)
+	got, err := loadListeners(v)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if got[0].Network != "unix" || got[0].Address != "/run/goip/goip.sock" {
+		t.Errorf("expected unix socket listener, got %+v", got[0])
+	}
+	if got[0].SocketMode != 0o660 || got[0].SocketGroup != "www-data" {
+		t.Errorf("expected socket permissions to be set, got %+v", got[0])
+	}
+}
+
+func TestNewListener_UnixRateLimitWarning(t *testing.T) {
+	themes, err := loadThemes(configFromTOML(t, ``), templateFS(""), nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, tt := range []struct {
+		name    string
+		trusted []string
+		warn    bool
+	}{
+		{"untrusted", nil, true},
+		{"trusted", []string{"unix"}, false},
+	} {
+		var warnings bytes.Buffer
+		logger.Init(io.Discard, io.Discard, &warnings, io.Discard)
+		l, err := newListener(listenerConfig{Name: "proxy", Network: "unix", Address: "/run/goip/goip.sock",
+			RateLimit: 10, RateLimitBurst: 20, TrustedProxies: tt.trusted}, handlerOptions{themes: themes, staticDir: "static"})
+		if err != nil {
+			t.Fatal(err)
+		}
+		l.rl.Stop()
+		if got := strings.Contains(warnings.String(), "share one rate limit"); got != tt.warn {
+			t.Errorf("%s: expected a warning %v, got %q", tt.name, tt.warn, warnings.String())
+		}
+	}
+}
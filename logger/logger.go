@@ -6,6 +6,9 @@ import (
 	"net/http"
 )
 
+// accessFields returns extra fields for the access log line of a request.
+var accessFields func(*http.Request) string
+
 var logger struct {
 	trace   *log.Logger
 	info    *log.Logger
@@ -33,5 +36,17 @@ func Error(format string, v ...interface{}) {
 }
 
 func Access(r *http.Request, status int) {
+	if accessFields != nil {
+		if fields := accessFields(r); fields != "" {
+			logger.info.Printf("[Info] [%d] %s: %s %s", status, r.RemoteAddr, r.URL.Path, fields)
+			return
+		}
+	}
 	logger.info.Printf("[Info] [%d] %s: %s", status, r.RemoteAddr, r.URL.Path)
 }
+
+// SetAccessFields makes Access append the fields f returns for a request,
+// unless they are empty. It must be called before requests are logged.
+func SetAccessFields(f func(*http.Request) string) {
+	accessFields = f
+}
@@ -61,6 +61,27 @@ func TestAccess(t *testing.T) {
 	}
 }
 
+func TestAccess_Fields(t *testing.T) {
+	var buf bytes.Buffer
+	Init(&buf, &buf, &buf, &buf)
+	SetAccessFields(func(r *http.Request) string { return r.Header.Get("X-Fields") })
+	defer SetAccessFields(nil)
+
+	req := httptest.NewRequest(http.MethodGet, "/ip", nil)
+	req.Header.Set("X-Fields", "ja4=t13d1516h2_8daaf6152771_e5627efa2ab1")
+	Access(req, http.StatusOK)
+	if out := buf.String(); !strings.HasSuffix(out, ": /ip ja4=t13d1516h2_8daaf6152771_e5627efa2ab1\n") {
+		t.Errorf("expected the fields after the path, got: %q", out)
+	}
+
+	buf.Reset()
+	req.Header.Del("X-Fields")
+	Access(req, http.StatusOK)
+	if out := buf.String(); !strings.HasSuffix(out, ": /ip\n") {
+		t.Errorf("expected no trailing fields, got: %q", out)
+	}
+}
+
 func TestAccess_DifferentStatus(t *testing.T) {
 	var buf bytes.Buffer
 	Init(&buf, &buf, &buf, &buf)
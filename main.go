@@ -4,19 +4,25 @@ import (
 	"context"
 	"fmt"
 	"io"
-	"log"
-	"math"
+	"maps"
 	"net"
 	"net/http"
 	"os"
 	"os/signal"
+	"slices"
+	"strings"
 	"sync"
+	"sync/atomic"
+	"syscall"
 	"time"
 
 	"github.com/spf13/pflag"
 	"github.com/spf13/viper"
+	"github.com/tuggan/goip/health"
 	"github.com/tuggan/goip/logger"
+	"github.com/tuggan/goip/systemd"
 	"github.com/tuggan/goip/web"
+	"golang.org/x/crypto/acme/autocert"
 )
 
 var (
@@ -27,6 +33,10 @@ var (
 	email   = "dennis@vestern.se"
 )
 
+// shutdownSignals make GoIP drain and shut down. SIGTERM is what docker
+// stop and Kubernetes send.
+var shutdownSignals = []os.Signal{os.Interrupt, syscall.SIGTERM, syscall.SIGQUIT}
+
 func printVersion() {
 	fmt.Printf("GoIP %s (%s) branch %s © Dennis Vesterlund <dennis@vestern.se>\n", Version, Date, Branch)
 }
@@ -38,16 +48,24 @@ func printHelp() {
 	pflag.PrintDefaults()
 }
 
-func serve(wg *sync.WaitGroup, srv *http.Server, l net.Listener, certFile, keyFile string) {
+// serve runs srv on l until the server is shut down. Any other error is
+// reported on errc so main can shut every server down cleanly.
+func serve(wg *sync.WaitGroup, errc chan<- error, srv *http.Server, l net.Listener) {
 	defer wg.Done()
 
-	if certFile != "" && keyFile != "" {
-		if err := srv.ServeTLS(l, certFile, keyFile); err != http.ErrServerClosed {
-			log.Fatalf("HTTP server ServeTLS: %v", err)
-		}
+	var err error
+	// TLS listeners get their certificates from TLSConfig.GetCertificate,
+	// so they can be replaced without restarting the server.
+	if srv.TLSConfig != nil && srv.TLSConfig.GetCertificate != nil {
+		err = srv.ServeTLS(l, "", "")
 	} else {
-		if err := srv.Serve(l); err != http.ErrServerClosed {
-			log.Fatalf("HTTP server Serve: %v", err)
+		err = srv.Serve(l)
+	}
+	if err != nil && err != http.ErrServerClosed {
+		select {
+		case errc <- fmt.Errorf("%s: %w", l.Addr(), err):
+		default:
+			// Shutdown has already been triggered.
 		}
 	}
 	logger.Info("Shutting down serve routine")
@@ -61,6 +79,7 @@ func recoveryMiddleware(next http.Handler) http.Handler {
 		defer func() {
 			if rec := recover(); rec != nil {
 				logger.Error("Panic recovered: %v", rec)
+				panicsRecovered.Inc()
 				http.Error(w, "500 Internal Server Error", http.StatusInternalServerError)
 			}
 		}()
@@ -74,8 +93,9 @@ func securityHeadersMiddleware(next http.Handler) http.Handler {
 	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
 		w.Header().Set("X-Content-Type-Options", "nosniff")
 		w.Header().Set("X-Frame-Options", "DENY")
+		// 'self' lets pages use the files served under /static/.
 		w.Header().Set("Content-Security-Policy",
-			"default-src 'none'; style-src 'unsafe-inline'; img-src 'self' data:; script-src 'unsafe-inline';")
+			"default-src 'none'; style-src 'self' 'unsafe-inline'; img-src 'self' data:; script-src 'self' 'unsafe-inline'; font-src 'self';")
 		// Only send HSTS on TLS connections.
 		if r.TLS != nil {
 			w.Header().Set("Strict-Transport-Security",
@@ -86,12 +106,20 @@ func securityHeadersMiddleware(next http.Handler) http.Handler {
 }
 
 func main() {
+	os.Exit(run())
+}
+
+// run starts GoIP and serves until it is shut down, returning the exit
+// code. Returning instead of calling os.Exit lets deferred cleanup run.
+func run() int {
 
 	pflag.StringSliceP("endpoint", "e", []string{"127.0.0.1:3000"}, "Endpoint(s) to listen on (repeatable)")
 	pflag.StringSlice("tlsEndpoint", []string{}, "TLS endpoint(s) to listen on (repeatable)")
 	pflag.String("tlsCert", "", "Path to TLS Certificate file")
 	pflag.String("tlsKey", "", "Path to TLS Key file")
 	pflag.StringSlice("trustedProxy", nil, "Trusted proxy IP or CIDR (repeatable, e.g. --trustedProxy 10.0.0.0/8)")
+	pflag.Bool("httpsRedirect", false, "Redirect requests on plain HTTP listeners to the HTTPS origin")
+	pflag.Bool("h2c", false, "Serve HTTP/2 without TLS on plain HTTP listeners")
 
 	versionFlag := pflag.BoolP("version", "v", false, "Print version and exit")
 	help := pflag.BoolP("help", "h", false, "Print help and exit")
@@ -99,6 +127,19 @@ func main() {
 
 	pflag.Float64("rateLimit", 0, "Maximum requests per second per IP (0 = disabled)")
 	pflag.Int("rateLimitBurst", 0, "Maximum burst size (defaults to rateLimit if not set)")
+	pflag.String("rateLimitKey", "ip", "What clients are rate limited by: ip, ja3, ja4 or a combination such as ip+ja4")
+
+	limits := defaultServerLimits()
+	pflag.Duration("readTimeout", limits.ReadTimeout, "Maximum duration for reading an entire request")
+	pflag.Duration("readHeaderTimeout", limits.ReadHeaderTimeout, "Maximum duration for reading request headers")
+	pflag.Duration("writeTimeout", limits.WriteTimeout, "Maximum duration before timing out writes of a response")
+	pflag.Duration("idleTimeout", limits.IdleTimeout, "Maximum time to wait for the next request on keep-alive connections")
+	pflag.Int("maxHeaderBytes", limits.MaxHeaderBytes, "Maximum size of request headers in bytes")
+	pflag.Bool("templateReload", false, "Reload templates when a file in the template directory changes")
+	pflag.String("adminEndpoint", "", "Address of the admin listener serving operational endpoints (disabled if empty)")
+	pflag.Duration("shutdownTimeout", 10*time.Second, "Maximum time to wait for in-flight requests on shutdown")
+	pflag.Duration("drainPeriod", 0, "Time /health and /readyz fail before listeners are closed on shutdown")
+	pflag.Duration("restartTimeout", 30*time.Second, "Maximum time to wait for the new process on graceful restart")
 
 	pflag.Parse()
 
@@ -106,15 +147,16 @@ func main() {
 
 	if *help {
 		printHelp()
-		os.Exit(0)
+		return 0
 	}
 
 	if *versionFlag {
 		printVersion()
-		os.Exit(0)
+		return 0
 	}
 
 	logger.Init(io.Discard, os.Stdout, os.Stdout, os.Stderr)
+	logger.SetAccessFields(web.AccessLogFields)
 
 	viper.SetConfigName("goip")
 	viper.AddConfigPath(*configFile)
@@ -123,13 +165,11 @@ func main() {
 	viper.AddConfigPath("/etc/goip/")
 	viper.AddConfigPath("config/")
 
-	err := viper.ReadInConfig()
-	if err != nil {
+	configErr := viper.ReadInConfig()
+	if err := configErr; err != nil {
 		logger.Error("Error with config file: %s", err)
 	}
 
-	addr := viper.GetStringSlice("endpoint")
-
 	logger.Info("Starting %s", os.Args[0])
 	if 0 < len(os.Args[1:]) {
 		logger.Info("Arguments: %s", os.Args[1:])
@@ -139,123 +179,363 @@ func main() {
 	if viper.IsSet("templateDir") {
 		t = viper.GetString("templateDir")
 	}
+	var staticDir = "static"
+	if viper.IsSet("staticDir") {
+		staticDir = viper.GetString("staticDir")
+	}
+	var staticMaxAge = time.Hour
+	if viper.IsSet("staticMaxAge") {
+		staticMaxAge = viper.GetDuration("staticMaxAge")
+	}
+
+	vhostConfigs, err := loadVHosts(viper.GetViper())
+	if err != nil {
+		logger.Error("Error in vhost configuration: %s", err)
+		return 1
+	}
+	vhosts, err := web.NewVHosts(vhostConfigs)
+	if err != nil {
+		logger.Error("Error in vhost configuration: %s", err)
+		return 1
+	}
+	themes, err := loadThemes(viper.GetViper(), templateFS(t), vhostConfigs)
+	if err != nil {
+		logger.Error("Error in templates: %s", err)
+		return 1
+	}
+	if viper.GetBool("templateReload") {
+		watcher, err := themes.Watch(t)
+		if err != nil {
+			logger.Error("Failed to watch template directory %s: %s", t, err)
+			return 1
+		}
+		defer watcher.Close()
+		logger.Info("Reloading templates on changes in %s", t)
+	}
 
 	var egzip = true
 	if viper.IsSet("enablegzip") {
 		egzip = viper.GetBool("enablegzip")
 	}
 
-	var tlsEndpoint []string
-	if viper.IsSet("tlsEndpoint") {
-		tlsEndpoint = viper.GetStringSlice("tlsEndpoint")
-	}
-
-	var tlsCert = ""
-	if viper.IsSet("tlsCert") {
-		tlsCert = viper.GetString("tlsCert")
+	configs, err := loadListeners(viper.GetViper())
+	if err != nil {
+		logger.Error("Error in listener configuration: %s", err)
+		return 1
 	}
 
-	var tlsKey = ""
-	if viper.IsSet("tlsKey") {
-		tlsKey = viper.GetString("tlsKey")
+	acmeCfg, err := loadACME(viper.GetViper())
+	if err != nil {
+		logger.Error("Error in ACME configuration: %s", err)
+		return 1
 	}
-
-	trustedProxies := viper.GetStringSlice("trustedProxy")
-
-	rateLimit := viper.GetFloat64("rateLimit")
-	rateLimitBurst := viper.GetInt("rateLimitBurst")
-	if rateLimitBurst <= 0 {
-		rateLimitBurst = int(math.Ceil(rateLimit))
-		if rateLimitBurst < 1 {
-			rateLimitBurst = 1
+	var certManager *autocert.Manager
+	if acmeCfg != nil {
+		if certManager, err = newACMEManager(acmeCfg); err != nil {
+			logger.Error("Error in ACME configuration: %s", err)
+			return 1
 		}
+		logger.Info("Obtaining certificates for %s from %s", strings.Join(acmeCfg.Domains, ", "), acmeCfg.Directory)
 	}
 
-	handler := http.NewServeMux()
-
-	h := web.NewHandler(egzip, t, Version, Branch, Date, author, email, trustedProxies)
-	rateLimiter := web.NewRateLimiter(rateLimit, rateLimitBurst, 10*time.Minute)
-	defer rateLimiter.Stop()
-	handler.HandleFunc("/", h.MainHandler)
-	handler.HandleFunc("/GET", h.GETHandler)
-	handler.HandleFunc("/favicon.ico", h.FaviconHandler)
-	handler.HandleFunc("/robots.txt", h.RobotsHandler)
-	handler.HandleFunc("/health", h.HealthHandler)
-
-	// Wrap the mux with rate limiting, panic recovery, and security headers.
-	wrappedHandler := recoveryMiddleware(rateLimiter.Middleware(securityHeadersMiddleware(handler)))
-
-	// Separate server instances for TLS and plain HTTP.
-	// Go's http.Server docs state Serve/ServeTLS must not be called
-	// concurrently on the same server.
-	var plainSrv http.Server
-	plainSrv.Handler = wrappedHandler
-	plainSrv.ReadTimeout = 10 * time.Second
-	plainSrv.ReadHeaderTimeout = 5 * time.Second
-	plainSrv.WriteTimeout = 10 * time.Second
-	plainSrv.IdleTimeout = 60 * time.Second
-	plainSrv.MaxHeaderBytes = 1 << 20 // 1 MB
-
-	var tlsSrv http.Server
-	tlsSrv.Handler = wrappedHandler
-	tlsSrv.ReadTimeout = 10 * time.Second
-	tlsSrv.ReadHeaderTimeout = 5 * time.Second
-	tlsSrv.WriteTimeout = 10 * time.Second
-	tlsSrv.IdleTimeout = 60 * time.Second
-	tlsSrv.MaxHeaderBytes = 1 << 20 // 1 MB
+	admin, err := loadAdminConfig(viper.GetViper())
+	if err != nil {
+		logger.Error("Error in admin configuration: %s", err)
+		return 1
+	}
 
-	var wg sync.WaitGroup
+	shutdownTimeout := viper.GetDuration("shutdownTimeout")
+	restartTimeout := viper.GetDuration("restartTimeout")
+	drainPeriod := viper.GetDuration("drainPeriod")
 
-	go func() {
-		sigint := make(chan os.Signal, 1)
-		signal.Notify(sigint, os.Interrupt)
-		<-sigint
+	tracer, err := newTracer(viper.GetViper())
+	if err != nil {
+		logger.Error("Error in tracing configuration: %s", err)
+		return 1
+	}
+	if tracer != nil {
+		defer func() {
+			ctx, cancel := context.WithTimeout(context.Background(), shutdownTimeout)
+			defer cancel()
+			if err := tracer.Shutdown(ctx); err != nil {
+				logger.Warning("Failed to flush spans: %s", err)
+			}
+		}()
+	}
 
-		logger.Info("Shutting down server")
+	// Set during the drain period before shutdown.
+	var draining atomic.Bool
+	// Set once every listener is bound.
+	var bound atomic.Bool
+	livez := health.NewRegistry("livez")
+	readyz := health.NewRegistry("readyz")
+	registerLivenessChecks(livez)
+	opts := handlerOptions{
+		gzip:         egzip,
+		themes:       themes,
+		vhosts:       vhosts,
+		staticDir:    staticDir,
+		staticMaxAge: staticMaxAge,
+		draining:     &draining,
+		livez:        livez,
+		readyz:       readyz,
+		tracer:       tracer,
+		bans:         web.NewBanList(),
+		acme:         certManager,
+	}
 
-		shutdownCtx, shutdownRelease := context.WithTimeout(context.Background(), 10*time.Second)
-		defer shutdownRelease()
+	// Sockets passed in by systemd, or by the previous process on a
+	// graceful restart, replace the configured address of the listener
+	// with the same name. The remaining ones get a listener of their own.
+	inherited := claimInheritedListeners()
+	activated, err := systemd.Listeners()
+	if err != nil {
+		logger.Error("Error with socket activation: %s", err)
+		return 1
+	}
+	if inherited {
+		// This process is now responsible for removing unix socket
+		// files on shutdown.
+		for _, lns := range activated {
+			for _, ln := range lns {
+				if ul, ok := ln.(*net.UnixListener); ok {
+					ul.SetUnlinkOnClose(true)
+				}
+			}
+		}
+	}
 
-		// Shutdown both servers. Calling Shutdown on a server that
-		// never had Serve called is a safe no-op.
-		if err := plainSrv.Shutdown(shutdownCtx); err != nil {
-			log.Printf("Plain HTTP server Shutdown: %v", err)
+	// Every listener gets its own server, handler and rate limiter so
+	// trusted proxies, rate limits, routes and timeouts can differ
+	// between them. Go's http.Server docs state Serve/ServeTLS must not
+	// be called concurrently on the same server.
+	var listeners []*listener
+	claimed := make(map[string]bool)
+	for _, c := range configs {
+		lns, ok := activated[c.Name]
+		if ok && claimed[c.Name] {
+			// Further endpoints sharing the name are replaced as well.
+			continue
+		}
+		claimed[c.Name] = ok
+		l, err := newListener(c, opts)
+		if err != nil {
+			logger.Error("Error in listener configuration: %s", err)
+			return 1
+		}
+		l.lns = lns
+		listeners = append(listeners, l)
+	}
+	if admin != nil {
+		if _, ok := claimed[admin.Name]; ok {
+			logger.Error("Error in listener configuration: listener name %q is reserved for the admin listener", admin.Name)
+			return 1
+		}
+		routes := adminRoutes(admin, viper.GetViper(), opts.bans, func() []*listener { return listeners })
+		// Only the admin listener tells why a check failed.
+		maps.Copy(routes, livez.Routes())
+		maps.Copy(routes, readyz.Routes())
+		l, err := newAdminListener(admin, routes)
+		if err != nil {
+			logger.Error("Error in admin configuration: %s", err)
+			return 1
+		}
+		l.lns, claimed[admin.Name] = activated[admin.Name]
+		listeners = append(listeners, l)
+	}
+	for name, lns := range activated {
+		if claimed[name] {
+			continue
+		}
+		c, err := activatedListener(viper.GetViper(), name)
+		if err == nil {
+			var l *listener
+			if l, err = newListener(c, opts); err == nil {
+				l.lns = lns
+				listeners = append(listeners, l)
+			}
+		}
+		if err != nil {
+			logger.Error("Error in listener configuration: %s", err)
+			return 1
 		}
-		if err := tlsSrv.Shutdown(shutdownCtx); err != nil {
-			log.Printf("TLS server Shutdown: %v", err)
+	}
+	defer func() {
+		for _, l := range listeners {
+			l.rl.Stop()
 		}
 	}()
-
-	if len(tlsEndpoint) > 0 {
-		if tlsKey == "" || tlsCert == "" {
-			log.Fatal("Both certFile and keyFile must be set")
+	for _, l := range listeners {
+		if l.tickets != nil {
+			defer l.tickets.Start().Close()
+		}
+		if l.certs == nil {
+			continue
 		}
+		watcher, err := l.certs.Watch()
+		if err != nil {
+			logger.Error("Failed to watch the certificates of listener %s: %s", l.cfg.Name, err)
+			return 1
+		}
+		defer watcher.Close()
+	}
+	registerProcessMetrics(listeners)
+	registerReadinessChecks(readyz, readinessState{
+		configErr: configErr,
+		themes:    themes,
+		listeners: listeners,
+		bound:     &bound,
+		draining:  &draining,
+	})
 
-		for _, e := range tlsEndpoint {
-			tlsListener, err := net.Listen("tcp", e)
+	var wg sync.WaitGroup
+	var exitCode atomic.Int32
+	serveErr := make(chan error, 1)
+
+	// Signals are caught from here on so one arriving while the sockets
+	// are bound does not terminate the process, but are only handled below
+	// once every listener is bound: a restart hands all sockets to the new
+	// process.
+	sigs := make(chan os.Signal, 1)
+	signal.Notify(sigs, shutdownSignals...)
+	if len(restartSignals) > 0 {
+		signal.Notify(sigs, restartSignals...)
+	}
+	if len(reloadSignals) > 0 {
+		signal.Notify(sigs, reloadSignals...)
+	}
+
+	for _, l := range listeners {
+		if l.lns == nil {
+			ln, err := listen(l.cfg)
 			if err != nil {
 				logger.Error("Error binding listening socket: %s", err)
-				os.Exit(1)
+				// Closing the bound sockets removes their socket
+				// files.
+				for _, l := range listeners {
+					l.srv.Close()
+				}
+				wg.Wait()
+				return 1
+			}
+			l.lns = []net.Listener{ln}
+			logger.Info("Starting server %s on %s", l.cfg.Name, l.cfg.url())
+		} else {
+			for _, ln := range l.lns {
+				logger.Info("Starting server %s on inherited socket %s", l.cfg.Name, ln.Addr())
 			}
-			logger.Info("Starting HTTPS server on https://%s", e)
+		}
 
+		for _, ln := range l.lns {
 			wg.Add(1)
-			go serve(&wg, &tlsSrv, tlsListener, tlsCert, tlsKey)
+			go serve(&wg, serveErr, l.srv, ln)
 		}
 	}
 
-	for _, e := range addr {
-		listener, err := net.Listen("tcp", e)
-		if err != nil {
-			logger.Error("Error binding listening socket: %s", err)
-			os.Exit(1)
+	go func() {
+		restarted := false
+	wait:
+		for !restarted {
+			select {
+			case sig := <-sigs:
+				if slices.Contains(reloadSignals, sig) {
+					logger.Info("Reloading certificates")
+					reloadCertificates(listeners)
+					continue
+				}
+				if !slices.Contains(restartSignals, sig) {
+					logger.Info("Received %s", sig)
+					break wait
+				}
+				logger.Info("Graceful restart requested")
+				if err := restart(listeners, restartTimeout); err != nil {
+					logger.Error("Graceful restart failed: %s", err)
+					continue
+				}
+				restarted = true
+			case err := <-serveErr:
+				logger.Error("Server failed: %s", err)
+				exitCode.Store(1)
+				break wait
+			}
+		}
+
+		logger.Info("Shutting down server")
+		// After a restart the new process is the one systemd tracks.
+		if !restarted {
+			if _, err := systemd.Notify(systemd.Stopping); err != nil {
+				logger.Warning("Failed to notify systemd: %s", err)
+			}
 		}
-		logger.Info("Starting HTTP server on http://%s", e)
-		wg.Add(1)
-		go serve(&wg, &plainSrv, listener, "", "")
+
+		// Fail health checks first so load balancers stop sending new
+		// requests while the listeners are still open. A second shutdown
+		// signal cuts the drain period short, reloads are still handled.
+		// After a restart the new process accepts on the same sockets and
+		// answers the health checks, so there is nothing to drain.
+		if drainPeriod > 0 && !restarted && exitCode.Load() == 0 {
+			draining.Store(true)
+			logger.Info("Draining for %s", drainPeriod)
+			drained := time.After(drainPeriod)
+		drain:
+			for {
+				select {
+				case <-drained:
+					break drain
+				case sig := <-sigs:
+					switch {
+					case slices.Contains(shutdownSignals, sig):
+						logger.Info("Received %s, ending the drain period", sig)
+						break drain
+					case slices.Contains(reloadSignals, sig):
+						logger.Info("Reloading certificates")
+						reloadCertificates(listeners)
+					default:
+						logger.Info("Ignoring %s while draining", sig)
+					}
+				}
+			}
+		}
+
+		shutdownCtx, shutdownRelease := context.WithTimeout(context.Background(), shutdownTimeout)
+		defer shutdownRelease()
+
+		// Calling Shutdown on a server that never had Serve called is a
+		// safe no-op. Connections still open at the deadline are closed.
+		for _, l := range listeners {
+			if err := l.srv.Shutdown(shutdownCtx); err != nil {
+				logger.Warning("Server %s Shutdown: %v", l.cfg.Name, err)
+				l.srv.Close()
+			}
+		}
+	}()
+
+	bound.Store(true)
+
+	if err := notifyParentReady(); err != nil {
+		logger.Warning("Failed to notify parent process: %s", err)
+	}
+	if _, err := systemd.Notify(systemd.Ready); err != nil {
+		logger.Warning("Failed to notify systemd: %s", err)
+	}
+	if interval := systemd.WatchdogInterval(); interval > 0 {
+		go watchdog(interval / 2)
 	}
 
 	logger.Info("Waiting for waitgroups")
 	wg.Wait()
 	logger.Info("Shutting down GoIP server")
+	return int(exitCode.Load())
+}
+
+// watchdog keeps the systemd watchdog from restarting the service for as
+// long as the process is running.
+func watchdog(interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for range ticker.C {
+		if _, err := systemd.Notify(systemd.Watchdog); err != nil {
+			logger.Warning("Failed to notify systemd watchdog: %s", err)
+		}
+	}
 }
@@ -2,13 +2,17 @@ package main
 
 import (
 	"bytes"
+	"context"
 	"crypto/tls"
 	"io"
+	"net"
 	"net/http"
 	"net/http/httptest"
 	"os"
 	"strings"
+	"sync"
 	"testing"
+	"time"
 
 	"github.com/spf13/pflag"
 	"github.com/tuggan/goip/logger"
@@ -107,6 +111,10 @@ func TestSecurityHeadersMiddleware_SetsHeaders(t *testing.T) {
 		t.Errorf("expected img-src to include 'self' for same-origin images (favicon), got: %q",
 			gotHeaders.Get("Content-Security-Policy"))
 	}
+	if !strings.Contains(gotHeaders.Get("Content-Security-Policy"), "style-src 'self'") {
+		t.Errorf("expected style-src to include 'self' for static stylesheets, got: %q",
+			gotHeaders.Get("Content-Security-Policy"))
+	}
 }
 
 func TestSecurityHeadersMiddleware_HSTSWithoutTLS(t *testing.T) {
@@ -150,6 +158,62 @@ func TestSecurityHeadersMiddleware_HSTSWithTLS(t *testing.T) {
 	}
 }
 
+func TestServe_ReportsError(t *testing.T) {
+	logger.Init(io.Discard, io.Discard, io.Discard, io.Discard)
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("failed to listen: %v", err)
+	}
+	// A closed listener makes Serve fail immediately.
+	ln.Close()
+
+	var wg sync.WaitGroup
+	errc := make(chan error, 1)
+	wg.Add(1)
+	serve(&wg, errc, &http.Server{}, ln)
+	wg.Wait()
+
+	select {
+	case err := <-errc:
+		if err == nil {
+			t.Error("expected a non-nil error")
+		}
+	default:
+		t.Error("expected serve to report the error instead of exiting")
+	}
+}
+
+func TestServe_ShutdownIsNotAnError(t *testing.T) {
+	logger.Init(io.Discard, io.Discard, io.Discard, io.Discard)
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("failed to listen: %v", err)
+	}
+
+	srv := &http.Server{}
+	var wg sync.WaitGroup
+	errc := make(chan error, 1)
+	wg.Add(1)
+	go serve(&wg, errc, srv, ln)
+
+	// Wait for the server to start before shutting it down.
+	for i := 0; i < 100; i++ {
+		if c, err := net.Dial("tcp", ln.Addr().String()); err == nil {
+			c.Close()
+			break
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	srv.Shutdown(context.Background())
+	wg.Wait()
+
+	select {
+	case err := <-errc:
+		t.Errorf("expected no error on shutdown, got %v", err)
+	default:
+	}
+}
+
 func TestPrintVersion_EmptyVersion(t *testing.T) {
 	// Save and restore Version
 	origVersion := Version
@@ -182,7 +246,7 @@ func TestFaviconHandler_ThroughSecurityHeadersMiddleware(t *testing.T) {
 	// recoveryMiddleware → securityHeadersMiddleware → handler mux
 	handler := http.NewServeMux()
 	h := web.NewHandler(true, "html/", "v0.8", "master", "2026-05-18", "Author", "dennis@vestern.se", nil)
-	handler.HandleFunc("/favicon.ico", h.FaviconHandler)
+	handler.HandleFunc("/favicon.ico", h.StaticHandler)
 	handler.HandleFunc("/", h.MainHandler)
 
 	wrapped := recoveryMiddleware(securityHeadersMiddleware(handler))
@@ -205,6 +269,9 @@ func TestFaviconHandler_ThroughSecurityHeadersMiddleware(t *testing.T) {
 	if len(body) == 0 {
 		t.Error("expected non-empty favicon body")
 	}
+	if ct := resp.Header.Get("Content-Type"); ct != "image/x-icon" {
+		t.Errorf("expected Content-Type image/x-icon, got %q", ct)
+	}
 	// Verify the response still gets security headers
 	if resp.Header.Get("X-Content-Type-Options") != "nosniff" {
 		t.Errorf("expected X-Content-Type-Options: nosniff, got %q", resp.Header.Get("X-Content-Type-Options"))
@@ -0,0 +1,135 @@
+package main
+
+import (
+	"bytes"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/tuggan/goip/logger"
+	"github.com/tuggan/goip/metrics"
+)
+
+// metricsRegistry holds every metric served on /metrics.
+var metricsRegistry = metrics.NewRegistry()
+
+var (
+	requestsTotal = metricsRegistry.NewCounterVec("goip_http_requests_total",
+		"HTTP requests by listener, route and status code.", "listener", "route", "code")
+	requestDuration = metricsRegistry.NewHistogramVec("goip_http_request_duration_seconds",
+		"HTTP request latency by listener and route.", metrics.DefBuckets, "listener", "route")
+	responseBytes = metricsRegistry.NewCounterVec("goip_http_response_bytes_total",
+		"Bytes written in HTTP response bodies by listener and route.", "listener", "route")
+	gzipResponses = metricsRegistry.NewCounterVec("goip_http_gzip_responses_total",
+		"Responses to clients accepting gzip, by whether the response was compressed.", "compressed")
+	panicsRecovered = metricsRegistry.NewCounterVec("goip_panics_recovered_total",
+		"Panics in handlers recovered by the server.")
+	tlsHandshakeErrors = metricsRegistry.NewCounterVec("goip_tls_handshake_errors_total",
+		"Failed TLS handshakes by listener.", "listener")
+)
+
+// registerProcessMetrics adds the metrics read from the listeners and the
+// build information. It is called once the listeners are created.
+func registerProcessMetrics(listeners []*listener) {
+	metricsRegistry.NewFunc("goip_build_info", "Version, branch and commit date GoIP was built from.",
+		metrics.Gauge, []string{"version", "branch", "date"},
+		func(emit func(float64, ...string)) {
+			emit(1, Version, Branch, Date)
+		})
+	metricsRegistry.NewFunc("goip_ratelimit_decisions_total", "Rate limit decisions by listener.",
+		metrics.Counter, []string{"listener", "decision"},
+		func(emit func(float64, ...string)) {
+			for _, l := range listeners {
+				allowed, denied := l.rl.Stats()
+				emit(float64(allowed), l.cfg.Name, "allow")
+				emit(float64(denied), l.cfg.Name, "deny")
+			}
+		})
+	metricsRegistry.NewFunc("goip_tls_certificate_expiry_timestamp_seconds",
+		"Time the certificates served by each listener expire, in seconds since the epoch.",
+		metrics.Gauge, []string{"listener", "names"},
+		func(emit func(float64, ...string)) {
+			for _, l := range listeners {
+				if l.certs == nil {
+					continue
+				}
+				leaves, _ := l.certs.leaves()
+				for _, leaf := range leaves {
+					emit(float64(leaf.NotAfter.Unix()), l.cfg.Name, strings.Join(certNames(leaf), ","))
+				}
+			}
+		})
+	metricsRegistry.NewFunc("goip_ratelimit_visitors", "Clients tracked by the rate limiter of each listener.",
+		metrics.Gauge, []string{"listener"},
+		func(emit func(float64, ...string)) {
+			for _, l := range listeners {
+				emit(float64(l.rl.Visitors()), l.cfg.Name)
+			}
+		})
+}
+
+// metricsMiddleware records request count, latency and response size for
+// the listener. The route is the ServeMux pattern that matched, so the
+// number of series stays bounded whatever paths clients request.
+func metricsMiddleware(listenerName string, next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		start := time.Now()
+		rec := &responseRecorder{ResponseWriter: w}
+		next.ServeHTTP(rec, r)
+		if rec.code == 0 {
+			rec.code = http.StatusOK
+		}
+
+		route := r.Pattern
+		requestsTotal.Inc(listenerName, route, strconv.Itoa(rec.code))
+		requestDuration.Observe(time.Since(start).Seconds(), listenerName, route)
+		responseBytes.Add(float64(rec.bytes), listenerName, route)
+		if strings.Contains(r.Header.Get("Accept-Encoding"), "gzip") {
+			compressed := rec.Header().Get("Content-Encoding") == "gzip"
+			gzipResponses.Inc(strconv.FormatBool(compressed))
+		}
+	})
+}
+
+// responseRecorder remembers the status code and body size of a response.
+type responseRecorder struct {
+	http.ResponseWriter
+	code  int
+	bytes int64
+}
+
+func (r *responseRecorder) WriteHeader(code int) {
+	if r.code == 0 {
+		r.code = code
+	}
+	r.ResponseWriter.WriteHeader(code)
+}
+
+func (r *responseRecorder) Write(p []byte) (int, error) {
+	if r.code == 0 {
+		r.code = http.StatusOK
+	}
+	n, err := r.ResponseWriter.Write(p)
+	r.bytes += int64(n)
+	return n, err
+}
+
+// Unwrap lets http.ResponseController reach the underlying writer.
+func (r *responseRecorder) Unwrap() http.ResponseWriter {
+	return r.ResponseWriter
+}
+
+// serverErrorLog receives the error log of a listener's http.Server. It
+// counts failed TLS handshakes and passes every line on to the logger.
+type serverErrorLog struct {
+	listener string
+}
+
+func (l serverErrorLog) Write(p []byte) (int, error) {
+	if bytes.Contains(p, []byte("TLS handshake error")) {
+		tlsHandshakeErrors.Inc(l.listener)
+	}
+	logger.Warning("Server %s: %s", l.listener, bytes.TrimSpace(p))
+	return len(p), nil
+}
@@ -0,0 +1,322 @@
+// Package metrics implements counters, histograms and collector functions
+// exposed in the Prometheus text exposition format, using only the standard
+// library.
+package metrics
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"maps"
+	"math"
+	"net/http"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+
+	"github.com/tuggan/goip/logger"
+)
+
+// Metric types as written in the # TYPE line.
+const (
+	Counter   = "counter"
+	Gauge     = "gauge"
+	Histogram = "histogram"
+)
+
+// DefBuckets are the default histogram buckets, in seconds, suited to
+// request latencies.
+var DefBuckets = []float64{.005, .01, .025, .05, .1, .25, .5, 1, 2.5, 5, 10}
+
+// collector writes the samples of one metric family.
+type collector interface {
+	describe() (name, help, typ string)
+	write(w io.Writer)
+}
+
+// Registry holds metric families and serves them. It is safe for
+// concurrent use.
+type Registry struct {
+	mu         sync.Mutex
+	collectors []collector
+}
+
+// NewRegistry creates an empty registry.
+func NewRegistry() *Registry {
+	return &Registry{}
+}
+
+func (r *Registry) register(c collector) {
+	name, _, _ := c.describe()
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	for _, e := range r.collectors {
+		if n, _, _ := e.describe(); n == name {
+			panic("metrics: duplicate metric " + name)
+		}
+	}
+	r.collectors = append(r.collectors, c)
+}
+
+// WriteTo writes every registered metric family in the text exposition
+// format.
+func (r *Registry) WriteTo(w io.Writer) (int64, error) {
+	r.mu.Lock()
+	collectors := slices.Clone(r.collectors)
+	r.mu.Unlock()
+
+	cw := &countingWriter{w: bufio.NewWriter(w)}
+	for _, c := range collectors {
+		name, help, typ := c.describe()
+		fmt.Fprintf(cw, "# HELP %s %s\n", name, escapeHelp(help))
+		fmt.Fprintf(cw, "# TYPE %s %s\n", name, typ)
+		c.write(cw)
+	}
+	err := cw.w.(*bufio.Writer).Flush()
+	return cw.n, err
+}
+
+// Handler serves the registry, e.g. on /metrics.
+func (r *Registry) Handler() http.HandlerFunc {
+	return func(w http.ResponseWriter, req *http.Request) {
+		w.Header().Set("Content-Type", "text/plain; version=0.0.4; charset=utf-8")
+		r.WriteTo(w)
+		logger.Access(req, http.StatusOK)
+	}
+}
+
+// vec tracks one value per combination of label values.
+type vec[T any] struct {
+	name, help string
+	labels     []string
+	mu         sync.Mutex
+	values     map[string]*T
+	newValue   func() *T
+}
+
+func (v *vec[T]) with(values []string) *T {
+	if len(values) != len(v.labels) {
+		panic(fmt.Sprintf("metrics: %s expects %d label values, got %d", v.name, len(v.labels), len(values)))
+	}
+	key := strings.Join(values, "\xff")
+	v.mu.Lock()
+	defer v.mu.Unlock()
+	t, ok := v.values[key]
+	if !ok {
+		t = v.newValue()
+		v.values[key] = t
+	}
+	return t
+}
+
+// each calls f for every series in label value order.
+func (v *vec[T]) each(f func(values []string, t *T)) {
+	v.mu.Lock()
+	keys := slices.Sorted(maps.Keys(v.values))
+	series := make([]*T, len(keys))
+	for i, k := range keys {
+		series[i] = v.values[k]
+	}
+	v.mu.Unlock()
+	for i, k := range keys {
+		var values []string
+		if len(v.labels) > 0 {
+			values = strings.Split(k, "\xff")
+		}
+		f(values, series[i])
+	}
+}
+
+// CounterVec is a counter partitioned by labels.
+type CounterVec struct {
+	vec[counter]
+}
+
+type counter struct {
+	mu sync.Mutex
+	v  float64
+}
+
+// NewCounterVec registers a counter with the given label names.
+func (r *Registry) NewCounterVec(name, help string, labels ...string) *CounterVec {
+	c := &CounterVec{vec[counter]{
+		name: name, help: help, labels: labels,
+		values:   make(map[string]*counter),
+		newValue: func() *counter { return &counter{} },
+	}}
+	if len(labels) == 0 {
+		// Expose an unlabelled counter as 0 before its first increment.
+		c.with(nil)
+	}
+	r.register(c)
+	return c
+}
+
+// Add increases the counter for the label values by delta, which must not
+// be negative.
+func (c *CounterVec) Add(delta float64, values ...string) {
+	if delta < 0 {
+		panic("metrics: counter cannot decrease")
+	}
+	t := c.with(values)
+	t.mu.Lock()
+	t.v += delta
+	t.mu.Unlock()
+}
+
+// Inc increases the counter for the label values by one.
+func (c *CounterVec) Inc(values ...string) {
+	c.Add(1, values...)
+}
+
+// Value returns the current value for the label values.
+func (c *CounterVec) Value(values ...string) float64 {
+	t := c.with(values)
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	return t.v
+}
+
+func (c *CounterVec) describe() (string, string, string) {
+	return c.name, c.help, Counter
+}
+
+func (c *CounterVec) write(w io.Writer) {
+	c.each(func(values []string, t *counter) {
+		t.mu.Lock()
+		v := t.v
+		t.mu.Unlock()
+		writeSample(w, c.name, c.labels, values, v)
+	})
+}
+
+// HistogramVec is a histogram partitioned by labels.
+type HistogramVec struct {
+	vec[histogram]
+	buckets []float64
+}
+
+type histogram struct {
+	mu     sync.Mutex
+	counts []uint64
+	sum    float64
+	count  uint64
+}
+
+// NewHistogramVec registers a histogram with the given upper bucket bounds
+// and label names. The +Inf bucket is added automatically.
+func (r *Registry) NewHistogramVec(name, help string, buckets []float64, labels ...string) *HistogramVec {
+	buckets = slices.Clone(buckets)
+	slices.Sort(buckets)
+	h := &HistogramVec{buckets: buckets}
+	h.vec = vec[histogram]{
+		name: name, help: help, labels: labels,
+		values:   make(map[string]*histogram),
+		newValue: func() *histogram { return &histogram{counts: make([]uint64, len(buckets))} },
+	}
+	r.register(h)
+	return h
+}
+
+// Observe adds v to the histogram for the label values.
+func (h *HistogramVec) Observe(v float64, values ...string) {
+	t := h.with(values)
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	for i, b := range h.buckets {
+		if v <= b {
+			t.counts[i]++
+		}
+	}
+	t.sum += v
+	t.count++
+}
+
+func (h *HistogramVec) describe() (string, string, string) {
+	return h.name, h.help, Histogram
+}
+
+func (h *HistogramVec) write(w io.Writer) {
+	labels := append(slices.Clone(h.labels), "le")
+	h.each(func(values []string, t *histogram) {
+		t.mu.Lock()
+		counts := slices.Clone(t.counts)
+		sum, count := t.sum, t.count
+		t.mu.Unlock()
+		for i, b := range h.buckets {
+			writeSample(w, h.name+"_bucket", labels, append(slices.Clone(values), formatFloat(b)), float64(counts[i]))
+		}
+		writeSample(w, h.name+"_bucket", labels, append(slices.Clone(values), "+Inf"), float64(count))
+		writeSample(w, h.name+"_sum", h.labels, values, sum)
+		writeSample(w, h.name+"_count", h.labels, values, float64(count))
+	})
+}
+
+// funcCollector reads its samples from a callback at scrape time.
+type funcCollector struct {
+	name, help, typ string
+	labels          []string
+	collect         func(emit func(v float64, values ...string))
+}
+
+// NewFunc registers a metric of type typ whose samples are produced by
+// collect on every scrape. It suits values that are already tracked
+// elsewhere, such as the size of a map.
+func (r *Registry) NewFunc(name, help, typ string, labels []string, collect func(emit func(v float64, values ...string))) {
+	r.register(&funcCollector{name: name, help: help, typ: typ, labels: labels, collect: collect})
+}
+
+func (f *funcCollector) describe() (string, string, string) {
+	return f.name, f.help, f.typ
+}
+
+func (f *funcCollector) write(w io.Writer) {
+	f.collect(func(v float64, values ...string) {
+		writeSample(w, f.name, f.labels, values, v)
+	})
+}
+
+func writeSample(w io.Writer, name string, labels, values []string, v float64) {
+	io.WriteString(w, name)
+	if len(labels) > 0 {
+		io.WriteString(w, "{")
+		for i, l := range labels {
+			if i > 0 {
+				io.WriteString(w, ",")
+			}
+			fmt.Fprintf(w, "%s=\"%s\"", l, escapeLabel(values[i]))
+		}
+		io.WriteString(w, "}")
+	}
+	fmt.Fprintf(w, " %s\n", formatFloat(v))
+}
+
+func formatFloat(v float64) string {
+	switch {
+	case math.IsInf(v, 1):
+		return "+Inf"
+	case math.IsInf(v, -1):
+		return "-Inf"
+	}
+	return strconv.FormatFloat(v, 'g', -1, 64)
+}
+
+var (
+	labelEscaper = strings.NewReplacer(`\`, `\\`, "\n", `\n`, `"`, `\"`)
+	helpEscaper  = strings.NewReplacer(`\`, `\\`, "\n", `\n`)
+)
+
+func escapeLabel(s string) string { return labelEscaper.Replace(s) }
+func escapeHelp(s string) string  { return helpEscaper.Replace(s) }
+
+type countingWriter struct {
+	w io.Writer
+	n int64
+}
+
+func (c *countingWriter) Write(p []byte) (int, error) {
+	n, err := c.w.Write(p)
+	c.n += int64(n)
+	return n, err
+}
@@ -0,0 +1,89 @@
+package metrics
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestCounterVec(t *testing.T) {
+	r := NewRegistry()
+	c := r.NewCounterVec("requests_total", "Requests.", "code")
+	c.Inc("200")
+	c.Add(2, "200")
+	c.Inc("404")
+
+	var b strings.Builder
+	r.WriteTo(&b)
+	want := `# HELP requests_total Requests.
+# TYPE requests_total counter
+requests_total{code="200"} 3
+requests_total{code="404"} 1
+`
+	if b.String() != want {
+		t.Errorf("unexpected exposition:\n%s\nwant:\n%s", b.String(), want)
+	}
+	if v := c.Value("200"); v != 3 {
+		t.Errorf("expected 3, got %v", v)
+	}
+}
+
+func TestCounterVec_NoLabels(t *testing.T) {
+	r := NewRegistry()
+	c := r.NewCounterVec("panics_total", "Panics.")
+	c.Inc()
+
+	var b strings.Builder
+	r.WriteTo(&b)
+	if !strings.Contains(b.String(), "\npanics_total 1\n") {
+		t.Errorf("expected unlabelled sample, got:\n%s", b.String())
+	}
+}
+
+func TestHistogramVec(t *testing.T) {
+	r := NewRegistry()
+	h := r.NewHistogramVec("latency_seconds", "Latency.", []float64{1, 0.1}, "route")
+	h.Observe(0.05, "/")
+	h.Observe(0.5, "/")
+	h.Observe(5, "/")
+
+	var b strings.Builder
+	r.WriteTo(&b)
+	want := `# HELP latency_seconds Latency.
+# TYPE latency_seconds histogram
+latency_seconds_bucket{route="/",le="0.1"} 1
+latency_seconds_bucket{route="/",le="1"} 2
+latency_seconds_bucket{route="/",le="+Inf"} 3
+latency_seconds_sum{route="/"} 5.55
+latency_seconds_count{route="/"} 3
+`
+	if b.String() != want {
+		t.Errorf("unexpected exposition:\n%s\nwant:\n%s", b.String(), want)
+	}
+}
+
+func TestNewFunc(t *testing.T) {
+	r := NewRegistry()
+	r.NewFunc("build_info", "Build.", Gauge, []string{"version"}, func(emit func(float64, ...string)) {
+		emit(1, `v"1"`)
+	})
+
+	var b strings.Builder
+	r.WriteTo(&b)
+	if !strings.Contains(b.String(), `build_info{version="v\"1\""} 1`) {
+		t.Errorf("expected escaped label value, got:\n%s", b.String())
+	}
+	if !strings.Contains(b.String(), "# TYPE build_info gauge") {
+		t.Errorf("expected gauge type, got:\n%s", b.String())
+	}
+}
+
+func TestRegistry_DuplicatePanics(t *testing.T) {
+	r := NewRegistry()
+	r.NewCounterVec("requests_total", "Requests.")
+	defer func() {
+		if recover() == nil {
+			t.Error("expected registering a duplicate name to panic")
+		}
+	}()
+	r.NewCounterVec("requests_total", "Requests.")
+}
@@ -0,0 +1,66 @@
+package main
+
+import (
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/tuggan/goip/logger"
+)
+
+func TestMetricsMiddleware(t *testing.T) {
+	mux := http.NewServeMux()
+	mux.HandleFunc("/ip/{rest...}", func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Encoding", "gzip")
+		io.WriteString(w, "hello")
+	})
+	mux.HandleFunc("/missing", func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusNotFound)
+	})
+	h := metricsMiddleware("test-metrics", mux)
+
+	before := requestsTotal.Value("test-metrics", "/ip/{rest...}", "200")
+	beforeBytes := responseBytes.Value("test-metrics", "/ip/{rest...}")
+	beforeGzip := gzipResponses.Value("true")
+
+	req := httptest.NewRequest(http.MethodGet, "/ip/anything", nil)
+	req.Header.Set("Accept-Encoding", "gzip")
+	h.ServeHTTP(httptest.NewRecorder(), req)
+	h.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(http.MethodGet, "/missing", nil))
+
+	if got := requestsTotal.Value("test-metrics", "/ip/{rest...}", "200") - before; got != 1 {
+		t.Errorf("expected the request to be counted under its pattern, got %v", got)
+	}
+	if got := requestsTotal.Value("test-metrics", "/missing", "404"); got != 1 {
+		t.Errorf("expected the 404 to be counted, got %v", got)
+	}
+	if got := responseBytes.Value("test-metrics", "/ip/{rest...}") - beforeBytes; got != 5 {
+		t.Errorf("expected 5 bytes out, got %v", got)
+	}
+	if got := gzipResponses.Value("true") - beforeGzip; got != 1 {
+		t.Errorf("expected one compressed response, got %v", got)
+	}
+}
+
+func TestRecoveryMiddleware_CountsPanics(t *testing.T) {
+	logger.Init(io.Discard, io.Discard, io.Discard, io.Discard)
+	before := panicsRecovered.Value()
+	h := recoveryMiddleware(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		panic("boom")
+	}))
+	h.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(http.MethodGet, "/", nil))
+	if got := panicsRecovered.Value() - before; got != 1 {
+		t.Errorf("expected one recovered panic, got %v", got)
+	}
+}
+
+func TestServerErrorLog_CountsHandshakeErrors(t *testing.T) {
+	logger.Init(io.Discard, io.Discard, io.Discard, io.Discard)
+	l := serverErrorLog{listener: "test-tls"}
+	l.Write([]byte("http: TLS handshake error from 127.0.0.1:1234: EOF\n"))
+	l.Write([]byte("http: Accept error: too many open files\n"))
+	if got := tlsHandshakeErrors.Value("test-tls"); got != 1 {
+		t.Errorf("expected one handshake error, got %v", got)
+	}
+}
@@ -0,0 +1,111 @@
+package main
+
+import (
+	"fmt"
+	"net"
+	"net/http"
+	"slices"
+	"strconv"
+	"strings"
+
+	"github.com/spf13/viper"
+	"github.com/tuggan/goip/logger"
+	"github.com/tuggan/goip/web"
+)
+
+// acmeChallengePrefix is the path HTTP-01 challenges are answered under.
+const acmeChallengePrefix = "/.well-known/acme-challenge/"
+
+// httpsRedirect holds the settings of plain listeners that send their
+// clients to the HTTPS origin instead of serving them.
+type httpsRedirect struct {
+	Enabled bool
+	// Port is the port of the HTTPS origin, left out of the URL when it
+	// is 443.
+	Port int
+	// Code is the status of redirected GET and HEAD requests. Other
+	// methods get the matching status that keeps the method.
+	Code int
+	// Raw keeps serving the raw text endpoints over plain HTTP, for
+	// scripts that cannot follow redirects.
+	Raw bool
+}
+
+// defaultHTTPSRedirect returns the redirect settings used when nothing is
+// configured, which leave redirects disabled.
+func defaultHTTPSRedirect() httpsRedirect {
+	return httpsRedirect{Port: 443, Code: http.StatusMovedPermanently}
+}
+
+// loadHTTPSRedirect returns base with the redirect settings set in v
+// applied.
+func loadHTTPSRedirect(v *viper.Viper, base httpsRedirect) (httpsRedirect, error) {
+	c := base
+	if v.IsSet("httpsRedirect") {
+		c.Enabled = v.GetBool("httpsRedirect")
+	}
+	if v.IsSet("httpsRedirectPort") {
+		c.Port = v.GetInt("httpsRedirectPort")
+		if c.Port < 1 || c.Port > 65535 {
+			return c, fmt.Errorf("httpsRedirectPort %d is not a port", c.Port)
+		}
+	}
+	if v.IsSet("httpsRedirectCode") {
+		c.Code = v.GetInt("httpsRedirectCode")
+		switch c.Code {
+		case http.StatusMovedPermanently, http.StatusFound, http.StatusTemporaryRedirect, http.StatusPermanentRedirect:
+		default:
+			return c, fmt.Errorf("httpsRedirectCode %d is not one of 301, 302, 307 or 308", c.Code)
+		}
+	}
+	if v.IsSet("httpsRedirectRaw") {
+		c.Raw = v.GetBool("httpsRedirectRaw")
+	}
+	return c, nil
+}
+
+// middleware redirects every request to the HTTPS origin except ACME
+// challenges, the exempt paths and, with Raw set, the raw text endpoints.
+// Exempt paths ending in a slash exempt everything below them, like the
+// patterns of http.ServeMux.
+func (c httpsRedirect) middleware(exempt []string, next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if strings.HasPrefix(r.URL.Path, acmeChallengePrefix) || isExempt(exempt, r.URL.Path) ||
+			(c.Raw && web.IsRawEndpoint(r.URL.Path)) {
+			next.ServeHTTP(w, r)
+			return
+		}
+		host := r.Host
+		if h, _, err := net.SplitHostPort(host); err == nil {
+			host = h
+		}
+		host = strings.TrimSuffix(strings.TrimPrefix(host, "["), "]")
+		if host == "" {
+			http.Error(w, "400 Bad Request", http.StatusBadRequest)
+			logger.Access(r, http.StatusBadRequest)
+			return
+		}
+		if c.Port != 443 {
+			host = net.JoinHostPort(host, strconv.Itoa(c.Port))
+		} else if strings.Contains(host, ":") {
+			host = "[" + host + "]"
+		}
+		code := c.Code
+		if r.Method != http.MethodGet && r.Method != http.MethodHead {
+			switch code {
+			case http.StatusMovedPermanently:
+				code = http.StatusPermanentRedirect
+			case http.StatusFound:
+				code = http.StatusTemporaryRedirect
+			}
+		}
+		http.Redirect(w, r, "https://"+host+r.URL.RequestURI(), code)
+		logger.Access(r, code)
+	})
+}
+
+func isExempt(exempt []string, path string) bool {
+	return slices.ContainsFunc(exempt, func(e string) bool {
+		return e == path || strings.HasSuffix(e, "/") && strings.HasPrefix(path, e)
+	})
+}
@@ -0,0 +1,177 @@
+package main
+
+import (
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/tuggan/goip/health"
+	"github.com/tuggan/goip/logger"
+)
+
+func TestLoadListeners_HTTPSRedirect(t *testing.T) {
+	v := configFromTOML(t, `
+httpsRedirect = true
+httpsRedirectPort = 8443
+endpoint = ["127.0.0.1:80"]
+
+[[listener]]
+name = "legacy"
+address = "127.0.0.1:81"
+httpsRedirectCode = 308
+httpsRedirectRaw = true
+
+[[listener]]
+name = "open"
+address = "127.0.0.1:82"
+httpsRedirect = false
+
+[[listener]]
+name = "https"
+address = "127.0.0.1:443"
+tlsCert = "cert.pem"
+tlsKey = "key.pem"
+`)
+	got, err := loadListeners(v)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if len(got) != 4 {
+		t.Fatalf("expected 4 listeners, got %d", len(got))
+	}
+	want := httpsRedirect{Enabled: true, Port: 8443, Code: http.StatusMovedPermanently}
+	if got[0].HTTPSRedirect != want {
+		t.Errorf("expected the global settings %+v, got %+v", want, got[0].HTTPSRedirect)
+	}
+	want = httpsRedirect{Enabled: true, Port: 8443, Code: http.StatusPermanentRedirect, Raw: true}
+	if got[1].HTTPSRedirect != want {
+		t.Errorf("expected the listener to override the code, got %+v", got[1].HTTPSRedirect)
+	}
+	if got[2].HTTPSRedirect.Enabled {
+		t.Error("expected the listener to disable the redirect")
+	}
+
+	base, err := baseListener(configFromTOML(t, ``))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if base.HTTPSRedirect != defaultHTTPSRedirect() || base.HTTPSRedirect.Enabled {
+		t.Errorf("expected redirects to be disabled by default, got %+v", base.HTTPSRedirect)
+	}
+}
+
+func TestLoadListeners_HTTPSRedirectInvalid(t *testing.T) {
+	tests := map[string]string{
+		"port":     `httpsRedirectPort = 70000`,
+		"code":     `httpsRedirectCode = 200`,
+		"table":    "[[listener]]\naddress = \"127.0.0.1:80\"\nhttpsRedirectCode = 303",
+		"no https": "httpsRedirect = true\nendpoint = [\"127.0.0.1:80\"]",
+	}
+	for name, doc := range tests {
+		t.Run(name, func(t *testing.T) {
+			if _, err := loadListeners(configFromTOML(t, doc)); err == nil {
+				t.Error("expected an error")
+			}
+		})
+	}
+}
+
+func TestLoadListeners_HTTPSRedirectGlobalCert(t *testing.T) {
+	// Sockets named https passed in by systemd serve the global
+	// certificate.
+	v := configFromTOML(t, "httpsRedirect = true\ntlsCert = \"cert.pem\"\ntlsKey = \"key.pem\"")
+	if _, err := loadListeners(v); err != nil {
+		t.Errorf("expected a global certificate to allow redirects, got %v", err)
+	}
+}
+
+func TestHTTPSRedirect_Middleware(t *testing.T) {
+	logger.Init(io.Discard, io.Discard, io.Discard, io.Discard)
+	next := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusOK)
+	})
+	tests := []struct {
+		name     string
+		redirect httpsRedirect
+		method   string
+		host     string
+		target   string
+		code     int
+		location string
+	}{
+		{"path and query", defaultHTTPSRedirect(), http.MethodGet, "ip.example.com", "/json?x=1", http.StatusMovedPermanently, "https://ip.example.com/json?x=1"},
+		{"port dropped", defaultHTTPSRedirect(), http.MethodGet, "ip.example.com:80", "/", http.StatusMovedPermanently, "https://ip.example.com/"},
+		{"custom port", httpsRedirect{Port: 8443, Code: http.StatusFound}, http.MethodHead, "ip.example.com:8080", "/", http.StatusFound, "https://ip.example.com:8443/"},
+		{"ipv6", defaultHTTPSRedirect(), http.MethodGet, "[2001:db8::1]:80", "/", http.StatusMovedPermanently, "https://[2001:db8::1]/"},
+		{"ipv6 custom port", httpsRedirect{Port: 8443, Code: http.StatusMovedPermanently}, http.MethodGet, "[2001:db8::1]", "/", http.StatusMovedPermanently, "https://[2001:db8::1]:8443/"},
+		{"post permanent", defaultHTTPSRedirect(), http.MethodPost, "ip.example.com", "/", http.StatusPermanentRedirect, "https://ip.example.com/"},
+		{"post temporary", httpsRedirect{Port: 443, Code: http.StatusFound}, http.MethodPost, "ip.example.com", "/", http.StatusTemporaryRedirect, "https://ip.example.com/"},
+		{"acme challenge", defaultHTTPSRedirect(), http.MethodGet, "ip.example.com", "/.well-known/acme-challenge/token", http.StatusOK, ""},
+		{"health", defaultHTTPSRedirect(), http.MethodGet, "ip.example.com", "/health", http.StatusOK, ""},
+		{"check below exempt subtree", defaultHTTPSRedirect(), http.MethodGet, "ip.example.com", "/livez/db", http.StatusOK, ""},
+		{"raw endpoint", defaultHTTPSRedirect(), http.MethodGet, "ip.example.com", "/ip", http.StatusMovedPermanently, "https://ip.example.com/ip"},
+		{"raw endpoint exempt", httpsRedirect{Port: 443, Code: http.StatusMovedPermanently, Raw: true}, http.MethodGet, "ip.example.com", "/ip", http.StatusOK, ""},
+		{"no host", defaultHTTPSRedirect(), http.MethodGet, "", "/", http.StatusBadRequest, ""},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req := httptest.NewRequest(tt.method, tt.target, nil)
+			req.Host = tt.host
+			w := httptest.NewRecorder()
+			tt.redirect.middleware([]string{"/health", "/livez/"}, next).ServeHTTP(w, req)
+			if w.Code != tt.code {
+				t.Errorf("expected %d, got %d", tt.code, w.Code)
+			}
+			if loc := w.Header().Get("Location"); loc != tt.location {
+				t.Errorf("expected Location %q, got %q", tt.location, loc)
+			}
+		})
+	}
+}
+
+func TestNewListener_HTTPSRedirect(t *testing.T) {
+	logger.Init(io.Discard, io.Discard, io.Discard, io.Discard)
+	themes, err := loadThemes(configFromTOML(t, ``), templateFS(""), nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	opts := handlerOptions{themes: themes, staticDir: "static",
+		livez: health.NewRegistry("livez"), readyz: health.NewRegistry("readyz")}
+	redirect := httpsRedirect{Enabled: true, Port: 443, Code: http.StatusMovedPermanently}
+
+	get := func(l *listener, path string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(http.MethodGet, path, nil)
+		req.Host = "ip.example.com"
+		req.RemoteAddr = "1.2.3.4:5678"
+		w := httptest.NewRecorder()
+		l.srv.Handler.ServeHTTP(w, req)
+		return w
+	}
+
+	l, err := newListener(listenerConfig{Name: "http", Address: "127.0.0.1:0", HTTPSRedirect: redirect}, opts)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer l.rl.Stop()
+	if w := get(l, "/"); w.Code != http.StatusMovedPermanently || w.Header().Get("Location") != "https://ip.example.com/" {
+		t.Errorf("expected a redirect to HTTPS, got %d %q", w.Code, w.Header().Get("Location"))
+	}
+	for _, path := range []string{"/health", "/livez", "/readyz"} {
+		if w := get(l, path); w.Code != http.StatusOK {
+			t.Errorf("expected %s to be served, got %d", path, w.Code)
+		}
+	}
+
+	certFile, keyFile := writeTestCert(t, time.Now().Add(-time.Hour), time.Now().Add(time.Hour), "ip.example.com")
+	l, err = newListener(listenerConfig{Name: "https", Address: "127.0.0.1:0", TLS: true, TLSCert: certFile, TLSKey: keyFile,
+		TLSPolicy: defaultTLSPolicyConfig(), HTTPSRedirect: redirect}, opts)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer l.rl.Stop()
+	if w := get(l, "/"); w.Code != http.StatusOK {
+		t.Errorf("expected TLS listeners not to redirect, got %d", w.Code)
+	}
+}
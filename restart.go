@@ -0,0 +1,166 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"os"
+	"os/exec"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/tuggan/goip/logger"
+	"github.com/tuggan/goip/systemd"
+)
+
+// Environment variables used to hand the listening sockets over to a new
+// process during a graceful restart. The sockets themselves are passed
+// the same way systemd passes them, through LISTEN_FDS and LISTEN_FDNAMES.
+const (
+	envInherit = "GOIP_INHERIT_LISTENERS"
+	envReadyFD = "GOIP_READY_FD"
+)
+
+// claimInheritedListeners prepares the environment so systemd.Listeners
+// picks up sockets handed over by a parent during a graceful restart. The
+// parent cannot know the pid of its child in advance, so it asks the child
+// to claim LISTEN_FDS for itself. It reports whether the sockets came from
+// a parent process.
+func claimInheritedListeners() bool {
+	if os.Getenv(envInherit) == "" {
+		return false
+	}
+	os.Unsetenv(envInherit)
+	os.Setenv("LISTEN_PID", strconv.Itoa(os.Getpid()))
+	return true
+}
+
+// restart starts a new instance of the running binary, hands it every
+// listening socket and waits up to timeout for it to report that it is
+// serving. On success the caller should shut down its servers without
+// draining, as the new process shares the sockets and would fail health
+// checks along with it. On failure the new process is killed and the
+// caller keeps serving.
+func restart(listeners []*listener, timeout time.Duration) error {
+	var files []*os.File
+	var names []string
+	defer func() {
+		for _, f := range files {
+			f.Close()
+		}
+	}()
+	for _, l := range listeners {
+		for _, ln := range l.lns {
+			fl, ok := ln.(interface{ File() (*os.File, error) })
+			if !ok {
+				return fmt.Errorf("socket %s of listener %s cannot be handed over", ln.Addr(), l.cfg.Name)
+			}
+			f, err := fl.File()
+			if err != nil {
+				return err
+			}
+			files = append(files, f)
+			names = append(names, l.cfg.Name)
+		}
+	}
+
+	r, w, err := os.Pipe()
+	if err != nil {
+		return err
+	}
+	defer r.Close()
+
+	exe, err := os.Executable()
+	if err != nil {
+		w.Close()
+		return err
+	}
+	cmd := exec.Command(exe, os.Args[1:]...)
+	cmd.Stdout = os.Stdout
+	cmd.Stderr = os.Stderr
+	// ExtraFiles start at descriptor 3 in the child, the readiness pipe
+	// comes after the sockets.
+	cmd.ExtraFiles = append(files, w)
+	cmd.Env = append(environWithout("LISTEN_PID", "LISTEN_FDS", "LISTEN_FDNAMES", "WATCHDOG_PID", envInherit, envReadyFD),
+		"LISTEN_FDS="+strconv.Itoa(len(files)),
+		"LISTEN_FDNAMES="+strings.Join(names, ":"),
+		envInherit+"=1",
+		envReadyFD+"="+strconv.Itoa(3+len(files)),
+	)
+	err = cmd.Start()
+	w.Close()
+	if err != nil {
+		return err
+	}
+	logger.Info("Started new process %d, waiting for it to become ready", cmd.Process.Pid)
+
+	ready := make(chan error, 1)
+	go func() {
+		// The read fails with EOF if the child exits without
+		// reporting ready.
+		_, err := r.Read(make([]byte, 1))
+		ready <- err
+	}()
+
+	select {
+	case err = <-ready:
+	case <-time.After(timeout):
+		err = fmt.Errorf("not ready after %s", timeout)
+	}
+	if err != nil {
+		cmd.Process.Kill()
+		cmd.Wait()
+		if errors.Is(err, io.EOF) {
+			err = errors.New("exited before becoming ready")
+		}
+		return fmt.Errorf("new process %d: %w", cmd.Process.Pid, err)
+	}
+
+	// The sockets now belong to the new process as well, so closing
+	// ours on shutdown must not remove unix socket files.
+	for _, l := range listeners {
+		for _, ln := range l.lns {
+			if ul, ok := ln.(*net.UnixListener); ok {
+				ul.SetUnlinkOnClose(false)
+			}
+		}
+	}
+	if _, err := systemd.Notify("MAINPID=" + strconv.Itoa(cmd.Process.Pid)); err != nil {
+		logger.Warning("Failed to notify systemd: %s", err)
+	}
+	cmd.Process.Release()
+	return nil
+}
+
+// notifyParentReady tells the process that started this one during a
+// graceful restart that it is serving requests.
+func notifyParentReady() error {
+	s := os.Getenv(envReadyFD)
+	if s == "" {
+		return nil
+	}
+	os.Unsetenv(envReadyFD)
+	fd, err := strconv.Atoi(s)
+	if err != nil {
+		return fmt.Errorf("invalid %s %q", envReadyFD, s)
+	}
+	f := os.NewFile(uintptr(fd), "ready")
+	defer f.Close()
+	_, err = f.Write([]byte{1})
+	return err
+}
+
+// environWithout returns the environment without the given variables.
+func environWithout(keys ...string) []string {
+	var env []string
+	for _, kv := range os.Environ() {
+		name, _, _ := strings.Cut(kv, "=")
+		if !slices.Contains(keys, name) {
+			env = append(env, kv)
+		}
+	}
+	return env
+}
@@ -0,0 +1,81 @@
+//go:build !windows
+
+package main
+
+import (
+	"os"
+	"strconv"
+	"syscall"
+	"testing"
+)
+
+func TestClaimInheritedListeners(t *testing.T) {
+	t.Setenv(envInherit, "")
+	t.Setenv("LISTEN_PID", "")
+	if claimInheritedListeners() {
+		t.Error("expected no inherited listeners without a parent")
+	}
+	if os.Getenv("LISTEN_PID") != "" {
+		t.Error("expected LISTEN_PID to be left alone")
+	}
+
+	t.Setenv(envInherit, "1")
+	if !claimInheritedListeners() {
+		t.Error("expected inherited listeners when started by a parent")
+	}
+	if got := os.Getenv("LISTEN_PID"); got != strconv.Itoa(os.Getpid()) {
+		t.Errorf("expected LISTEN_PID to be claimed, got %q", got)
+	}
+	if os.Getenv(envInherit) != "" {
+		t.Errorf("expected %s to be removed from the environment", envInherit)
+	}
+}
+
+func TestNotifyParentReady(t *testing.T) {
+	r, w, err := os.Pipe()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer r.Close()
+	// notifyParentReady closes the descriptor, so give it a raw duplicate.
+	fd, err := syscall.Dup(int(w.Fd()))
+	w.Close()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	t.Setenv(envReadyFD, strconv.Itoa(fd))
+	if err := notifyParentReady(); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	buf := make([]byte, 1)
+	if n, err := r.Read(buf); err != nil || n != 1 {
+		t.Errorf("expected the parent to receive a byte, got n=%d err=%v", n, err)
+	}
+}
+
+func TestNotifyParentReady_NoParent(t *testing.T) {
+	t.Setenv(envReadyFD, "")
+	if err := notifyParentReady(); err != nil {
+		t.Errorf("expected no-op without a parent, got %v", err)
+	}
+}
+
+func TestEnvironWithout(t *testing.T) {
+	t.Setenv("GOIP_TEST_KEEP", "1")
+	t.Setenv("GOIP_TEST_DROP", "1")
+	env := environWithout("GOIP_TEST_DROP")
+
+	var keep, drop bool
+	for _, kv := range env {
+		switch kv {
+		case "GOIP_TEST_KEEP=1":
+			keep = true
+		case "GOIP_TEST_DROP=1":
+			drop = true
+		}
+	}
+	if !keep || drop {
+		t.Errorf("expected only GOIP_TEST_DROP to be removed, keep=%v drop=%v", keep, drop)
+	}
+}
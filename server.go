@@ -0,0 +1,98 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"time"
+
+	"github.com/spf13/viper"
+)
+
+// The frame sizes HTTP/2 allows for SETTINGS_MAX_FRAME_SIZE (RFC 9113).
+const (
+	minFrameSize = 1 << 14
+	maxFrameSize = 1<<24 - 1
+)
+
+// serverLimits holds the timeouts and header size limit applied to an
+// http.Server. IdleTimeout applies to HTTP/2 connections too.
+type serverLimits struct {
+	ReadTimeout       time.Duration
+	ReadHeaderTimeout time.Duration
+	WriteTimeout      time.Duration
+	IdleTimeout       time.Duration
+	MaxHeaderBytes    int
+	// HTTP2MaxConcurrentStreams and HTTP2MaxReadFrameSize are the HTTP/2
+	// settings announced to clients. Zero leaves the net/http defaults.
+	HTTP2MaxConcurrentStreams int
+	HTTP2MaxReadFrameSize     int
+}
+
+// defaultServerLimits returns the limits GoIP has always used when nothing
+// is configured.
+func defaultServerLimits() serverLimits {
+	return serverLimits{
+		ReadTimeout:       10 * time.Second,
+		ReadHeaderTimeout: 5 * time.Second,
+		WriteTimeout:      10 * time.Second,
+		IdleTimeout:       60 * time.Second,
+		MaxHeaderBytes:    1 << 20, // 1 MB
+	}
+}
+
+// loadServerLimits returns base with every limit that is set under prefix in
+// v overridden. An empty prefix reads the top level keys, a prefix such as
+// "https." reads a listener specific section.
+func loadServerLimits(v *viper.Viper, prefix string, base serverLimits) serverLimits {
+	l := base
+	if v.IsSet(prefix + "readTimeout") {
+		l.ReadTimeout = v.GetDuration(prefix + "readTimeout")
+	}
+	if v.IsSet(prefix + "readHeaderTimeout") {
+		l.ReadHeaderTimeout = v.GetDuration(prefix + "readHeaderTimeout")
+	}
+	if v.IsSet(prefix + "writeTimeout") {
+		l.WriteTimeout = v.GetDuration(prefix + "writeTimeout")
+	}
+	if v.IsSet(prefix + "idleTimeout") {
+		l.IdleTimeout = v.GetDuration(prefix + "idleTimeout")
+	}
+	if v.IsSet(prefix + "maxHeaderBytes") {
+		l.MaxHeaderBytes = v.GetInt(prefix + "maxHeaderBytes")
+	}
+	if v.IsSet(prefix + "http2MaxConcurrentStreams") {
+		l.HTTP2MaxConcurrentStreams = v.GetInt(prefix + "http2MaxConcurrentStreams")
+	}
+	if v.IsSet(prefix + "http2MaxReadFrameSize") {
+		l.HTTP2MaxReadFrameSize = v.GetInt(prefix + "http2MaxReadFrameSize")
+	}
+	return l
+}
+
+// apply copies the limits onto srv.
+func (l serverLimits) apply(srv *http.Server) {
+	srv.ReadTimeout = l.ReadTimeout
+	srv.ReadHeaderTimeout = l.ReadHeaderTimeout
+	srv.WriteTimeout = l.WriteTimeout
+	srv.IdleTimeout = l.IdleTimeout
+	srv.MaxHeaderBytes = l.MaxHeaderBytes
+	if l.HTTP2MaxConcurrentStreams != 0 || l.HTTP2MaxReadFrameSize != 0 {
+		srv.HTTP2 = &http.HTTP2Config{
+			MaxConcurrentStreams: l.HTTP2MaxConcurrentStreams,
+			MaxReadFrameSize:     l.HTTP2MaxReadFrameSize,
+		}
+	}
+}
+
+// validate checks the HTTP/2 settings, which net/http would otherwise
+// silently replace with its defaults.
+func (l serverLimits) validate() error {
+	if l.HTTP2MaxConcurrentStreams < 0 {
+		return errors.New("http2MaxConcurrentStreams must not be negative")
+	}
+	if l.HTTP2MaxReadFrameSize != 0 && (l.HTTP2MaxReadFrameSize < minFrameSize || l.HTTP2MaxReadFrameSize > maxFrameSize) {
+		return fmt.Errorf("http2MaxReadFrameSize %d is not between %d and %d", l.HTTP2MaxReadFrameSize, minFrameSize, maxFrameSize)
+	}
+	return nil
+}
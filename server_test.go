@@ -0,0 +1,114 @@
+package main
+
+import (
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/spf13/viper"
+)
+
+func TestLoadServerLimits_Defaults(t *testing.T) {
+	v := viper.New()
+	got := loadServerLimits(v, "", defaultServerLimits())
+	if got != defaultServerLimits() {
+		t.Errorf("expected defaults when nothing is set, got %+v", got)
+	}
+}
+
+func TestLoadServerLimits_GlobalAndSection(t *testing.T) {
+	v := viper.New()
+	v.Set("writeTimeout", "30s")
+	v.Set("maxHeaderBytes", 4096)
+	v.Set("https.writeTimeout", "5m")
+	v.Set("https.idleTimeout", "2m")
+
+	global := loadServerLimits(v, "", defaultServerLimits())
+	if global.WriteTimeout != 30*time.Second {
+		t.Errorf("expected global writeTimeout 30s, got %s", global.WriteTimeout)
+	}
+	if global.MaxHeaderBytes != 4096 {
+		t.Errorf("expected global maxHeaderBytes 4096, got %d", global.MaxHeaderBytes)
+	}
+	if global.ReadTimeout != 10*time.Second {
+		t.Errorf("expected default readTimeout to be kept, got %s", global.ReadTimeout)
+	}
+
+	tls := loadServerLimits(v, "https.", global)
+	if tls.WriteTimeout != 5*time.Minute {
+		t.Errorf("expected https writeTimeout 5m, got %s", tls.WriteTimeout)
+	}
+	if tls.IdleTimeout != 2*time.Minute {
+		t.Errorf("expected https idleTimeout 2m, got %s", tls.IdleTimeout)
+	}
+	if tls.MaxHeaderBytes != 4096 {
+		t.Errorf("expected https to inherit global maxHeaderBytes, got %d", tls.MaxHeaderBytes)
+	}
+
+	plain := loadServerLimits(v, "http.", global)
+	if plain != global {
+		t.Errorf("expected http section to equal global limits, got %+v", plain)
+	}
+}
+
+func TestServerLimits_Apply(t *testing.T) {
+	l := serverLimits{
+		ReadTimeout:       1 * time.Second,
+		ReadHeaderTimeout: 2 * time.Second,
+		WriteTimeout:      3 * time.Second,
+		IdleTimeout:       4 * time.Second,
+		MaxHeaderBytes:    5,
+	}
+	var srv http.Server
+	l.apply(&srv)
+
+	if srv.ReadTimeout != l.ReadTimeout || srv.ReadHeaderTimeout != l.ReadHeaderTimeout ||
+		srv.WriteTimeout != l.WriteTimeout || srv.IdleTimeout != l.IdleTimeout ||
+		srv.MaxHeaderBytes != l.MaxHeaderBytes {
+		t.Errorf("expected server to carry limits %+v", l)
+	}
+}
+
+func TestServerLimits_HTTP2(t *testing.T) {
+	v := viper.New()
+	v.Set("http2MaxConcurrentStreams", 50)
+	v.Set("https.http2MaxReadFrameSize", 1<<20)
+
+	global := loadServerLimits(v, "", defaultServerLimits())
+	var plain http.Server
+	global.apply(&plain)
+	if plain.HTTP2 == nil || plain.HTTP2.MaxConcurrentStreams != 50 || plain.HTTP2.MaxReadFrameSize != 0 {
+		t.Errorf("expected only the stream limit to be set, got %+v", plain.HTTP2)
+	}
+	var tls http.Server
+	loadServerLimits(v, "https.", global).apply(&tls)
+	if tls.HTTP2 == nil || tls.HTTP2.MaxConcurrentStreams != 50 || tls.HTTP2.MaxReadFrameSize != 1<<20 {
+		t.Errorf("expected the section to add the frame size, got %+v", tls.HTTP2)
+	}
+	var unset http.Server
+	defaultServerLimits().apply(&unset)
+	if unset.HTTP2 != nil {
+		t.Errorf("expected the net/http defaults without HTTP/2 settings, got %+v", unset.HTTP2)
+	}
+}
+
+func TestServerLimits_Validate(t *testing.T) {
+	tests := map[string]struct {
+		limits serverLimits
+		valid  bool
+	}{
+		"defaults":         {defaultServerLimits(), true},
+		"streams":          {serverLimits{HTTP2MaxConcurrentStreams: 10}, true},
+		"negative streams": {serverLimits{HTTP2MaxConcurrentStreams: -1}, false},
+		"min frame size":   {serverLimits{HTTP2MaxReadFrameSize: 16384}, true},
+		"small frame size": {serverLimits{HTTP2MaxReadFrameSize: 16383}, false},
+		"large frame size": {serverLimits{HTTP2MaxReadFrameSize: 1 << 24}, false},
+	}
+	for name, tt := range tests {
+		t.Run(name, func(t *testing.T) {
+			if err := tt.limits.validate(); (err == nil) != tt.valid {
+				t.Errorf("expected valid=%t, got %v", tt.valid, err)
+			}
+		})
+	}
+}
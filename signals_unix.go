@@ -0,0 +1,14 @@
+//go:build !windows
+
+package main
+
+import (
+	"os"
+	"syscall"
+)
+
+// restartSignals trigger a graceful restart.
+var restartSignals = []os.Signal{syscall.SIGUSR2}
+
+// reloadSignals make GoIP reload its TLS certificates.
+var reloadSignals = []os.Signal{syscall.SIGHUP}
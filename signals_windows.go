@@ -0,0 +1,13 @@
+//go:build windows
+
+package main
+
+import "os"
+
+// restartSignals trigger a graceful restart. Handing sockets to a child
+// process is not supported on Windows.
+var restartSignals []os.Signal
+
+// reloadSignals make GoIP reload its TLS certificates. Certificates are
+// still reloaded when their files change.
+var reloadSignals []os.Signal
@@ -0,0 +1,140 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"io/fs"
+	"net"
+	"os"
+	"os/user"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/tuggan/goip/logger"
+)
+
+// unixPrefix marks an endpoint address as a unix domain socket path, as in
+// "unix:/run/goip/goip.sock".
+const unixPrefix = "unix:"
+
+// listen binds the socket described by the listener configuration.
+func listen(c listenerConfig) (net.Listener, error) {
+	if c.Network != "unix" {
+		return net.Listen(c.Network, c.Address)
+	}
+
+	if err := removeStaleSocket(c.Address); err != nil {
+		return nil, err
+	}
+	ln, err := net.Listen("unix", c.Address)
+	if err != nil {
+		return nil, err
+	}
+	// Remove the socket file when the server shuts down so the next
+	// start does not find a stale socket.
+	ln.(*net.UnixListener).SetUnlinkOnClose(true)
+
+	if err := setSocketPermissions(c); err != nil {
+		ln.Close()
+		return nil, err
+	}
+	return ln, nil
+}
+
+// removeStaleSocket removes a socket file left behind by a process that did
+// not shut down cleanly. A socket that still accepts connections belongs to
+// a running process and is left alone, as is anything that is not a socket.
+func removeStaleSocket(path string) error {
+	fi, err := os.Lstat(path)
+	if errors.Is(err, fs.ErrNotExist) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	if fi.Mode().Type() != fs.ModeSocket {
+		return fmt.Errorf("%s exists and is not a socket", path)
+	}
+	conn, err := net.DialTimeout("unix", path, time.Second)
+	if err == nil {
+		conn.Close()
+		return fmt.Errorf("%s is in use by another process", path)
+	}
+	logger.Warning("Removing stale socket %s", path)
+	return os.Remove(path)
+}
+
+// setSocketPermissions applies the configured file mode and ownership to
+// the socket file of a unix listener.
+func setSocketPermissions(c listenerConfig) error {
+	if c.SocketMode != 0 {
+		if err := os.Chmod(c.Address, c.SocketMode); err != nil {
+			return err
+		}
+	}
+	if c.SocketOwner == "" && c.SocketGroup == "" {
+		return nil
+	}
+	uid, gid := -1, -1
+	if c.SocketOwner != "" {
+		id, err := lookupID(c.SocketOwner, func(name string) (string, error) {
+			u, err := user.Lookup(name)
+			if err != nil {
+				return "", err
+			}
+			return u.Uid, nil
+		})
+		if err != nil {
+			return fmt.Errorf("socket owner %q: %w", c.SocketOwner, err)
+		}
+		uid = id
+	}
+	if c.SocketGroup != "" {
+		id, err := lookupID(c.SocketGroup, func(name string) (string, error) {
+			g, err := user.LookupGroup(name)
+			if err != nil {
+				return "", err
+			}
+			return g.Gid, nil
+		})
+		if err != nil {
+			return fmt.Errorf("socket group %q: %w", c.SocketGroup, err)
+		}
+		gid = id
+	}
+	return os.Chown(c.Address, uid, gid)
+}
+
+// lookupID resolves a user or group given by name or numeric id.
+func lookupID(s string, lookup func(string) (string, error)) (int, error) {
+	if id, err := strconv.Atoi(s); err == nil {
+		return id, nil
+	}
+	id, err := lookup(s)
+	if err != nil {
+		return 0, err
+	}
+	return strconv.Atoi(id)
+}
+
+// parseSocketMode reads a file mode given either as an octal string such as
+// "0660" or as a TOML integer such as 0o660.
+func parseSocketMode(v interface{}) (os.FileMode, error) {
+	switch m := v.(type) {
+	case nil:
+		return 0, nil
+	case string:
+		mode, err := strconv.ParseUint(strings.TrimPrefix(m, "0o"), 8, 32)
+		if err != nil {
+			return 0, fmt.Errorf("invalid socket mode %q", m)
+		}
+		return os.FileMode(mode) & os.ModePerm, nil
+	case int:
+		return os.FileMode(m) & os.ModePerm, nil
+	case int64:
+		return os.FileMode(m) & os.ModePerm, nil
+	default:
+		return 0, fmt.Errorf("invalid socket mode %v", v)
+	}
+}
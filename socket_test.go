@@ -0,0 +1,90 @@
+package main
+
+import (
+	"net"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestParseSocketMode(t *testing.T) {
+	tests := []struct {
+		in   interface{}
+		want os.FileMode
+	}{
+		{nil, 0},
+		{"0660", 0o660},
+		{"0o600", 0o600},
+		{int64(0o644), 0o644},
+	}
+	for _, tt := range tests {
+		got, err := parseSocketMode(tt.in)
+		if err != nil {
+			t.Errorf("%v: unexpected error: %v", tt.in, err)
+			continue
+		}
+		if got != tt.want {
+			t.Errorf("%v: expected %o, got %o", tt.in, tt.want, got)
+		}
+	}
+	if _, err := parseSocketMode("rw-rw----"); err == nil {
+		t.Error("expected an error for a non octal mode")
+	}
+}
+
+func TestListen_UnixSocket(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "goip.sock")
+	ln, err := listen(listenerConfig{Network: "unix", Address: path, SocketMode: 0o600})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	fi, err := os.Stat(path)
+	if err != nil {
+		t.Fatalf("expected socket file to exist: %v", err)
+	}
+	if fi.Mode().Perm() != 0o600 {
+		t.Errorf("expected mode 0600, got %o", fi.Mode().Perm())
+	}
+
+	// A second listener on the same path must not steal a live socket.
+	if _, err := listen(listenerConfig{Network: "unix", Address: path}); err == nil {
+		t.Error("expected an error binding a socket that is in use")
+	}
+
+	ln.Close()
+	if _, err := os.Stat(path); !os.IsNotExist(err) {
+		t.Errorf("expected socket file to be removed on close, got %v", err)
+	}
+}
+
+func TestRemoveStaleSocket(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "stale.sock")
+	ln, err := net.Listen("unix", path)
+	if err != nil {
+		t.Fatalf("failed to create socket: %v", err)
+	}
+	// Leave the file behind as a crashed process would.
+	ln.(*net.UnixListener).SetUnlinkOnClose(false)
+	ln.Close()
+
+	if err := removeStaleSocket(path); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if _, err := os.Stat(path); !os.IsNotExist(err) {
+		t.Errorf("expected stale socket to be removed, got %v", err)
+	}
+}
+
+func TestRemoveStaleSocket_NotASocket(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "file")
+	if err := os.WriteFile(path, []byte("data"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	if err := removeStaleSocket(path); err == nil {
+		t.Error("expected an error for a regular file")
+	}
+	if _, err := os.Stat(path); err != nil {
+		t.Errorf("expected regular file to be left alone, got %v", err)
+	}
+}
@@ -0,0 +1,109 @@
+// Package systemd implements the parts of the systemd service protocol GoIP
+// uses: socket activation through LISTEN_FDS and readiness, stopping and
+// watchdog notifications through NOTIFY_SOCKET. Outside of systemd every
+// function is a no-op.
+package systemd
+
+import (
+	"fmt"
+	"net"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// listenFDsStart is the first file descriptor passed by systemd, the ones
+// before it are stdin, stdout and stderr.
+const listenFDsStart = 3
+
+// Notification states understood by systemd, see sd_notify(3).
+const (
+	Ready    = "READY=1"
+	Stopping = "STOPPING=1"
+	Watchdog = "WATCHDOG=1"
+)
+
+// Listeners returns the sockets passed to the process by systemd socket
+// activation, grouped by their FileDescriptorName. Sockets without a name
+// are grouped under "unknown" as systemd does. The LISTEN_* variables are
+// removed from the environment so child processes do not pick them up.
+func Listeners() (map[string][]net.Listener, error) {
+	defer func() {
+		os.Unsetenv("LISTEN_PID")
+		os.Unsetenv("LISTEN_FDS")
+		os.Unsetenv("LISTEN_FDNAMES")
+	}()
+	return listeners(os.Getenv, listenFDsStart)
+}
+
+func listeners(getenv func(string) string, start int) (map[string][]net.Listener, error) {
+	pid, err := strconv.Atoi(getenv("LISTEN_PID"))
+	if err != nil || pid != os.Getpid() {
+		return nil, nil
+	}
+	n, err := strconv.Atoi(getenv("LISTEN_FDS"))
+	if err != nil || n <= 0 {
+		return nil, nil
+	}
+
+	var names []string
+	if s := getenv("LISTEN_FDNAMES"); s != "" {
+		names = strings.Split(s, ":")
+	}
+
+	result := make(map[string][]net.Listener)
+	for i := 0; i < n; i++ {
+		name := "unknown"
+		if i < len(names) && names[i] != "" {
+			name = names[i]
+		}
+		f := os.NewFile(uintptr(start+i), name)
+		ln, err := net.FileListener(f)
+		// FileListener duplicates the descriptor, the original is no
+		// longer needed either way.
+		f.Close()
+		if err != nil {
+			return nil, fmt.Errorf("socket %d (%s): %w", start+i, name, err)
+		}
+		result[name] = append(result[name], ln)
+	}
+	return result, nil
+}
+
+// Notify sends state to the service manager. It reports false without an
+// error when the process is not running under systemd.
+func Notify(state string) (bool, error) {
+	socket := os.Getenv("NOTIFY_SOCKET")
+	if socket == "" {
+		return false, nil
+	}
+	// A leading @ denotes a socket in the abstract namespace.
+	if socket[0] == '@' {
+		socket = "\x00" + socket[1:]
+	}
+	conn, err := net.DialUnix("unixgram", nil, &net.UnixAddr{Name: socket, Net: "unixgram"})
+	if err != nil {
+		return false, err
+	}
+	defer conn.Close()
+	if _, err := conn.Write([]byte(state)); err != nil {
+		return false, err
+	}
+	return true, nil
+}
+
+// WatchdogInterval returns how often systemd expects a watchdog
+// notification, or zero when the watchdog is not enabled for this process.
+func WatchdogInterval() time.Duration {
+	usec, err := strconv.ParseInt(os.Getenv("WATCHDOG_USEC"), 10, 64)
+	if err != nil || usec <= 0 {
+		return 0
+	}
+	if s := os.Getenv("WATCHDOG_PID"); s != "" {
+		if pid, err := strconv.Atoi(s); err != nil || pid != os.Getpid() {
+			return 0
+		}
+	}
+	return time.Duration(usec) * time.Microsecond
+}
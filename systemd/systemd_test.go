@@ -0,0 +1,128 @@
+//go:build !windows
+
+package systemd
+
+import (
+	"net"
+	"os"
+	"path/filepath"
+	"strconv"
+	"syscall"
+	"testing"
+	"time"
+)
+
+func TestListeners_NotActivated(t *testing.T) {
+	env := map[string]string{}
+	got, err := listeners(func(k string) string { return env[k] }, listenFDsStart)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if got != nil {
+		t.Errorf("expected no listeners, got %v", got)
+	}
+}
+
+func TestListeners_OtherPID(t *testing.T) {
+	env := map[string]string{
+		"LISTEN_PID": strconv.Itoa(os.Getpid() + 1),
+		"LISTEN_FDS": "1",
+	}
+	got, err := listeners(func(k string) string { return env[k] }, listenFDsStart)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if got != nil {
+		t.Errorf("expected sockets for another process to be ignored, got %v", got)
+	}
+}
+
+func TestListeners_Named(t *testing.T) {
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("failed to listen: %v", err)
+	}
+	defer ln.Close()
+	f, err := ln.(*net.TCPListener).File()
+	if err != nil {
+		t.Fatalf("failed to get file: %v", err)
+	}
+	// listeners takes ownership of the descriptor and closes it, so hand
+	// it a raw duplicate that no *os.File refers to.
+	fd, err := syscall.Dup(int(f.Fd()))
+	f.Close()
+	if err != nil {
+		t.Fatalf("failed to dup: %v", err)
+	}
+
+	env := map[string]string{
+		"LISTEN_PID":     strconv.Itoa(os.Getpid()),
+		"LISTEN_FDS":     "1",
+		"LISTEN_FDNAMES": "https",
+	}
+	got, err := listeners(func(k string) string { return env[k] }, fd)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if len(got["https"]) != 1 {
+		t.Fatalf("expected one listener named https, got %v", got)
+	}
+	defer got["https"][0].Close()
+	if got["https"][0].Addr().String() != ln.Addr().String() {
+		t.Errorf("expected listener on %s, got %s", ln.Addr(), got["https"][0].Addr())
+	}
+}
+
+func TestNotify(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "notify.sock")
+	conn, err := net.ListenUnixgram("unixgram", &net.UnixAddr{Name: path, Net: "unixgram"})
+	if err != nil {
+		t.Fatalf("failed to listen: %v", err)
+	}
+	defer conn.Close()
+	t.Setenv("NOTIFY_SOCKET", path)
+
+	sent, err := Notify(Ready)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if !sent {
+		t.Error("expected notification to be sent")
+	}
+
+	buf := make([]byte, 64)
+	conn.SetReadDeadline(time.Now().Add(time.Second))
+	n, err := conn.Read(buf)
+	if err != nil {
+		t.Fatalf("failed to read notification: %v", err)
+	}
+	if string(buf[:n]) != Ready {
+		t.Errorf("expected %q, got %q", Ready, buf[:n])
+	}
+}
+
+func TestNotify_NoSocket(t *testing.T) {
+	t.Setenv("NOTIFY_SOCKET", "")
+	sent, err := Notify(Ready)
+	if err != nil || sent {
+		t.Errorf("expected no-op outside systemd, got sent=%v err=%v", sent, err)
+	}
+}
+
+func TestWatchdogInterval(t *testing.T) {
+	t.Setenv("WATCHDOG_USEC", "30000000")
+	t.Setenv("WATCHDOG_PID", strconv.Itoa(os.Getpid()))
+	if got := WatchdogInterval(); got != 30*time.Second {
+		t.Errorf("expected 30s, got %s", got)
+	}
+
+	t.Setenv("WATCHDOG_PID", strconv.Itoa(os.Getpid()+1))
+	if got := WatchdogInterval(); got != 0 {
+		t.Errorf("expected watchdog for another process to be ignored, got %s", got)
+	}
+
+	t.Setenv("WATCHDOG_USEC", "")
+	if got := WatchdogInterval(); got != 0 {
+		t.Errorf("expected 0 without WATCHDOG_USEC, got %s", got)
+	}
+}
@@ -0,0 +1,62 @@
+package main
+
+import (
+	"fmt"
+	"io/fs"
+	"slices"
+
+	"github.com/spf13/viper"
+	"github.com/tuggan/goip/web"
+)
+
+// loadThemes reads the theme, [site] and [[themes]] settings and parses the
+// templates of every theme in fsys, including the themes used by vhosts.
+func loadThemes(v *viper.Viper, fsys fs.FS, vhosts []web.VHost) (*web.Themes, error) {
+	site, err := loadSite(v)
+	if err != nil {
+		return nil, err
+	}
+	tables, err := configTables(v, "themes")
+	if err != nil {
+		return nil, err
+	}
+	var configs []web.ThemeConfig
+	for i, t := range tables {
+		c := web.ThemeConfig{
+			Name:  t.GetString("name"),
+			Hosts: t.GetStringSlice("hosts"),
+		}
+		if c.Name == "" {
+			return nil, fmt.Errorf("themes %d: name is not set", i)
+		}
+		if c.Site, err = loadSite(t); err != nil {
+			return nil, fmt.Errorf("theme %q: %w", c.Name, err)
+		}
+		configs = append(configs, c)
+	}
+	for _, vh := range vhosts {
+		if vh.Theme != "" && !slices.ContainsFunc(configs, func(c web.ThemeConfig) bool { return c.Name == vh.Theme }) {
+			configs = append(configs, web.ThemeConfig{Name: vh.Theme})
+		}
+	}
+	return web.LoadThemes(fsys, v.GetString("theme"), site, configs)
+}
+
+// loadSite reads the [site] table of v.
+func loadSite(v *viper.Viper) (web.Site, error) {
+	site := web.Site{
+		Name:   v.GetString("site.name"),
+		Footer: v.GetString("site.footer"),
+	}
+	if v.IsSet("site.vars") {
+		site.Vars = v.GetStringMapString("site.vars")
+	}
+	links, err := configTables(v, "site.links")
+	if err != nil {
+		return site, err
+	}
+	for _, l := range links {
+		site.Links = append(site.Links, web.Link{Name: l.GetString("name"), URL: l.GetString("url")})
+	}
+	return site, nil
+}
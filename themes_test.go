@@ -0,0 +1,81 @@
+package main
+
+import (
+	"testing"
+	"testing/fstest"
+)
+
+func TestLoadThemes_Config(t *testing.T) {
+	v := configFromTOML(t, `
+theme = "acme"
+
+[site]
+name = "GoIP"
+footer = "Run by the platform team"
+[site.vars]
+Support = "support@example.com"
+[[site.links]]
+name = "Status"
+url = "https://status.example.com"
+
+[[themes]]
+name = "acme"
+hosts = ["ip.acme.example"]
+[themes.site]
+name = "Acme IP"
+
+[[themes]]
+name = "other"
+hosts = ["ip.other.example"]
+`)
+	fsys := fstest.MapFS{
+		"index.html":              {Data: []byte("index")},
+		"error.html":              {Data: []byte("error")},
+		"themes/acme/index.html":  {Data: []byte("acme")},
+		"themes/other/index.html": {Data: []byte("other")},
+	}
+	themes, err := loadThemes(v, fsys, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	def := themes.ForHost("ip.example")
+	if def.Name != "acme" {
+		t.Errorf("expected the default theme to be acme, got %q", def.Name)
+	}
+	if def.Site.Name != "Acme IP" || def.Site.Footer != "Run by the platform team" {
+		t.Errorf("expected the theme site to extend [site], got %+v", def.Site)
+	}
+	if len(def.Site.Links) != 1 || def.Site.Links[0].URL != "https://status.example.com" {
+		t.Errorf("expected the site links, got %+v", def.Site.Links)
+	}
+	// Keys are case insensitive, so templates use lower case var names.
+	if def.Site.Vars["support"] != "support@example.com" {
+		t.Errorf("expected the site vars, got %v", def.Site.Vars)
+	}
+
+	other := themes.ForHost("ip.other.example")
+	if other.Name != "other" || other.Site.Name != "GoIP" {
+		t.Errorf("expected theme other with the global site name, got %q %+v", other.Name, other.Site)
+	}
+}
+
+func TestLoadThemes_Defaults(t *testing.T) {
+	themes, err := loadThemes(configFromTOML(t, ``), templateFS(""), nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := themes.Check(); err != nil {
+		t.Errorf("expected the built in templates to parse, got %v", err)
+	}
+	if theme := themes.ForHost("example.com"); theme.Name != "" {
+		t.Errorf("expected the templates of the template directory, got theme %q", theme.Name)
+	}
+
+	if _, err := loadThemes(configFromTOML(t, `
+[[themes]]
+hosts = ["ip.example"]
+`), templateFS(""), nil); err == nil {
+		t.Error("expected a theme without a name to fail")
+	}
+}
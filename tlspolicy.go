@@ -0,0 +1,334 @@
+package main
+
+import (
+	"crypto/rand"
+	"crypto/tls"
+	"fmt"
+	"io"
+	"net/http"
+	"slices"
+	"strings"
+	"sync/atomic"
+	"time"
+
+	"github.com/spf13/viper"
+	"golang.org/x/crypto/acme"
+)
+
+// defaultTLSPolicy is the preset used when tlsPolicy is not set.
+const defaultTLSPolicy = "intermediate"
+
+// sessionTicketKeys is how many session ticket keys are kept when they are
+// rotated, so tickets stay valid for that many rotation periods.
+const sessionTicketKeys = 2
+
+// tlsPolicy holds the protocol settings of a TLS listener.
+type tlsPolicy struct {
+	// Name is the preset the policy is based on.
+	Name       string
+	MinVersion uint16
+	// MaxVersion is zero for the highest version Go supports.
+	MaxVersion uint16
+	// CipherSuites only applies to TLS 1.2 and below, nil uses Go's
+	// defaults.
+	CipherSuites []uint16
+	// Curves nil uses Go's defaults.
+	Curves []tls.CurveID
+	// ALPN lists the protocols offered, in order of preference.
+	ALPN           []string
+	SessionTickets bool
+	// TicketRotation is how often the session ticket keys are replaced.
+	// Zero leaves the rotation to Go, which replaces them daily.
+	TicketRotation time.Duration
+}
+
+// tlsPolicies are the named presets, following Mozilla's server side TLS
+// recommendations.
+var tlsPolicies = map[string]func() tlsPolicy{
+	// modern only allows TLS 1.3, for clients from 2019 on.
+	"modern": func() tlsPolicy {
+		return tlsPolicy{
+			Name:           "modern",
+			MinVersion:     tls.VersionTLS13,
+			Curves:         []tls.CurveID{tls.X25519MLKEM768, tls.X25519, tls.CurveP256, tls.CurveP384},
+			ALPN:           []string{"h2", "http/1.1"},
+			SessionTickets: true,
+		}
+	},
+	// intermediate allows TLS 1.2 with forward secret AEAD cipher suites.
+	"intermediate": func() tlsPolicy {
+		return tlsPolicy{
+			Name:       "intermediate",
+			MinVersion: tls.VersionTLS12,
+			CipherSuites: []uint16{
+				tls.TLS_ECDHE_ECDSA_WITH_AES_128_GCM_SHA256,
+				tls.TLS_ECDHE_RSA_WITH_AES_128_GCM_SHA256,
+				tls.TLS_ECDHE_ECDSA_WITH_AES_256_GCM_SHA384,
+				tls.TLS_ECDHE_RSA_WITH_AES_256_GCM_SHA384,
+				tls.TLS_ECDHE_ECDSA_WITH_CHACHA20_POLY1305_SHA256,
+				tls.TLS_ECDHE_RSA_WITH_CHACHA20_POLY1305_SHA256,
+			},
+			Curves:         []tls.CurveID{tls.X25519MLKEM768, tls.X25519, tls.CurveP256, tls.CurveP384},
+			ALPN:           []string{"h2", "http/1.1"},
+			SessionTickets: true,
+		}
+	},
+	// legacy allows TLS 1.0 and CBC cipher suites for very old clients.
+	"legacy": func() tlsPolicy {
+		return tlsPolicy{
+			Name:       "legacy",
+			MinVersion: tls.VersionTLS10,
+			CipherSuites: []uint16{
+				tls.TLS_ECDHE_ECDSA_WITH_AES_128_GCM_SHA256,
+				tls.TLS_ECDHE_RSA_WITH_AES_128_GCM_SHA256,
+				tls.TLS_ECDHE_ECDSA_WITH_AES_256_GCM_SHA384,
+				tls.TLS_ECDHE_RSA_WITH_AES_256_GCM_SHA384,
+				tls.TLS_ECDHE_ECDSA_WITH_CHACHA20_POLY1305_SHA256,
+				tls.TLS_ECDHE_RSA_WITH_CHACHA20_POLY1305_SHA256,
+				tls.TLS_ECDHE_ECDSA_WITH_AES_128_CBC_SHA256,
+				tls.TLS_ECDHE_RSA_WITH_AES_128_CBC_SHA256,
+				tls.TLS_ECDHE_ECDSA_WITH_AES_128_CBC_SHA,
+				tls.TLS_ECDHE_RSA_WITH_AES_128_CBC_SHA,
+				tls.TLS_ECDHE_ECDSA_WITH_AES_256_CBC_SHA,
+				tls.TLS_ECDHE_RSA_WITH_AES_256_CBC_SHA,
+				tls.TLS_RSA_WITH_AES_128_GCM_SHA256,
+				tls.TLS_RSA_WITH_AES_256_GCM_SHA384,
+				tls.TLS_RSA_WITH_AES_128_CBC_SHA256,
+				tls.TLS_RSA_WITH_AES_128_CBC_SHA,
+				tls.TLS_RSA_WITH_AES_256_CBC_SHA,
+				tls.TLS_RSA_WITH_3DES_EDE_CBC_SHA,
+			},
+			Curves:         []tls.CurveID{tls.X25519MLKEM768, tls.X25519, tls.CurveP256, tls.CurveP384},
+			ALPN:           []string{"h2", "http/1.1"},
+			SessionTickets: true,
+		}
+	},
+}
+
+// tlsVersions maps the accepted version names to their values.
+var tlsVersions = map[string]uint16{
+	"1.0": tls.VersionTLS10,
+	"1.1": tls.VersionTLS11,
+	"1.2": tls.VersionTLS12,
+	"1.3": tls.VersionTLS13,
+}
+
+// tlsCurves maps the accepted lower case curve names to their IDs.
+var tlsCurves = map[string]tls.CurveID{
+	"x25519mlkem768": tls.X25519MLKEM768,
+	"x25519":         tls.X25519,
+	"p256":           tls.CurveP256,
+	"p-256":          tls.CurveP256,
+	"secp256r1":      tls.CurveP256,
+	"prime256v1":     tls.CurveP256,
+	"p384":           tls.CurveP384,
+	"p-384":          tls.CurveP384,
+	"secp384r1":      tls.CurveP384,
+	"p521":           tls.CurveP521,
+	"p-521":          tls.CurveP521,
+	"secp521r1":      tls.CurveP521,
+}
+
+// defaultTLSPolicyConfig returns the policy used when nothing is
+// configured.
+func defaultTLSPolicyConfig() tlsPolicy {
+	return tlsPolicies[defaultTLSPolicy]()
+}
+
+// loadTLSPolicy returns base with every setting under prefix in v applied,
+// the same way loadServerLimits does. Setting tlsPolicy starts over from
+// that preset, dropping the settings base was built from.
+func loadTLSPolicy(v *viper.Viper, prefix string, base tlsPolicy) (tlsPolicy, error) {
+	p := base
+	if v.IsSet(prefix + "tlsPolicy") {
+		name := strings.ToLower(v.GetString(prefix + "tlsPolicy"))
+		preset, ok := tlsPolicies[name]
+		if !ok {
+			return p, fmt.Errorf("%stlsPolicy: unknown preset %q, expected modern, intermediate or legacy", prefix, name)
+		}
+		p = preset()
+	}
+	var err error
+	if v.IsSet(prefix + "tlsMinVersion") {
+		if p.MinVersion, err = parseTLSVersion(v.GetString(prefix + "tlsMinVersion")); err != nil {
+			return p, fmt.Errorf("%stlsMinVersion: %w", prefix, err)
+		}
+	}
+	if v.IsSet(prefix + "tlsMaxVersion") {
+		if p.MaxVersion, err = parseTLSVersion(v.GetString(prefix + "tlsMaxVersion")); err != nil {
+			return p, fmt.Errorf("%stlsMaxVersion: %w", prefix, err)
+		}
+	}
+	if p.MaxVersion != 0 && p.MaxVersion < p.MinVersion {
+		return p, fmt.Errorf("%stlsMaxVersion %s is below tlsMinVersion %s", prefix,
+			tls.VersionName(p.MaxVersion), tls.VersionName(p.MinVersion))
+	}
+	if v.IsSet(prefix + "tlsCipherSuites") {
+		if p.MinVersion >= tls.VersionTLS13 {
+			return p, fmt.Errorf("%stlsCipherSuites: cipher suites do not apply to TLS 1.3", prefix)
+		}
+		if p.CipherSuites, err = parseCipherSuites(v.GetStringSlice(prefix + "tlsCipherSuites")); err != nil {
+			return p, fmt.Errorf("%stlsCipherSuites: %w", prefix, err)
+		}
+	}
+	if v.IsSet(prefix + "tlsCurves") {
+		if p.Curves, err = parseCurves(v.GetStringSlice(prefix + "tlsCurves")); err != nil {
+			return p, fmt.Errorf("%stlsCurves: %w", prefix, err)
+		}
+	}
+	if v.IsSet(prefix + "tlsALPN") {
+		p.ALPN = v.GetStringSlice(prefix + "tlsALPN")
+	}
+	if len(p.ALPN) == 0 {
+		return p, fmt.Errorf("%stlsALPN: at least one protocol must be offered", prefix)
+	}
+	for _, proto := range p.ALPN {
+		if proto != "h2" && proto != "http/1.1" {
+			return p, fmt.Errorf("%stlsALPN: unsupported protocol %q, expected h2 or http/1.1", prefix, proto)
+		}
+	}
+	// Go's HTTP/2 server refuses to start without one of these.
+	if slices.Contains(p.ALPN, "h2") && p.CipherSuites != nil && p.MinVersion < tls.VersionTLS13 &&
+		!slices.Contains(p.CipherSuites, tls.TLS_ECDHE_RSA_WITH_AES_128_GCM_SHA256) &&
+		!slices.Contains(p.CipherSuites, tls.TLS_ECDHE_ECDSA_WITH_AES_128_GCM_SHA256) {
+		return p, fmt.Errorf("%stlsCipherSuites: HTTP/2 requires TLS_ECDHE_RSA_WITH_AES_128_GCM_SHA256 or TLS_ECDHE_ECDSA_WITH_AES_128_GCM_SHA256", prefix)
+	}
+	if v.IsSet(prefix + "tlsSessionTickets") {
+		p.SessionTickets = v.GetBool(prefix + "tlsSessionTickets")
+	}
+	if v.IsSet(prefix + "tlsSessionTicketRotation") {
+		p.TicketRotation = v.GetDuration(prefix + "tlsSessionTicketRotation")
+		if p.TicketRotation < 0 {
+			return p, fmt.Errorf("%stlsSessionTicketRotation %s is negative", prefix, p.TicketRotation)
+		}
+	}
+	return p, nil
+}
+
+// parseTLSVersion parses a version such as "1.2", "TLS1.2" or "TLSv1.2".
+func parseTLSVersion(s string) (uint16, error) {
+	name := strings.TrimPrefix(strings.TrimPrefix(strings.ToLower(strings.TrimSpace(s)), "tls"), "v")
+	if v, ok := tlsVersions[name]; ok {
+		return v, nil
+	}
+	return 0, fmt.Errorf("unknown TLS version %q, expected 1.0, 1.1, 1.2 or 1.3", s)
+}
+
+// parseCipherSuites looks up cipher suites by their IANA names. The
+// insecure suites Go implements are accepted too, as listing them is the
+// only way to enable them.
+func parseCipherSuites(names []string) ([]uint16, error) {
+	suites := append(tls.CipherSuites(), tls.InsecureCipherSuites()...)
+	ids := make([]uint16, 0, len(names))
+	for _, name := range names {
+		i := slices.IndexFunc(suites, func(s *tls.CipherSuite) bool { return strings.EqualFold(s.Name, name) })
+		if i < 0 {
+			return nil, fmt.Errorf("unknown cipher suite %q", name)
+		}
+		if !slices.ContainsFunc(suites[i].SupportedVersions, func(v uint16) bool { return v < tls.VersionTLS13 }) {
+			return nil, fmt.Errorf("%s is a TLS 1.3 cipher suite, which cannot be configured", suites[i].Name)
+		}
+		ids = append(ids, suites[i].ID)
+	}
+	return ids, nil
+}
+
+// parseCurves looks up key exchange curves by name.
+func parseCurves(names []string) ([]tls.CurveID, error) {
+	curves := make([]tls.CurveID, 0, len(names))
+	for _, name := range names {
+		c, ok := tlsCurves[strings.ToLower(name)]
+		if !ok {
+			return nil, fmt.Errorf("unknown curve %q", name)
+		}
+		curves = append(curves, c)
+	}
+	return curves, nil
+}
+
+// apply configures srv.TLSConfig, which must be set, and the HTTP versions
+// srv serves according to p. The ACME challenge protocol is kept if the
+// config offers it. It returns the session ticket key rotation to start,
+// or nil when Go rotates the keys itself.
+func (p tlsPolicy) apply(srv *http.Server) *ticketKeys {
+	cfg := srv.TLSConfig
+	cfg.MinVersion = p.MinVersion
+	cfg.MaxVersion = p.MaxVersion
+	cfg.CipherSuites = p.CipherSuites
+	cfg.CurvePreferences = p.Curves
+	protos := slices.Clone(p.ALPN)
+	if slices.Contains(cfg.NextProtos, acme.ALPNProto) {
+		protos = append(protos, acme.ALPNProto)
+	}
+	cfg.NextProtos = protos
+	srv.Protocols = new(http.Protocols)
+	srv.Protocols.SetHTTP1(slices.Contains(p.ALPN, "http/1.1"))
+	srv.Protocols.SetHTTP2(slices.Contains(p.ALPN, "h2"))
+	cfg.SessionTicketsDisabled = !p.SessionTickets
+	if !p.SessionTickets || p.TicketRotation == 0 {
+		return nil
+	}
+	return newTicketKeys(cfg, p.TicketRotation)
+}
+
+// ticketKeys replaces the session ticket keys of a TLS config periodically.
+// The newest key encrypts new tickets, the older ones are kept to decrypt
+// tickets issued before the last rotations. http.Server serves a clone of
+// its TLSConfig, so every rotation stores a new copy of the config that
+// GetConfigForClient hands to the handshakes.
+type ticketKeys struct {
+	base     *tls.Config
+	current  atomic.Pointer[tls.Config]
+	interval time.Duration
+	keys     [][32]byte
+}
+
+// newTicketKeys sets a first random key and makes cfg serve the rotated
+// keys. cfg must not be changed afterwards, other than by wrapping its
+// GetConfigForClient.
+func newTicketKeys(cfg *tls.Config, interval time.Duration) *ticketKeys {
+	k := &ticketKeys{base: cfg.Clone(), interval: interval}
+	k.rotate()
+	cfg.GetConfigForClient = func(*tls.ClientHelloInfo) (*tls.Config, error) {
+		return k.current.Load(), nil
+	}
+	return k
+}
+
+// rotate adds a new key and drops the oldest one.
+func (k *ticketKeys) rotate() {
+	var key [32]byte
+	rand.Read(key[:])
+	k.keys = append([][32]byte{key}, k.keys...)
+	if len(k.keys) > sessionTicketKeys {
+		k.keys = k.keys[:sessionTicketKeys]
+	}
+	cfg := k.base.Clone()
+	cfg.SetSessionTicketKeys(k.keys)
+	k.current.Store(cfg)
+}
+
+// Start rotates the keys every interval until the returned closer is
+// closed.
+func (k *ticketKeys) Start() io.Closer {
+	stop := make(chan struct{})
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		t := time.NewTicker(k.interval)
+		defer t.Stop()
+		for {
+			select {
+			case <-t.C:
+				k.rotate()
+			case <-stop:
+				return
+			}
+		}
+	}()
+	return closerFunc(func() error {
+		close(stop)
+		<-done
+		return nil
+	})
+}
@@ -0,0 +1,211 @@
+package main
+
+import (
+	"context"
+	"crypto/tls"
+	"io"
+	"net"
+	"slices"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/tuggan/goip/logger"
+)
+
+func TestLoadTLSPolicy(t *testing.T) {
+	p, err := loadTLSPolicy(configFromTOML(t, ``), "", defaultTLSPolicyConfig())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if p.Name != "intermediate" || p.MinVersion != tls.VersionTLS12 || !p.SessionTickets ||
+		!slices.Equal(p.ALPN, []string{"h2", "http/1.1"}) {
+		t.Errorf("expected the intermediate preset by default, got %+v", p)
+	}
+
+	p, err = loadTLSPolicy(configFromTOML(t, `
+tlsPolicy = "Modern"
+tlsMaxVersion = "TLSv1.3"
+tlsCurves = ["X25519", "P-256"]
+tlsALPN = ["http/1.1"]
+tlsSessionTicketRotation = "1h"
+`), "", defaultTLSPolicyConfig())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if p.Name != "modern" || p.MinVersion != tls.VersionTLS13 || p.MaxVersion != tls.VersionTLS13 ||
+		p.CipherSuites != nil || !slices.Equal(p.Curves, []tls.CurveID{tls.X25519, tls.CurveP256}) ||
+		!slices.Equal(p.ALPN, []string{"http/1.1"}) || p.TicketRotation != time.Hour {
+		t.Errorf("unexpected policy %+v", p)
+	}
+
+	p, err = loadTLSPolicy(configFromTOML(t, `
+tlsMinVersion = "1.3"
+[admin]
+tlsPolicy = "legacy"
+tlsCipherSuites = ["TLS_ECDHE_RSA_WITH_AES_128_GCM_SHA256", "tls_rsa_with_3des_ede_cbc_sha"]
+tlsSessionTickets = false
+`), "admin.", defaultTLSPolicyConfig())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if p.MinVersion != tls.VersionTLS10 || p.SessionTickets ||
+		!slices.Equal(p.CipherSuites, []uint16{tls.TLS_ECDHE_RSA_WITH_AES_128_GCM_SHA256, tls.TLS_RSA_WITH_3DES_EDE_CBC_SHA}) {
+		t.Errorf("expected the prefixed settings on top of the legacy preset, got %+v", p)
+	}
+
+	for _, doc := range []string{
+		`tlsPolicy = "strict"`,
+		`tlsMinVersion = "1.4"`,
+		"tlsMinVersion = \"1.3\"\ntlsMaxVersion = \"1.2\"",
+		`tlsCipherSuites = ["TLS_ECDHE_RSA_WITH_RC5"]`,
+		`tlsCipherSuites = ["TLS_AES_128_GCM_SHA256"]`,
+		"tlsPolicy = \"modern\"\ntlsCipherSuites = [\"TLS_ECDHE_RSA_WITH_AES_128_GCM_SHA256\"]",
+		`tlsCipherSuites = ["TLS_ECDHE_RSA_WITH_AES_256_GCM_SHA384"]`,
+		`tlsCurves = ["P-192"]`,
+		`tlsALPN = []`,
+		`tlsALPN = ["spdy/3"]`,
+		`tlsSessionTicketRotation = "-1h"`,
+	} {
+		if _, err := loadTLSPolicy(configFromTOML(t, doc), "", defaultTLSPolicyConfig()); err == nil {
+			t.Errorf("expected an error for:\n%s", doc)
+		}
+	}
+
+	// Without HTTP/2 any cipher suite will do.
+	if _, err := loadTLSPolicy(configFromTOML(t, `
+tlsCipherSuites = ["TLS_ECDHE_RSA_WITH_AES_256_GCM_SHA384"]
+tlsALPN = ["http/1.1"]
+`), "", defaultTLSPolicyConfig()); err != nil {
+		t.Error(err)
+	}
+}
+
+func TestLoadListeners_TLSPolicy(t *testing.T) {
+	v := configFromTOML(t, `
+tlsEndpoint = ["127.0.0.1:443"]
+tlsCert = "main.crt"
+tlsKey = "main.key"
+tlsMinVersion = "1.3"
+
+[[listener]]
+name = "old-clients"
+address = "127.0.0.1:8443"
+tls = true
+tlsPolicy = "legacy"
+`)
+	listeners, err := loadListeners(v)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := map[string]uint16{"https": tls.VersionTLS13, "old-clients": tls.VersionTLS10}
+	for _, l := range listeners {
+		if l.TLSPolicy.MinVersion != want[l.Name] {
+			t.Errorf("listener %s: expected minimum version %s, got %s",
+				l.Name, tls.VersionName(want[l.Name]), tls.VersionName(l.TLSPolicy.MinVersion))
+		}
+	}
+
+	if _, err := loadListeners(configFromTOML(t, `
+[[listener]]
+address = "127.0.0.1:8443"
+tlsCert = "main.crt"
+tlsKey = "main.key"
+tlsMinVersion = "1.5"
+`)); err == nil {
+		t.Error("expected an invalid listener TLS version to fail")
+	}
+}
+
+func TestNewListener_TLSPolicy(t *testing.T) {
+	logger.Init(io.Discard, io.Discard, io.Discard, io.Discard)
+	now := time.Now()
+	certFile, keyFile := writeTestCert(t, now.Add(-time.Hour), now.Add(90*24*time.Hour), "ip.example.com")
+	themes, err := loadThemes(configFromTOML(t, ``), templateFS(""), nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	policy := defaultTLSPolicyConfig()
+	policy.ALPN = []string{"http/1.1"}
+	policy.TicketRotation = time.Hour
+	l, err := newListener(listenerConfig{Name: "https", Address: "127.0.0.1:0", TLS: true, TLSCert: certFile, TLSKey: keyFile, TLSPolicy: policy},
+		handlerOptions{themes: themes, staticDir: "static"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer l.rl.Stop()
+	if l.tickets == nil {
+		t.Fatal("expected the session ticket keys to be rotated")
+	}
+	defer l.tickets.Start().Close()
+
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	var wg sync.WaitGroup
+	wg.Add(1)
+	go serve(&wg, make(chan error, 1), l.srv, ln)
+	defer func() {
+		l.srv.Shutdown(context.Background())
+		wg.Wait()
+	}()
+
+	dial := func(cfg *tls.Config) (tls.ConnectionState, error) {
+		cfg.ServerName = "ip.example.com"
+		cfg.InsecureSkipVerify = true
+		conn, err := tls.Dial("tcp", ln.Addr().String(), cfg)
+		if err != nil {
+			return tls.ConnectionState{}, err
+		}
+		defer conn.Close()
+		return conn.ConnectionState(), nil
+	}
+	if _, err := dial(&tls.Config{MinVersion: tls.VersionTLS11, MaxVersion: tls.VersionTLS11}); err == nil {
+		t.Error("expected a TLS 1.1 handshake to fail")
+	}
+	if _, err := dial(&tls.Config{MaxVersion: tls.VersionTLS12, CipherSuites: []uint16{tls.TLS_ECDHE_RSA_WITH_AES_128_CBC_SHA, tls.TLS_ECDHE_ECDSA_WITH_AES_128_CBC_SHA}}); err == nil {
+		t.Error("expected a CBC cipher suite to be refused")
+	}
+	state, err := dial(&tls.Config{NextProtos: []string{"h2", "http/1.1"}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if state.NegotiatedProtocol != "http/1.1" {
+		t.Errorf("expected http/1.1 to be negotiated, got %q", state.NegotiatedProtocol)
+	}
+
+	k := l.tickets
+	first := k.keys[0]
+	k.rotate()
+	k.rotate()
+	if len(k.keys) != sessionTicketKeys || slices.Contains(k.keys, first) {
+		t.Errorf("expected the oldest key to be dropped after rotating, got %d keys", len(k.keys))
+	}
+
+	// Sessions resume until the key of their ticket has been rotated out.
+	// Resuming with an older key issues a ticket with the newest one.
+	cache := tls.NewLRUClientSessionCache(1)
+	resume := func() bool {
+		t.Helper()
+		state, err := dial(&tls.Config{MaxVersion: tls.VersionTLS12, ClientSessionCache: cache})
+		if err != nil {
+			t.Fatal(err)
+		}
+		return state.DidResume
+	}
+	resume()
+	if !resume() {
+		t.Fatal("expected the session to resume")
+	}
+	k.rotate()
+	if !resume() {
+		t.Error("expected the session to resume with the previous key")
+	}
+	for range sessionTicketKeys {
+		k.rotate()
+	}
+	if resume() {
+		t.Error("expected the session not to resume after its key was rotated out")
+	}
+}
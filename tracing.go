@@ -0,0 +1,82 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"os"
+
+	"github.com/spf13/viper"
+	"github.com/tuggan/goip/tracing"
+)
+
+// newTracer creates the tracer configured in the [tracing] section, or
+// returns nil when tracing is disabled.
+func newTracer(v *viper.Viper) (*tracing.Tracer, error) {
+	var exporter tracing.Exporter
+	switch e := v.GetString("tracing.exporter"); e {
+	case "", "none":
+		return nil, nil
+	case "stdout":
+		exporter = tracing.NewStdoutExporter(os.Stdout)
+	case "otlp":
+		endpoint := v.GetString("tracing.endpoint")
+		if endpoint == "" {
+			endpoint = "http://localhost:4318"
+		}
+		service := v.GetString("tracing.serviceName")
+		if service == "" {
+			service = "goip"
+		}
+		exporter = tracing.NewOTLPExporter(endpoint, v.GetStringMapString("tracing.headers"),
+			tracing.String("service.name", service),
+			tracing.String("service.version", Version))
+	default:
+		return nil, fmt.Errorf("unknown tracing exporter %q", e)
+	}
+	return tracing.NewTracer(exporter), nil
+}
+
+// tracingMiddleware starts a server span for every request. An incoming
+// traceparent header is only continued when trusted reports the peer as a
+// trusted proxy, so clients cannot attach spans to arbitrary traces.
+func tracingMiddleware(tracer *tracing.Tracer, listenerName string, trusted func(*http.Request) bool,
+	clientIP func(*http.Request) (string, error), next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var remote tracing.SpanContext
+		if trusted(r) {
+			remote, _ = tracing.ParseTraceparent(r.Header.Get("Traceparent"))
+		}
+		ctx, span := tracer.StartServer(r.Context(), r.Method, remote,
+			tracing.String("http.request.method", r.Method),
+			tracing.String("url.path", r.URL.Path),
+			tracing.String("server.address", r.Host),
+			tracing.String("network.protocol.name", "http"),
+			tracing.String("goip.listener", listenerName))
+		if span == nil {
+			next.ServeHTTP(w, r)
+			return
+		}
+		defer span.End()
+		if ip, err := clientIP(r); err == nil {
+			span.SetAttributes(tracing.String("client.address", ip))
+		}
+
+		r = r.WithContext(ctx)
+		rec := &responseRecorder{ResponseWriter: w}
+		next.ServeHTTP(rec, r)
+		if rec.code == 0 {
+			rec.code = http.StatusOK
+		}
+
+		// The mux sets the pattern on the request it was handed, which
+		// is the one passed on above.
+		if r.Pattern != "" {
+			span.SetName(r.Method + " " + r.Pattern)
+			span.SetAttributes(tracing.String("http.route", r.Pattern))
+		}
+		span.SetAttributes(tracing.Int("http.response.status_code", rec.code))
+		if rec.code >= 500 {
+			span.SetStatus(tracing.StatusError, http.StatusText(rec.code))
+		}
+	})
+}
@@ -0,0 +1,219 @@
+package tracing
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// StdoutExporter writes every span as a line of JSON, which is useful for
+// debugging and for log based collectors.
+type StdoutExporter struct {
+	mu sync.Mutex
+	w  io.Writer
+}
+
+// NewStdoutExporter creates an exporter writing to w.
+func NewStdoutExporter(w io.Writer) *StdoutExporter {
+	return &StdoutExporter{w: w}
+}
+
+// stdoutSpan is the JSON form of a span written by StdoutExporter.
+type stdoutSpan struct {
+	TraceID    string         `json:"traceId"`
+	SpanID     string         `json:"spanId"`
+	ParentID   string         `json:"parentSpanId,omitempty"`
+	Name       string         `json:"name"`
+	Kind       Kind           `json:"kind"`
+	Start      time.Time      `json:"start"`
+	Duration   string         `json:"duration"`
+	Attributes map[string]any `json:"attributes,omitempty"`
+	Status     int            `json:"status,omitempty"`
+	Message    string         `json:"statusMessage,omitempty"`
+}
+
+// Export writes spans to the writer.
+func (e *StdoutExporter) Export(ctx context.Context, spans []*Span) error {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	enc := json.NewEncoder(e.w)
+	for _, s := range spans {
+		out := stdoutSpan{
+			TraceID:  s.Context.TraceID.String(),
+			SpanID:   s.Context.SpanID.String(),
+			Name:     s.Name,
+			Kind:     s.Kind,
+			Start:    s.StartTime,
+			Duration: s.EndTime.Sub(s.StartTime).String(),
+			Status:   s.StatusCode,
+			Message:  s.StatusMessage,
+		}
+		if s.Parent.IsValid() {
+			out.ParentID = s.Parent.String()
+		}
+		if len(s.Attributes) > 0 {
+			out.Attributes = make(map[string]any, len(s.Attributes))
+			for _, a := range s.Attributes {
+				out.Attributes[a.Key] = a.Value
+			}
+		}
+		if err := enc.Encode(out); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// OTLPExporter sends spans to an OpenTelemetry collector with the OTLP/HTTP
+// protocol, JSON encoded.
+type OTLPExporter struct {
+	url      string
+	headers  map[string]string
+	resource []Attribute
+	client   *http.Client
+}
+
+// NewOTLPExporter creates an exporter posting to endpoint, e.g.
+// "http://localhost:4318". The /v1/traces path is added unless the
+// endpoint already has a path. Headers are sent with every request and
+// resource describes the service, e.g. service.name.
+func NewOTLPExporter(endpoint string, headers map[string]string, resource ...Attribute) *OTLPExporter {
+	url := strings.TrimRight(endpoint, "/")
+	if i := strings.Index(url, "://"); i == -1 || !strings.Contains(url[i+3:], "/") {
+		url += "/v1/traces"
+	}
+	return &OTLPExporter{
+		url:      url,
+		headers:  headers,
+		resource: resource,
+		client:   &http.Client{Timeout: 10 * time.Second},
+	}
+}
+
+// The OTLP JSON encoding, see
+// https://opentelemetry.io/docs/specs/otlp/#json-protobuf-encoding.
+// Ids are hex encoded and 64 bit integers are strings.
+type (
+	otlpRequest struct {
+		ResourceSpans []otlpResourceSpans `json:"resourceSpans"`
+	}
+	otlpResourceSpans struct {
+		Resource   otlpResource     `json:"resource"`
+		ScopeSpans []otlpScopeSpans `json:"scopeSpans"`
+	}
+	otlpResource struct {
+		Attributes []otlpKeyValue `json:"attributes"`
+	}
+	otlpScopeSpans struct {
+		Scope otlpScope  `json:"scope"`
+		Spans []otlpSpan `json:"spans"`
+	}
+	otlpScope struct {
+		Name string `json:"name"`
+	}
+	otlpSpan struct {
+		TraceID           string         `json:"traceId"`
+		SpanID            string         `json:"spanId"`
+		ParentSpanID      string         `json:"parentSpanId,omitempty"`
+		Name              string         `json:"name"`
+		Kind              Kind           `json:"kind"`
+		StartTimeUnixNano string         `json:"startTimeUnixNano"`
+		EndTimeUnixNano   string         `json:"endTimeUnixNano"`
+		Attributes        []otlpKeyValue `json:"attributes,omitempty"`
+		Status            otlpStatus     `json:"status"`
+	}
+	otlpStatus struct {
+		Code    int    `json:"code,omitempty"`
+		Message string `json:"message,omitempty"`
+	}
+	otlpKeyValue struct {
+		Key   string    `json:"key"`
+		Value otlpValue `json:"value"`
+	}
+	otlpValue struct {
+		StringValue *string  `json:"stringValue,omitempty"`
+		IntValue    *string  `json:"intValue,omitempty"`
+		DoubleValue *float64 `json:"doubleValue,omitempty"`
+		BoolValue   *bool    `json:"boolValue,omitempty"`
+	}
+)
+
+func otlpAttributes(attrs []Attribute) []otlpKeyValue {
+	kvs := make([]otlpKeyValue, 0, len(attrs))
+	for _, a := range attrs {
+		var v otlpValue
+		switch x := a.Value.(type) {
+		case string:
+			v.StringValue = &x
+		case int64:
+			s := strconv.FormatInt(x, 10)
+			v.IntValue = &s
+		case float64:
+			v.DoubleValue = &x
+		case bool:
+			v.BoolValue = &x
+		default:
+			s := fmt.Sprint(x)
+			v.StringValue = &s
+		}
+		kvs = append(kvs, otlpKeyValue{Key: a.Key, Value: v})
+	}
+	return kvs
+}
+
+// Export posts spans to the collector.
+func (e *OTLPExporter) Export(ctx context.Context, spans []*Span) error {
+	out := make([]otlpSpan, 0, len(spans))
+	for _, s := range spans {
+		span := otlpSpan{
+			TraceID:           s.Context.TraceID.String(),
+			SpanID:            s.Context.SpanID.String(),
+			Name:              s.Name,
+			Kind:              s.Kind,
+			StartTimeUnixNano: strconv.FormatInt(s.StartTime.UnixNano(), 10),
+			EndTimeUnixNano:   strconv.FormatInt(s.EndTime.UnixNano(), 10),
+			Attributes:        otlpAttributes(s.Attributes),
+			Status:            otlpStatus{Code: s.StatusCode, Message: s.StatusMessage},
+		}
+		if s.Parent.IsValid() {
+			span.ParentSpanID = s.Parent.String()
+		}
+		out = append(out, span)
+	}
+	body, err := json.Marshal(otlpRequest{ResourceSpans: []otlpResourceSpans{{
+		Resource: otlpResource{Attributes: otlpAttributes(e.resource)},
+		ScopeSpans: []otlpScopeSpans{{
+			Scope: otlpScope{Name: "github.com/tuggan/goip"},
+			Spans: out,
+		}},
+	}}})
+	if err != nil {
+		return err
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, e.url, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	for k, v := range e.headers {
+		req.Header.Set(k, v)
+	}
+	resp, err := e.client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	io.Copy(io.Discard, resp.Body)
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		return fmt.Errorf("collector at %s returned %s", e.url, resp.Status)
+	}
+	return nil
+}
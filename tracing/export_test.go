@@ -0,0 +1,105 @@
+package tracing
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+func testSpan() *Span {
+	start := time.Unix(1700000000, 0)
+	s := &Span{
+		Name:      "GET /",
+		Kind:      KindServer,
+		StartTime: start,
+		EndTime:   start.Add(time.Millisecond),
+		Attributes: []Attribute{
+			String("http.route", "/"),
+			Int("http.response.status_code", 200),
+			Bool("goip.ratelimit.allowed", true),
+		},
+	}
+	s.Context, _ = ParseTraceparent("00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01")
+	return s
+}
+
+func TestOTLPExporter(t *testing.T) {
+	var got otlpRequest
+	var path, auth string
+	collector := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		path = r.URL.Path
+		auth = r.Header.Get("Authorization")
+		if err := json.NewDecoder(r.Body).Decode(&got); err != nil {
+			t.Errorf("failed to decode request: %v", err)
+		}
+	}))
+	defer collector.Close()
+
+	exp := NewOTLPExporter(collector.URL, map[string]string{"Authorization": "Bearer secret"},
+		String("service.name", "goip"))
+	if err := exp.Export(context.Background(), []*Span{testSpan()}); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	if path != "/v1/traces" {
+		t.Errorf("expected /v1/traces, got %q", path)
+	}
+	if auth != "Bearer secret" {
+		t.Errorf("expected configured header to be sent, got %q", auth)
+	}
+	if len(got.ResourceSpans) != 1 || len(got.ResourceSpans[0].ScopeSpans) != 1 {
+		t.Fatalf("unexpected request %+v", got)
+	}
+	res := got.ResourceSpans[0].Resource.Attributes
+	if len(res) != 1 || res[0].Key != "service.name" || *res[0].Value.StringValue != "goip" {
+		t.Errorf("unexpected resource %+v", res)
+	}
+	spans := got.ResourceSpans[0].ScopeSpans[0].Spans
+	if len(spans) != 1 {
+		t.Fatalf("expected one span, got %d", len(spans))
+	}
+	s := spans[0]
+	if s.TraceID != "4bf92f3577b34da6a3ce929d0e0e4736" || s.Kind != KindServer || s.StartTimeUnixNano != "1700000000000000000" {
+		t.Errorf("unexpected span %+v", s)
+	}
+	if v := s.Attributes[1].Value.IntValue; v == nil || *v != "200" {
+		t.Errorf("expected int attribute encoded as string, got %+v", s.Attributes[1])
+	}
+}
+
+func TestOTLPExporter_CollectorError(t *testing.T) {
+	collector := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusBadRequest)
+	}))
+	defer collector.Close()
+
+	exp := NewOTLPExporter(collector.URL+"/custom/path", nil)
+	if exp.url != collector.URL+"/custom/path" {
+		t.Errorf("expected an endpoint with a path to be used as is, got %q", exp.url)
+	}
+	if err := exp.Export(context.Background(), []*Span{testSpan()}); err == nil {
+		t.Error("expected an error when the collector rejects the spans")
+	}
+}
+
+func TestStdoutExporter(t *testing.T) {
+	var buf bytes.Buffer
+	if err := NewStdoutExporter(&buf).Export(context.Background(), []*Span{testSpan()}); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	var got map[string]any
+	if err := json.Unmarshal(buf.Bytes(), &got); err != nil {
+		t.Fatalf("expected a JSON line, got %q: %v", buf.String(), err)
+	}
+	if got["name"] != "GET /" || got["duration"] != "1ms" {
+		t.Errorf("unexpected span %v", got)
+	}
+	if !strings.HasSuffix(buf.String(), "\n") {
+		t.Error("expected one span per line")
+	}
+}
@@ -0,0 +1,348 @@
+// Package tracing records request spans compatible with OpenTelemetry and
+// exports them over OTLP/HTTP or to a writer, using only the standard
+// library. Trace context is propagated with the W3C traceparent header.
+//
+// Handlers start child spans with Start, which is a no-op when the request
+// context carries no span, so instrumented code does not need to know
+// whether tracing is enabled.
+package tracing
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"fmt"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/tuggan/goip/logger"
+)
+
+// TraceID identifies a trace.
+type TraceID [16]byte
+
+// SpanID identifies a span within a trace.
+type SpanID [8]byte
+
+func (t TraceID) String() string { return hex.EncodeToString(t[:]) }
+func (s SpanID) String() string  { return hex.EncodeToString(s[:]) }
+
+// IsValid reports whether t is not all zeros.
+func (t TraceID) IsValid() bool { return t != TraceID{} }
+
+// IsValid reports whether s is not all zeros.
+func (s SpanID) IsValid() bool { return s != SpanID{} }
+
+// SpanContext is the part of a span propagated between processes.
+type SpanContext struct {
+	TraceID TraceID
+	SpanID  SpanID
+	Sampled bool
+}
+
+// IsValid reports whether sc has a trace and span id.
+func (sc SpanContext) IsValid() bool {
+	return sc.TraceID.IsValid() && sc.SpanID.IsValid()
+}
+
+// ParseTraceparent parses a W3C traceparent header value, e.g.
+// "00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01".
+func ParseTraceparent(s string) (SpanContext, bool) {
+	var sc SpanContext
+	parts := strings.Split(strings.TrimSpace(s), "-")
+	if len(parts) < 4 || len(parts[0]) != 2 || len(parts[1]) != 32 || len(parts[2]) != 16 || len(parts[3]) != 2 {
+		return sc, false
+	}
+	// Version ff is forbidden, version 00 has exactly four fields and
+	// later versions may append more.
+	if parts[0] == "ff" || (parts[0] == "00" && len(parts) != 4) {
+		return sc, false
+	}
+	var version, flags [1]byte
+	if _, err := hex.Decode(version[:], []byte(parts[0])); err != nil {
+		return sc, false
+	}
+	if _, err := hex.Decode(sc.TraceID[:], []byte(parts[1])); err != nil || strings.ToLower(parts[1]) != parts[1] {
+		return sc, false
+	}
+	if _, err := hex.Decode(sc.SpanID[:], []byte(parts[2])); err != nil || strings.ToLower(parts[2]) != parts[2] {
+		return sc, false
+	}
+	if _, err := hex.Decode(flags[:], []byte(parts[3])); err != nil {
+		return sc, false
+	}
+	sc.Sampled = flags[0]&1 == 1
+	return sc, sc.IsValid()
+}
+
+// Traceparent formats sc as a W3C traceparent header value.
+func (sc SpanContext) Traceparent() string {
+	flags := "00"
+	if sc.Sampled {
+		flags = "01"
+	}
+	return fmt.Sprintf("00-%s-%s-%s", sc.TraceID, sc.SpanID, flags)
+}
+
+// Kind is the OTLP span kind.
+type Kind int
+
+// Span kinds, numbered as in the OTLP protocol.
+const (
+	KindInternal Kind = 1
+	KindServer   Kind = 2
+)
+
+// Status codes, numbered as in the OTLP protocol.
+const (
+	StatusUnset = 0
+	StatusOK    = 1
+	StatusError = 2
+)
+
+// Attribute is a key/value pair attached to a span. Values are strings,
+// int64s, float64s or bools.
+type Attribute struct {
+	Key   string
+	Value any
+}
+
+// String returns a string attribute.
+func String(key, value string) Attribute { return Attribute{key, value} }
+
+// Int returns an integer attribute.
+func Int(key string, value int) Attribute { return Attribute{key, int64(value)} }
+
+// Bool returns a boolean attribute.
+func Bool(key string, value bool) Attribute { return Attribute{key, value} }
+
+// Span is a timed operation within a trace. A nil *Span is valid and
+// records nothing.
+type Span struct {
+	Name          string
+	Kind          Kind
+	Context       SpanContext
+	Parent        SpanID
+	StartTime     time.Time
+	EndTime       time.Time
+	Attributes    []Attribute
+	StatusCode    int
+	StatusMessage string
+
+	tracer *Tracer
+	mu     sync.Mutex
+	ended  bool
+}
+
+// SetName replaces the name of the span, e.g. once the route of a request
+// is known.
+func (s *Span) SetName(name string) {
+	if s == nil {
+		return
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if !s.ended {
+		s.Name = name
+	}
+}
+
+// SetAttributes adds attributes to the span.
+func (s *Span) SetAttributes(attrs ...Attribute) {
+	if s == nil {
+		return
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if !s.ended {
+		s.Attributes = append(s.Attributes, attrs...)
+	}
+}
+
+// SetStatus sets the status of the span. The message is only kept for
+// StatusError.
+func (s *Span) SetStatus(code int, message string) {
+	if s == nil {
+		return
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.ended {
+		return
+	}
+	s.StatusCode = code
+	if code == StatusError {
+		s.StatusMessage = message
+	} else {
+		s.StatusMessage = ""
+	}
+}
+
+// End ends the span and queues it for export. Later calls are no-ops.
+func (s *Span) End() {
+	if s == nil {
+		return
+	}
+	s.mu.Lock()
+	if s.ended {
+		s.mu.Unlock()
+		return
+	}
+	s.ended = true
+	s.EndTime = time.Now()
+	s.mu.Unlock()
+	s.tracer.enqueue(s)
+}
+
+type spanKey struct{}
+
+// ContextWithSpan returns a copy of ctx carrying s.
+func ContextWithSpan(ctx context.Context, s *Span) context.Context {
+	return context.WithValue(ctx, spanKey{}, s)
+}
+
+// SpanFromContext returns the span carried by ctx, or nil.
+func SpanFromContext(ctx context.Context) *Span {
+	s, _ := ctx.Value(spanKey{}).(*Span)
+	return s
+}
+
+// Start starts a child of the span in ctx. Without a span in ctx it
+// returns ctx unchanged and a nil span.
+func Start(ctx context.Context, name string, attrs ...Attribute) (context.Context, *Span) {
+	parent := SpanFromContext(ctx)
+	if parent == nil {
+		return ctx, nil
+	}
+	s := parent.tracer.newSpan(name, KindInternal, parent.Context.TraceID, parent.Context.SpanID, attrs)
+	return ContextWithSpan(ctx, s), s
+}
+
+// Exporter sends finished spans to a backend.
+type Exporter interface {
+	Export(ctx context.Context, spans []*Span) error
+}
+
+// Batching limits of the tracer.
+const (
+	queueSize    = 2048
+	maxBatchSize = 512
+	batchTimeout = 5 * time.Second
+)
+
+// Tracer starts spans and exports them in batches in the background.
+type Tracer struct {
+	exporter Exporter
+	mu       sync.RWMutex
+	closed   bool
+	queue    chan *Span
+	done     chan struct{}
+}
+
+// NewTracer creates a tracer exporting to exporter. Shutdown must be
+// called to flush the remaining spans.
+func NewTracer(exporter Exporter) *Tracer {
+	t := &Tracer{
+		exporter: exporter,
+		queue:    make(chan *Span, queueSize),
+		done:     make(chan struct{}),
+	}
+	go t.run()
+	return t
+}
+
+// StartServer starts the span of an incoming request. A valid remote
+// parent continues its trace, otherwise a new trace is started. It
+// returns a nil span when the remote parent is not sampled.
+func (t *Tracer) StartServer(ctx context.Context, name string, remote SpanContext, attrs ...Attribute) (context.Context, *Span) {
+	traceID := remote.TraceID
+	var parent SpanID
+	if remote.IsValid() {
+		if !remote.Sampled {
+			return ctx, nil
+		}
+		parent = remote.SpanID
+	} else {
+		rand.Read(traceID[:])
+	}
+	s := t.newSpan(name, KindServer, traceID, parent, attrs)
+	return ContextWithSpan(ctx, s), s
+}
+
+func (t *Tracer) newSpan(name string, kind Kind, traceID TraceID, parent SpanID, attrs []Attribute) *Span {
+	s := &Span{
+		Name:       name,
+		Kind:       kind,
+		Context:    SpanContext{TraceID: traceID, Sampled: true},
+		Parent:     parent,
+		StartTime:  time.Now(),
+		Attributes: attrs,
+		tracer:     t,
+	}
+	rand.Read(s.Context.SpanID[:])
+	return s
+}
+
+func (t *Tracer) enqueue(s *Span) {
+	t.mu.RLock()
+	defer t.mu.RUnlock()
+	if t.closed {
+		return
+	}
+	select {
+	case t.queue <- s:
+	default:
+		// Dropping spans is better than blocking requests when the
+		// backend cannot keep up.
+	}
+}
+
+func (t *Tracer) run() {
+	defer close(t.done)
+	ticker := time.NewTicker(batchTimeout)
+	defer ticker.Stop()
+
+	var batch []*Span
+	flush := func() {
+		if len(batch) == 0 {
+			return
+		}
+		if err := t.exporter.Export(context.Background(), batch); err != nil {
+			logger.Warning("Failed to export %d spans: %s", len(batch), err)
+		}
+		batch = nil
+	}
+	for {
+		select {
+		case s, ok := <-t.queue:
+			if !ok {
+				flush()
+				return
+			}
+			batch = append(batch, s)
+			if len(batch) >= maxBatchSize {
+				flush()
+			}
+		case <-ticker.C:
+			flush()
+		}
+	}
+}
+
+// Shutdown exports the queued spans and stops the tracer. Spans finished
+// afterwards are dropped.
+func (t *Tracer) Shutdown(ctx context.Context) error {
+	t.mu.Lock()
+	if !t.closed {
+		t.closed = true
+		close(t.queue)
+	}
+	t.mu.Unlock()
+	select {
+	case <-t.done:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
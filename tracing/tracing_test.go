@@ -0,0 +1,105 @@
+package tracing
+
+import (
+	"context"
+	"sync"
+	"testing"
+)
+
+// recordingExporter keeps every exported span.
+type recordingExporter struct {
+	mu    sync.Mutex
+	spans []*Span
+}
+
+func (e *recordingExporter) Export(ctx context.Context, spans []*Span) error {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	e.spans = append(e.spans, spans...)
+	return nil
+}
+
+func TestParseTraceparent(t *testing.T) {
+	const valid = "00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01"
+	sc, ok := ParseTraceparent(valid)
+	if !ok {
+		t.Fatalf("expected %q to parse", valid)
+	}
+	if sc.TraceID.String() != "4bf92f3577b34da6a3ce929d0e0e4736" || sc.SpanID.String() != "00f067aa0ba902b7" || !sc.Sampled {
+		t.Errorf("unexpected span context %+v", sc)
+	}
+	if got := sc.Traceparent(); got != valid {
+		t.Errorf("expected round trip to %q, got %q", valid, got)
+	}
+
+	invalid := []string{
+		"",
+		"00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7",
+		"00-00000000000000000000000000000000-00f067aa0ba902b7-01",
+		"00-4bf92f3577b34da6a3ce929d0e0e4736-0000000000000000-01",
+		"00-4BF92F3577B34DA6A3CE929D0E0E4736-00f067aa0ba902b7-01",
+		"ff-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01",
+		"00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01-extra",
+		"00-zzf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01",
+	}
+	for _, s := range invalid {
+		if _, ok := ParseTraceparent(s); ok {
+			t.Errorf("expected %q to be rejected", s)
+		}
+	}
+}
+
+func TestStart_WithoutSpan(t *testing.T) {
+	ctx := context.Background()
+	got, span := Start(ctx, "child")
+	if span != nil || got != ctx {
+		t.Error("expected Start to be a no-op without a span in the context")
+	}
+	// A nil span must be safe to use.
+	span.SetAttributes(String("k", "v"))
+	span.SetStatus(StatusError, "failed")
+	span.End()
+}
+
+func TestTracer_ExportsOnShutdown(t *testing.T) {
+	exp := &recordingExporter{}
+	tracer := NewTracer(exp)
+
+	remote, _ := ParseTraceparent("00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01")
+	ctx, server := tracer.StartServer(context.Background(), "GET /", remote)
+	_, child := Start(ctx, "renderTemplate")
+	child.End()
+	server.End()
+
+	if err := tracer.Shutdown(context.Background()); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if len(exp.spans) != 2 {
+		t.Fatalf("expected 2 spans, got %d", len(exp.spans))
+	}
+	got := exp.spans[1]
+	if got.Context.TraceID != remote.TraceID || got.Parent != remote.SpanID || got.Kind != KindServer {
+		t.Errorf("expected server span to continue the remote trace, got %+v", got)
+	}
+	if c := exp.spans[0]; c.Parent != got.Context.SpanID || c.Context.TraceID != remote.TraceID {
+		t.Errorf("expected child of the server span, got %+v", c)
+	}
+
+	// Spans ended after shutdown are dropped instead of panicking.
+	_, late := tracer.StartServer(context.Background(), "late", SpanContext{})
+	late.End()
+}
+
+func TestTracer_NotSampled(t *testing.T) {
+	tracer := NewTracer(&recordingExporter{})
+	defer tracer.Shutdown(context.Background())
+
+	remote, _ := ParseTraceparent("00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-00")
+	if _, span := tracer.StartServer(context.Background(), "GET /", remote); span != nil {
+		t.Error("expected no span for an unsampled parent")
+	}
+	_, span := tracer.StartServer(context.Background(), "GET /", SpanContext{})
+	if span == nil || !span.Context.TraceID.IsValid() || span.Parent.IsValid() {
+		t.Errorf("expected a new root span, got %+v", span)
+	}
+}
@@ -0,0 +1,98 @@
+package main
+
+import (
+	"context"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+
+	"github.com/tuggan/goip/logger"
+	"github.com/tuggan/goip/tracing"
+	"github.com/tuggan/goip/web"
+)
+
+type recordingExporter struct {
+	mu    sync.Mutex
+	spans []*tracing.Span
+}
+
+func (e *recordingExporter) Export(ctx context.Context, spans []*tracing.Span) error {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	e.spans = append(e.spans, spans...)
+	return nil
+}
+
+func TestTracingMiddleware(t *testing.T) {
+	logger.Init(io.Discard, io.Discard, io.Discard, io.Discard)
+	const parent = "00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01"
+
+	h := web.NewHandler(false, "html", "v", "b", "d", "a", "e", []string{"10.0.0.1"})
+	mux := http.NewServeMux()
+	mux.HandleFunc("/", h.MainHandler)
+
+	tests := []struct {
+		name       string
+		remoteAddr string
+		wantParent bool
+	}{
+		{"trusted proxy", "10.0.0.1:1234", true},
+		{"direct client", "192.0.2.1:1234", false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			exp := &recordingExporter{}
+			tracer := tracing.NewTracer(exp)
+			handler := tracingMiddleware(tracer, "http", h.TrustedPeer, h.ClientIP, mux)
+
+			req := httptest.NewRequest(http.MethodGet, "/", nil)
+			req.RemoteAddr = tt.remoteAddr
+			req.Header.Set("Traceparent", parent)
+			handler.ServeHTTP(httptest.NewRecorder(), req)
+			tracer.Shutdown(context.Background())
+
+			var server, render *tracing.Span
+			for _, s := range exp.spans {
+				switch s.Name {
+				case "GET /":
+					server = s
+				case "renderTemplate":
+					render = s
+				}
+			}
+			if server == nil || render == nil {
+				t.Fatalf("expected a server and a renderTemplate span, got %d spans", len(exp.spans))
+			}
+			continued := server.Context.TraceID.String() == "4bf92f3577b34da6a3ce929d0e0e4736"
+			if continued != tt.wantParent {
+				t.Errorf("expected traceparent to be continued: %v, got trace %s", tt.wantParent, server.Context.TraceID)
+			}
+			if render.Parent != server.Context.SpanID {
+				t.Error("expected renderTemplate to be a child of the server span")
+			}
+			attrs := map[string]any{}
+			for _, a := range server.Attributes {
+				attrs[a.Key] = a.Value
+			}
+			if attrs["http.route"] != "/" || attrs["http.response.status_code"] != int64(200) || attrs["client.address"] != tt.remoteAddr[:len(tt.remoteAddr)-5] {
+				t.Errorf("unexpected attributes %v", attrs)
+			}
+		})
+	}
+}
+
+func TestNewTracer(t *testing.T) {
+	if tracer, err := newTracer(configFromTOML(t, ``)); tracer != nil || err != nil {
+		t.Errorf("expected tracing to be disabled by default, got %v, %v", tracer, err)
+	}
+	tracer, err := newTracer(configFromTOML(t, "[tracing]\nexporter = \"stdout\"\n"))
+	if err != nil || tracer == nil {
+		t.Fatalf("expected a stdout tracer, got %v, %v", tracer, err)
+	}
+	tracer.Shutdown(context.Background())
+	if _, err := newTracer(configFromTOML(t, "[tracing]\nexporter = \"zipkin\"\n")); err == nil {
+		t.Error("expected an unknown exporter to be rejected")
+	}
+}
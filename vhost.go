@@ -0,0 +1,42 @@
+package main
+
+import (
+	"net/http"
+
+	"github.com/spf13/viper"
+	"github.com/tuggan/goip/web"
+)
+
+// loadVHosts reads the [[vhost]] tables.
+func loadVHosts(v *viper.Viper) ([]web.VHost, error) {
+	tables, err := configTables(v, "vhost")
+	if err != nil {
+		return nil, err
+	}
+	var vhosts []web.VHost
+	for _, t := range tables {
+		vh := web.VHost{
+			Hosts:    t.GetStringSlice("hosts"),
+			Theme:    t.GetString("theme"),
+			Format:   t.GetString("format"),
+			Redirect: t.GetString("redirect"),
+		}
+		if t.IsSet("routes") {
+			vh.Routes = t.GetStringSlice("routes")
+		}
+		vhosts = append(vhosts, vh)
+	}
+	return vhosts, nil
+}
+
+// vhostRoute wraps the handler registered under pattern so it answers
+// notFound for virtual hosts that do not serve the route.
+func vhostRoute(pattern string, hf, notFound http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if !web.VHostFromContext(r.Context()).Allows(pattern) {
+			notFound(w, r)
+			return
+		}
+		hf(w, r)
+	}
+}
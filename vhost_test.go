@@ -0,0 +1,90 @@
+package main
+
+import (
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/tuggan/goip/logger"
+	"github.com/tuggan/goip/web"
+)
+
+func TestLoadVHosts(t *testing.T) {
+	v := configFromTOML(t, `
+[[vhost]]
+hosts = ["ip.example.com"]
+
+[[vhost]]
+hosts = ["ipv4.example.com", "ipv6.example.com"]
+format = "text"
+routes = ["/", "/health"]
+theme = "plain"
+redirect = "ip.example.com"
+`)
+	vhosts, err := loadVHosts(v)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(vhosts) != 2 {
+		t.Fatalf("expected 2 vhosts, got %d", len(vhosts))
+	}
+	if vhosts[0].Routes != nil {
+		t.Errorf("expected every route without routes, got %v", vhosts[0].Routes)
+	}
+	vh := vhosts[1]
+	if len(vh.Hosts) != 2 || vh.Format != "text" || vh.Theme != "plain" || vh.Redirect != "ip.example.com" ||
+		len(vh.Routes) != 2 {
+		t.Errorf("unexpected vhost %+v", vh)
+	}
+}
+
+func TestNewListener_VHosts(t *testing.T) {
+	logger.Init(io.Discard, io.Discard, io.Discard, io.Discard)
+	vhosts, err := web.NewVHosts([]web.VHost{
+		{Hosts: []string{"ipv4.example.com"}, Format: web.FormatText, Routes: []string{"/"}},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	themes, err := loadThemes(configFromTOML(t, ``), templateFS(""), nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	l, err := newListener(listenerConfig{Name: "http", Address: "127.0.0.1:0"},
+		handlerOptions{themes: themes, vhosts: vhosts, staticDir: "static"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer l.rl.Stop()
+
+	get := func(host, path string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(http.MethodGet, path, nil)
+		req.Host = host
+		req.RemoteAddr = "1.2.3.4:5678"
+		w := httptest.NewRecorder()
+		l.srv.Handler.ServeHTTP(w, req)
+		return w
+	}
+
+	if w := get("ipv4.example.com", "/"); w.Body.String() != "1.2.3.4\n" {
+		t.Errorf("expected the text format on the vhost, got %q", w.Body.String())
+	}
+	if w := get("ipv4.example.com", "/health"); w.Code != http.StatusNotFound {
+		t.Errorf("expected a route the vhost does not serve to be 404, got %d", w.Code)
+	}
+	if w := get("ip.example.com", "/health"); w.Code != http.StatusOK {
+		t.Errorf("expected other hosts to serve every route, got %d", w.Code)
+	}
+	if w := get("ip.example.com", "/"); !strings.Contains(w.Body.String(), "<html") {
+		t.Error("expected other hosts to get the HTML page")
+	}
+
+	// The metrics around the vhost middleware still see the matched route.
+	before := requestsTotal.Value("http", "/health", "404")
+	get("ipv4.example.com", "/health")
+	if got := requestsTotal.Value("http", "/health", "404") - before; got != 1 {
+		t.Errorf("expected the vhost request to be counted under its route, got %v", got)
+	}
+}
@@ -0,0 +1,80 @@
+package web
+
+import (
+	"slices"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Ban is a client that is refused service until Expires. A zero Expires
+// bans the client until it is unbanned.
+type Ban struct {
+	Client  string    `json:"client"`
+	Expires time.Time `json:"expires,omitzero"`
+}
+
+// BanList holds banned clients, keyed the same way the rate limiter keys
+// visitors. It is safe for concurrent use and shared between listeners.
+type BanList struct {
+	mu   sync.Mutex
+	bans map[string]time.Time
+}
+
+// NewBanList creates an empty ban list.
+func NewBanList() *BanList {
+	return &BanList{bans: make(map[string]time.Time)}
+}
+
+// Ban refuses client for d, or until it is unbanned if d is zero.
+func (b *BanList) Ban(client string, d time.Duration) {
+	var expires time.Time
+	if d > 0 {
+		expires = time.Now().Add(d)
+	}
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.bans[client] = expires
+}
+
+// Unban removes client from the list and reports whether it was banned.
+func (b *BanList) Unban(client string) bool {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	_, ok := b.bans[client]
+	delete(b.bans, client)
+	return ok
+}
+
+// Banned reports whether client is currently banned. Expired bans are
+// removed.
+func (b *BanList) Banned(client string) bool {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	expires, ok := b.bans[client]
+	if !ok {
+		return false
+	}
+	if !expires.IsZero() && time.Now().After(expires) {
+		delete(b.bans, client)
+		return false
+	}
+	return true
+}
+
+// List returns the active bans sorted by client.
+func (b *BanList) List() []Ban {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	now := time.Now()
+	bans := make([]Ban, 0, len(b.bans))
+	for client, expires := range b.bans {
+		if !expires.IsZero() && now.After(expires) {
+			delete(b.bans, client)
+			continue
+		}
+		bans = append(bans, Ban{Client: client, Expires: expires})
+	}
+	slices.SortFunc(bans, func(a, b Ban) int { return strings.Compare(a.Client, b.Client) })
+	return bans
+}
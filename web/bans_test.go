@@ -0,0 +1,49 @@
+package web
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+func TestBanList(t *testing.T) {
+	b := NewBanList()
+	b.Ban("10.0.0.1", 0)
+	b.Ban("10.0.0.2", time.Hour)
+	b.Ban("10.0.0.3", time.Nanosecond)
+	time.Sleep(time.Millisecond)
+
+	if !b.Banned("10.0.0.1") || !b.Banned("10.0.0.2") {
+		t.Error("expected active bans to apply")
+	}
+	if b.Banned("10.0.0.3") {
+		t.Error("expected an expired ban to be lifted")
+	}
+	if list := b.List(); len(list) != 2 || list[0].Client != "10.0.0.1" || !list[0].Expires.IsZero() {
+		t.Errorf("unexpected ban list %+v", list)
+	}
+	if !b.Unban("10.0.0.1") || b.Unban("10.0.0.1") {
+		t.Error("expected Unban to report whether the client was banned")
+	}
+}
+
+func TestMiddleware_Banned(t *testing.T) {
+	rl := NewRateLimiter(0, 1, 0)
+	bans := NewBanList()
+	rl.SetBanList(bans)
+	bans.Ban("192.0.2.1", 0)
+
+	h := rl.Middleware(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusOK)
+	}))
+	for addr, code := range map[string]int{"192.0.2.1:1234": http.StatusForbidden, "192.0.2.2:1234": http.StatusOK} {
+		req := httptest.NewRequest(http.MethodGet, "/", nil)
+		req.RemoteAddr = addr
+		w := httptest.NewRecorder()
+		h.ServeHTTP(w, req)
+		if w.Code != code {
+			t.Errorf("%s: expected %d, got %d", addr, code, w.Code)
+		}
+	}
+}
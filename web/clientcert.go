@@ -0,0 +1,91 @@
+package web
+
+import (
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/hex"
+	"encoding/json"
+	"net/http"
+	"time"
+
+	"github.com/tuggan/goip/logger"
+)
+
+// certificateReport is the JSON form of a certificate the client sent.
+type certificateReport struct {
+	Subject            string    `json:"subject"`
+	Issuer             string    `json:"issuer"`
+	SerialNumber       string    `json:"serialNumber"`
+	NotBefore          time.Time `json:"notBefore"`
+	NotAfter           time.Time `json:"notAfter"`
+	DNSNames           []string  `json:"dnsNames,omitempty"`
+	IPAddresses        []string  `json:"ipAddresses,omitempty"`
+	EmailAddresses     []string  `json:"emailAddresses,omitempty"`
+	URIs               []string  `json:"uris,omitempty"`
+	IsCA               bool      `json:"isCA"`
+	SignatureAlgorithm string    `json:"signatureAlgorithm"`
+	PublicKeyAlgorithm string    `json:"publicKeyAlgorithm"`
+	// SHA256 is the fingerprint of the DER encoded certificate, the one
+	// openssl x509 -fingerprint -sha256 prints.
+	SHA256 string `json:"sha256"`
+}
+
+// clientCertReport is the JSON served on /tls/client-certificate.
+type clientCertReport struct {
+	TLS bool `json:"tls"`
+	// Presented is true when the client sent a certificate.
+	Presented bool `json:"presented"`
+	// Verified is true when the certificate was verified against the
+	// client CA of the listener.
+	Verified bool `json:"verified"`
+	// Certificates is the chain the client sent, its own certificate
+	// first.
+	Certificates []certificateReport `json:"certificates,omitempty"`
+}
+
+func newCertificateReport(cert *x509.Certificate) certificateReport {
+	sum := sha256.Sum256(cert.Raw)
+	c := certificateReport{
+		Subject:            cert.Subject.String(),
+		Issuer:             cert.Issuer.String(),
+		SerialNumber:       hex.EncodeToString(cert.SerialNumber.Bytes()),
+		NotBefore:          cert.NotBefore.UTC(),
+		NotAfter:           cert.NotAfter.UTC(),
+		DNSNames:           cert.DNSNames,
+		EmailAddresses:     cert.EmailAddresses,
+		IsCA:               cert.IsCA,
+		SignatureAlgorithm: cert.SignatureAlgorithm.String(),
+		PublicKeyAlgorithm: cert.PublicKeyAlgorithm.String(),
+		SHA256:             hex.EncodeToString(sum[:]),
+	}
+	for _, ip := range cert.IPAddresses {
+		c.IPAddresses = append(c.IPAddresses, ip.String())
+	}
+	for _, u := range cert.URIs {
+		c.URIs = append(c.URIs, u.String())
+	}
+	return c
+}
+
+// ClientCertHandler reports the certificate chain the client presented
+// during the TLS handshake as JSON. Listeners only ask for client
+// certificates when tlsClientAuth is set.
+func (h handler) ClientCertHandler(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Server", h.server)
+	w.Header().Set("Content-Type", "application/json")
+	report := clientCertReport{TLS: r.TLS != nil}
+	if r.TLS != nil {
+		report.Presented = len(r.TLS.PeerCertificates) > 0
+		report.Verified = len(r.TLS.VerifiedChains) > 0
+		for _, cert := range r.TLS.PeerCertificates {
+			report.Certificates = append(report.Certificates, newCertificateReport(cert))
+		}
+	}
+	enc := json.NewEncoder(w)
+	enc.SetIndent("", "  ")
+	if err := enc.Encode(report); err != nil {
+		logger.Error("failed to write client certificate report: %s", err)
+		return
+	}
+	logger.Access(r, http.StatusOK)
+}
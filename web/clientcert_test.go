@@ -0,0 +1,90 @@
+package web
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/hex"
+	"encoding/json"
+	"math/big"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"slices"
+	"testing"
+	"time"
+)
+
+func TestClientCertHandler(t *testing.T) {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	spiffe, _ := url.Parse("spiffe://mesh.example.com/ns/default/sa/api")
+	notBefore := time.Date(2026, 1, 1, 0, 0, 0, 0, time.UTC)
+	tmpl := &x509.Certificate{
+		SerialNumber: big.NewInt(0x1f2e),
+		Subject:      pkix.Name{CommonName: "api", Organization: []string{"Mesh"}},
+		DNSNames:     []string{"api.mesh.example.com"},
+		IPAddresses:  []net.IP{net.ParseIP("10.0.0.7")},
+		URIs:         []*url.URL{spiffe},
+		NotBefore:    notBefore,
+		NotAfter:     notBefore.Add(24 * time.Hour),
+	}
+	der, err := x509.CreateCertificate(rand.Reader, tmpl, tmpl, &key.PublicKey, key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	cert, err := x509.ParseCertificate(der)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	h := testHandler()
+	get := func(state *tls.ConnectionState) clientCertReport {
+		t.Helper()
+		req := httptest.NewRequest(http.MethodGet, "/tls/client-certificate", nil)
+		req.TLS = state
+		w := httptest.NewRecorder()
+		h.ClientCertHandler(w, req)
+		if ct := w.Header().Get("Content-Type"); ct != "application/json" {
+			t.Errorf("expected JSON, got %s", ct)
+		}
+		var report clientCertReport
+		if err := json.Unmarshal(w.Body.Bytes(), &report); err != nil {
+			t.Fatal(err)
+		}
+		return report
+	}
+
+	if report := get(nil); report.TLS || report.Presented {
+		t.Errorf("expected plain HTTP to report no TLS, got %+v", report)
+	}
+	if report := get(&tls.ConnectionState{}); !report.TLS || report.Presented || report.Verified {
+		t.Errorf("expected no client certificate, got %+v", report)
+	}
+
+	report := get(&tls.ConnectionState{PeerCertificates: []*x509.Certificate{cert}})
+	if !report.Presented || report.Verified || len(report.Certificates) != 1 {
+		t.Fatalf("expected one unverified certificate, got %+v", report)
+	}
+	c := report.Certificates[0]
+	sum := sha256.Sum256(der)
+	if c.Subject != "CN=api,O=Mesh" || c.Issuer != "CN=api,O=Mesh" || c.SerialNumber != "1f2e" ||
+		!c.NotBefore.Equal(notBefore) || !c.NotAfter.Equal(notBefore.Add(24*time.Hour)) ||
+		!slices.Equal(c.DNSNames, []string{"api.mesh.example.com"}) || !slices.Equal(c.IPAddresses, []string{"10.0.0.7"}) ||
+		!slices.Equal(c.URIs, []string{spiffe.String()}) || c.SHA256 != hex.EncodeToString(sum[:]) ||
+		c.SignatureAlgorithm != "ECDSA-SHA256" || c.PublicKeyAlgorithm != "ECDSA" {
+		t.Errorf("unexpected certificate %+v", c)
+	}
+
+	report = get(&tls.ConnectionState{PeerCertificates: []*x509.Certificate{cert}, VerifiedChains: [][]*x509.Certificate{{cert}}})
+	if !report.Verified {
+		t.Error("expected the certificate to be reported as verified")
+	}
+}
@@ -0,0 +1,33 @@
+package web
+
+import (
+	"context"
+)
+
+type connInfoKey struct{}
+
+// ConnInfo describes the listener a connection was accepted on. The server
+// attaches it to every connection through its ConnContext hook so handlers
+// can tell connections apart where RemoteAddr alone is not enough.
+type ConnInfo struct {
+	// Listener is the configured name of the listener.
+	Listener string
+	// Unix is true for connections accepted on a unix domain socket.
+	Unix bool
+	// Hello is what the client offered in its TLS handshake. It is set
+	// during the handshake, before any request is read, on listeners that
+	// capture it.
+	Hello *ClientHello
+}
+
+// WithConnInfo returns a copy of ctx carrying info.
+func WithConnInfo(ctx context.Context, info *ConnInfo) context.Context {
+	return context.WithValue(ctx, connInfoKey{}, info)
+}
+
+// ConnInfoFromContext returns the ConnInfo stored in ctx, or nil if there is
+// none.
+func ConnInfoFromContext(ctx context.Context) *ConnInfo {
+	info, _ := ctx.Value(connInfoKey{}).(*ConnInfo)
+	return info
+}
@@ -0,0 +1,201 @@
+package web
+
+import (
+	"crypto/md5"
+	"crypto/sha256"
+	"crypto/tls"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"slices"
+	"strconv"
+	"strings"
+
+	"github.com/tuggan/goip/logger"
+)
+
+// TLS extensions the fingerprints treat specially.
+const (
+	extServerName        = 0
+	extALPN              = 16
+	extSupportedVersions = 43
+)
+
+// Fingerprint holds the JA3 and JA4 fingerprints of a ClientHello, which
+// identify the TLS library of a client independent of its address.
+type Fingerprint struct {
+	// JA3 is the JA3 string and JA3Hash its MD5 sum.
+	JA3     string
+	JA3Hash string
+	// JA4 is the JA4 fingerprint and JA4Raw its unhashed form, JA4_r.
+	JA4    string
+	JA4Raw string
+}
+
+// newFingerprint computes the fingerprints of hello. GREASE values are
+// left out of both, as clients pick them at random.
+func newFingerprint(hello *ClientHello) Fingerprint {
+	ciphers := withoutGREASE(hello.CipherSuites)
+	exts := withoutGREASE(hello.Extensions)
+	curves := withoutGREASE(hello.Curves)
+	versions := withoutGREASE(hello.Versions)
+	var maxVersion uint16
+	if len(versions) > 0 {
+		maxVersion = slices.Max(versions)
+	}
+
+	// The legacy version of the record is not available. Clients sending
+	// supported_versions have to set it to TLS 1.2, for the others Go
+	// derives the supported versions from it.
+	legacyVersion := maxVersion
+	if slices.Contains(exts, extSupportedVersions) {
+		legacyVersion = tls.VersionTLS12
+	}
+	ja3 := strings.Join([]string{
+		strconv.Itoa(int(legacyVersion)),
+		joinValues(ciphers, "-", decimal),
+		joinValues(exts, "-", decimal),
+		joinValues(curves, "-", decimal),
+		joinValues(hello.PointFormats, "-", decimal),
+	}, ",")
+	ja3Sum := md5.Sum([]byte(ja3))
+
+	sni := "i"
+	if slices.Contains(exts, extServerName) {
+		sni = "d"
+	}
+	a := fmt.Sprintf("t%s%s%02d%02d%s", ja4Version(maxVersion), sni,
+		min(len(ciphers), 99), min(len(exts), 99), ja4ALPN(hello.ALPN))
+
+	slices.Sort(ciphers)
+	b := joinValues(ciphers, ",", hex4)
+	sorted := slices.DeleteFunc(slices.Clone(exts), func(e uint16) bool {
+		return e == extServerName || e == extALPN
+	})
+	slices.Sort(sorted)
+	c := joinValues(sorted, ",", hex4)
+	if len(hello.SignatureSchemes) > 0 {
+		c += "_" + joinValues(hello.SignatureSchemes, ",", hex4)
+	}
+
+	return Fingerprint{
+		JA3:     ja3,
+		JA3Hash: hex.EncodeToString(ja3Sum[:]),
+		JA4:     a + "_" + ja4Hash(b) + "_" + ja4Hash(c),
+		JA4Raw:  a + "_" + b + "_" + c,
+	}
+}
+
+// withoutGREASE returns a copy of vs without GREASE values.
+func withoutGREASE[T ~uint16](vs []T) []T {
+	return slices.DeleteFunc(slices.Clone(vs), func(v T) bool { return isGREASE(uint16(v)) })
+}
+
+func decimal[T ~uint8 | ~uint16](v T) string { return strconv.Itoa(int(v)) }
+
+func hex4[T ~uint16](v T) string { return fmt.Sprintf("%04x", uint16(v)) }
+
+func joinValues[T any](vs []T, sep string, format func(T) string) string {
+	s := make([]string, len(vs))
+	for i, v := range vs {
+		s[i] = format(v)
+	}
+	return strings.Join(s, sep)
+}
+
+// ja4Version returns the two character JA4 name of a TLS version.
+func ja4Version(v uint16) string {
+	switch v {
+	case tls.VersionTLS13:
+		return "13"
+	case tls.VersionTLS12:
+		return "12"
+	case tls.VersionTLS11:
+		return "11"
+	case tls.VersionTLS10:
+		return "10"
+	case 0x0300:
+		return "s3"
+	}
+	return "00"
+}
+
+// ja4ALPN returns the first and last character of the first ALPN
+// protocol, or of its hex form when either is not alphanumeric.
+func ja4ALPN(protos []string) string {
+	if len(protos) == 0 || protos[0] == "" {
+		return "00"
+	}
+	p := protos[0]
+	first, last := p[0], p[len(p)-1]
+	if !isAlphanumeric(first) || !isAlphanumeric(last) {
+		h := hex.EncodeToString([]byte(p))
+		return h[:1] + h[len(h)-1:]
+	}
+	return string([]byte{first, last})
+}
+
+func isAlphanumeric(c byte) bool {
+	return '0' <= c && c <= '9' || 'A' <= c && c <= 'Z' || 'a' <= c && c <= 'z'
+}
+
+// ja4Hash returns the first 12 hex characters of the SHA-256 sum of s, or
+// zeros when s is empty.
+func ja4Hash(s string) string {
+	if s == "" {
+		return "000000000000"
+	}
+	sum := sha256.Sum256([]byte(s))
+	return hex.EncodeToString(sum[:6])
+}
+
+// RequestFingerprint returns the fingerprints of the TLS connection r came
+// in on, or nil when r came over plain HTTP or its ClientHello was not
+// captured.
+func RequestFingerprint(r *http.Request) *Fingerprint {
+	if info := ConnInfoFromContext(r.Context()); info != nil && info.Hello != nil {
+		return &info.Hello.Fingerprint
+	}
+	return nil
+}
+
+// fingerprintReport is the JSON served on /tls/fingerprint.
+type fingerprintReport struct {
+	TLS     bool   `json:"tls"`
+	JA3     string `json:"ja3,omitempty"`
+	JA3Hash string `json:"ja3Hash,omitempty"`
+	JA4     string `json:"ja4,omitempty"`
+	JA4Raw  string `json:"ja4Raw,omitempty"`
+}
+
+// FingerprintHandler reports the JA3 and JA4 fingerprints of the client
+// as JSON. Plain HTTP requests get "tls": false.
+func (h handler) FingerprintHandler(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Server", h.server)
+	w.Header().Set("Content-Type", "application/json")
+	report := fingerprintReport{TLS: r.TLS != nil}
+	if fp := RequestFingerprint(r); fp != nil {
+		report.JA3 = fp.JA3
+		report.JA3Hash = fp.JA3Hash
+		report.JA4 = fp.JA4
+		report.JA4Raw = fp.JA4Raw
+	}
+	enc := json.NewEncoder(w)
+	enc.SetIndent("", "  ")
+	if err := enc.Encode(report); err != nil {
+		logger.Error("failed to write TLS fingerprint: %s", err)
+		return
+	}
+	logger.Access(r, http.StatusOK)
+}
+
+// AccessLogFields returns the fingerprints of r for the access log, or ""
+// for requests without them.
+func AccessLogFields(r *http.Request) string {
+	fp := RequestFingerprint(r)
+	if fp == nil {
+		return ""
+	}
+	return "ja3=" + fp.JA3Hash + " ja4=" + fp.JA4
+}
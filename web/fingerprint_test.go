@@ -0,0 +1,107 @@
+package web
+
+import (
+	"crypto/tls"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestNewFingerprint_JA3(t *testing.T) {
+	// The example from the JA3 README.
+	fp := newFingerprint(&ClientHello{
+		Versions:     []uint16{tls.VersionTLS10},
+		CipherSuites: []uint16{47, 53, 5, 10, 49161, 49162, 49171, 49172, 50, 56, 19, 4},
+		Extensions:   []uint16{0, 10, 11},
+		Curves:       []tls.CurveID{23, 24, 25},
+		PointFormats: []uint8{0},
+	})
+	if want := "769,47-53-5-10-49161-49162-49171-49172-50-56-19-4,0-10-11,23-24-25,0"; fp.JA3 != want {
+		t.Errorf("expected JA3 %s, got %s", want, fp.JA3)
+	}
+	if want := "ada70206e40642a3e4461f35503241d5"; fp.JA3Hash != want {
+		t.Errorf("expected JA3 hash %s, got %s", want, fp.JA3Hash)
+	}
+}
+
+func TestNewFingerprint_JA4(t *testing.T) {
+	// The Chrome example from the JA4 specification, with GREASE values
+	// added the way Chrome sends them.
+	hello := &ClientHello{
+		ServerName: "example.com",
+		Versions:   []uint16{0x2a2a, tls.VersionTLS13, tls.VersionTLS12},
+		CipherSuites: []uint16{0x2a2a, 0x1301, 0x1302, 0x1303, 0xc02b, 0xc02f, 0xc02c, 0xc030, 0xcca9,
+			0xcca8, 0xc013, 0xc014, 0x009c, 0x009d, 0x002f, 0x0035},
+		Extensions: []uint16{0x4a4a, 0x0000, 0x0017, 0xff01, 0x000a, 0x000b, 0x0023, 0x0010, 0x0005,
+			0x000d, 0x0012, 0x0033, 0x002d, 0x002b, 0x001b, 0x4469, 0x0015, 0x5a5a},
+		Curves:           []tls.CurveID{0x4a4a, tls.X25519, tls.CurveP256, tls.CurveP384},
+		PointFormats:     []uint8{0},
+		SignatureSchemes: []tls.SignatureScheme{0x0403, 0x0804, 0x0401, 0x0503, 0x0805, 0x0501, 0x0806, 0x0601},
+		ALPN:             []string{"h2", "http/1.1"},
+	}
+	fp := newFingerprint(hello)
+	if want := "t13d1516h2_8daaf6152771_e5627efa2ab1"; fp.JA4 != want {
+		t.Errorf("expected JA4 %s, got %s", want, fp.JA4)
+	}
+	wantRaw := "t13d1516h2_002f,0035,009c,009d,1301,1302,1303,c013,c014,c02b,c02c,c02f,c030,cca8,cca9_" +
+		"0005,000a,000b,000d,0012,0015,0017,001b,0023,002b,002d,0033,4469,ff01_0403,0804,0401,0503,0805,0501,0806,0601"
+	if fp.JA4Raw != wantRaw {
+		t.Errorf("expected JA4_r %s, got %s", wantRaw, fp.JA4Raw)
+	}
+	if want := "771,4865-4866-4867-49195-49199-49196-49200-52393-52392-49171-49172-156-157-47-53," +
+		"0-23-65281-10-11-35-16-5-13-18-51-45-43-27-17513-21,29-23-24,0"; fp.JA3 != want {
+		t.Errorf("expected JA3 %s, got %s", want, fp.JA3)
+	}
+	if hello.CipherSuites[0] != 0x2a2a {
+		t.Error("expected the ClientHello to be left unchanged")
+	}
+}
+
+func TestNewFingerprint_JA4Edges(t *testing.T) {
+	for _, tc := range []struct {
+		hello ClientHello
+		want  string
+	}{
+		{ClientHello{}, "t00i000000_000000000000_000000000000"},
+		{ClientHello{Versions: []uint16{tls.VersionTLS12}, ALPN: []string{"h"}}, "t12i0000hh_000000000000_000000000000"},
+		{ClientHello{Versions: []uint16{tls.VersionTLS11}, ALPN: []string{"\xabx\xcd"}}, "t11i0000ad_000000000000_000000000000"},
+	} {
+		if got := newFingerprint(&tc.hello).JA4; got != tc.want {
+			t.Errorf("%+v: expected JA4 %s, got %s", tc.hello, tc.want, got)
+		}
+	}
+}
+
+func TestFingerprintHandler(t *testing.T) {
+	h := testHandler()
+	hello := &ClientHello{Versions: []uint16{tls.VersionTLS12}, CipherSuites: []uint16{0xc02f}, ALPN: []string{"http/1.1"}}
+	hello.Fingerprint = newFingerprint(hello)
+	req := httptest.NewRequest(http.MethodGet, "/tls/fingerprint", nil)
+	req.TLS = &tls.ConnectionState{Version: tls.VersionTLS12}
+	req = req.WithContext(WithConnInfo(req.Context(), &ConnInfo{Listener: "https", Hello: hello}))
+	w := httptest.NewRecorder()
+	h.FingerprintHandler(w, req)
+
+	var report fingerprintReport
+	if err := json.Unmarshal(w.Body.Bytes(), &report); err != nil {
+		t.Fatal(err)
+	}
+	if !report.TLS || report.JA3 != hello.Fingerprint.JA3 || report.JA3Hash != hello.Fingerprint.JA3Hash ||
+		report.JA4 != "t12i0100h1_"+ja4Hash("c02f")+"_000000000000" || report.JA4Raw != "t12i0100h1_c02f_" {
+		t.Errorf("unexpected fingerprint %+v", report)
+	}
+	if got := AccessLogFields(req); got != "ja3="+report.JA3Hash+" ja4="+report.JA4 {
+		t.Errorf("unexpected access log fields %q", got)
+	}
+
+	req = httptest.NewRequest(http.MethodGet, "/tls/fingerprint", nil)
+	w = httptest.NewRecorder()
+	h.FingerprintHandler(w, req)
+	if got := w.Body.String(); got != "{\n  \"tls\": false\n}\n" {
+		t.Errorf("expected plain HTTP to report no TLS, got %q", got)
+	}
+	if got := AccessLogFields(req); got != "" {
+		t.Errorf("expected no access log fields without TLS, got %q", got)
+	}
+}
@@ -0,0 +1,56 @@
+package web
+
+import (
+	"errors"
+	"io/fs"
+	"slices"
+	"strings"
+)
+
+// overlayFS serves files from upper, falling back to lower for files
+// upper does not have.
+type overlayFS struct {
+	upper fs.FS
+	lower fs.FS
+}
+
+// NewOverlayFS returns a file system where every file in upper replaces the
+// file with the same name in lower. It is used to let a template directory
+// on disk override individual files of the templates built into the binary.
+func NewOverlayFS(upper, lower fs.FS) fs.FS {
+	return overlayFS{upper: upper, lower: lower}
+}
+
+// Open opens name from upper, or from lower if upper does not have it.
+func (o overlayFS) Open(name string) (fs.File, error) {
+	f, err := o.upper.Open(name)
+	if err == nil || !errors.Is(err, fs.ErrNotExist) {
+		return f, err
+	}
+	return o.lower.Open(name)
+}
+
+// ReadDir lists the directory name of both layers, so a directory in upper
+// adds to the files of the same directory in lower instead of hiding them.
+// Entries of upper replace those of lower with the same name.
+func (o overlayFS) ReadDir(name string) ([]fs.DirEntry, error) {
+	upper, uerr := fs.ReadDir(o.upper, name)
+	if uerr != nil && !errors.Is(uerr, fs.ErrNotExist) {
+		return nil, uerr
+	}
+	lower, lerr := fs.ReadDir(o.lower, name)
+	if lerr != nil && !errors.Is(lerr, fs.ErrNotExist) {
+		return nil, lerr
+	}
+	if uerr != nil && lerr != nil {
+		return nil, uerr
+	}
+	entries := slices.Clone(upper)
+	for _, e := range lower {
+		if !slices.ContainsFunc(upper, func(u fs.DirEntry) bool { return u.Name() == e.Name() }) {
+			entries = append(entries, e)
+		}
+	}
+	slices.SortFunc(entries, func(a, b fs.DirEntry) int { return strings.Compare(a.Name(), b.Name()) })
+	return entries, nil
+}
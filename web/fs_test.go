@@ -0,0 +1,82 @@
+package web
+
+import (
+	"errors"
+	"io/fs"
+	"net/http"
+	"net/http/httptest"
+	"slices"
+	"strings"
+	"testing"
+	"testing/fstest"
+)
+
+func TestOverlayFS(t *testing.T) {
+	upper := fstest.MapFS{"robots.txt": {Data: []byte("upper")}}
+	lower := fstest.MapFS{
+		"robots.txt":  {Data: []byte("lower")},
+		"favicon.ico": {Data: []byte("icon")},
+	}
+	o := NewOverlayFS(upper, lower)
+
+	for name, want := range map[string]string{"robots.txt": "upper", "favicon.ico": "icon"} {
+		b, err := fs.ReadFile(o, name)
+		if err != nil {
+			t.Fatalf("reading %s: %v", name, err)
+		}
+		if string(b) != want {
+			t.Errorf("%s: expected %q, got %q", name, want, b)
+		}
+	}
+	if _, err := o.Open("missing"); !errors.Is(err, fs.ErrNotExist) {
+		t.Errorf("expected ErrNotExist for a file in neither, got %v", err)
+	}
+	if _, err := o.Open("../robots.txt"); err == nil {
+		t.Error("expected an invalid path to fail")
+	}
+}
+
+func TestOverlayFS_ReadDir(t *testing.T) {
+	upper := fstest.MapFS{
+		"locales/de.json": {Data: []byte("upper")},
+		"locales/en.json": {Data: []byte("upper")},
+	}
+	lower := fstest.MapFS{
+		"locales/en.json": {Data: []byte("lower")},
+		"locales/sv.json": {Data: []byte("lower")},
+		"index.html":      {Data: []byte("lower")},
+	}
+	o := NewOverlayFS(upper, lower)
+
+	entries, err := fs.ReadDir(o, "locales")
+	if err != nil {
+		t.Fatal(err)
+	}
+	var names []string
+	for _, e := range entries {
+		names = append(names, e.Name())
+	}
+	if want := []string{"de.json", "en.json", "sv.json"}; !slices.Equal(names, want) {
+		t.Errorf("expected the listings of both layers %v, got %v", want, names)
+	}
+	if b, _ := fs.ReadFile(o, "locales/en.json"); string(b) != "upper" {
+		t.Errorf("expected en.json from upper, got %q", b)
+	}
+	if entries, err := fs.ReadDir(o, "."); err != nil || len(entries) != 2 {
+		t.Errorf("expected the root of lower to show through, got %v %v", entries, err)
+	}
+	if _, err := fs.ReadDir(o, "missing"); !errors.Is(err, fs.ErrNotExist) {
+		t.Errorf("expected ErrNotExist for a directory in neither, got %v", err)
+	}
+}
+
+func TestHandler_SetFS(t *testing.T) {
+	h := NewHandler(false, t.TempDir(), "v", "b", "d", "a", "e", nil)
+	h.SetFS(fstest.MapFS{"static/robots.txt": {Data: []byte("User-agent: *\n")}})
+
+	w := httptest.NewRecorder()
+	h.StaticHandler(w, httptest.NewRequest(http.MethodGet, "/robots.txt", nil))
+	if w.Code != http.StatusOK || !strings.Contains(w.Body.String(), "User-agent:") {
+		t.Errorf("expected robots.txt from the set file system, got %d %q", w.Code, w.Body.String())
+	}
+}
@@ -2,19 +2,23 @@ package web
 
 import (
 	"compress/gzip"
+	"encoding/json"
 	"fmt"
 	"html"
 	"io"
+	"io/fs"
+	"maps"
 	"net"
 	"net/http"
 	"os"
 	"path"
-	"path/filepath"
 	"strconv"
 	"strings"
-	"html/template"
+	"sync/atomic"
+	"time"
 
 	"github.com/tuggan/goip/logger"
+	"github.com/tuggan/goip/tracing"
 )
 
 type head struct {
@@ -35,11 +39,16 @@ type page struct {
 	CommitDate string
 	Author     string
 	Email      string
+	Site       Site
+	Lang       string
 }
 
 type handler struct {
 	gzipEnabled   bool
-	templateDir   string
+	themes        *Themes
+	staticDir     string
+	staticMaxAge  time.Duration
+	etags         *etagCache
 	version       string
 	branch        string
 	date          string
@@ -47,18 +56,23 @@ type handler struct {
 	email         string
 	server        string
 	trustedIPNets []*net.IPNet
+	trustUnix     bool
+	draining      *atomic.Bool
 }
 
 func NewHandler(gzipEnabled bool, templateDir, version, branch, date, author, email string, trustedProxies []string) handler {
 	h := handler{
-		gzipEnabled: gzipEnabled,
-		templateDir: templateDir,
-		version:     version,
-		branch:      branch,
-		date:        date,
-		author:      author,
-		email:       email,
-		server:      fmt.Sprintf("GoIP %s", version),
+		gzipEnabled:  gzipEnabled,
+		themes:       NewThemes(os.DirFS(templateDir)),
+		staticDir:    "static",
+		staticMaxAge: time.Hour,
+		etags:        newETagCache(),
+		version:      version,
+		branch:       branch,
+		date:         date,
+		author:       author,
+		email:        email,
+		server:       fmt.Sprintf("GoIP %s", version),
 	}
 
 	for _, p := range trustedProxies {
@@ -66,6 +80,12 @@ func NewHandler(gzipEnabled bool, templateDir, version, branch, date, author, em
 		if p == "" {
 			continue
 		}
+		// "unix" trusts every peer connecting over a unix domain socket,
+		// typically a reverse proxy running on the same host.
+		if p == "unix" {
+			h.trustUnix = true
+			continue
+		}
 		// Try as CIDR notation first (e.g. "10.0.0.0/8", "192.168.1.0/24")
 		_, cidr, err := net.ParseCIDR(p)
 		if err == nil {
@@ -89,6 +109,60 @@ func NewHandler(gzipEnabled bool, templateDir, version, branch, date, author, em
 	return h
 }
 
+// SetDrainFlag makes HealthHandler fail while draining is true, so load
+// balancers stop sending new requests before the server shuts down. It must
+// be called before Routes.
+func (h *handler) SetDrainFlag(draining *atomic.Bool) {
+	h.draining = draining
+}
+
+// SetFS makes the handler read its templates and files from fsys instead
+// of templateDir. It must be called before Routes.
+func (h *handler) SetFS(fsys fs.FS) {
+	h.themes = NewThemes(fsys)
+}
+
+// SetThemes makes the handler pick the templates and files of a request
+// from t, which is typically shared by every handler. It replaces SetFS
+// and must be called before Routes.
+func (h *handler) SetThemes(t *Themes) {
+	h.themes = t
+}
+
+// SetStatic sets the directory of the static tree within the template file
+// system, "static" by default, and how long clients may cache the files. It
+// must be called before Routes.
+func (h *handler) SetStatic(dir string, maxAge time.Duration) error {
+	dir = path.Clean(dir)
+	if !fs.ValidPath(dir) {
+		return fmt.Errorf("static directory %q must be a relative path within the template directory", dir)
+	}
+	if maxAge < 0 {
+		return fmt.Errorf("static max age %s is negative", maxAge)
+	}
+	h.staticDir = dir
+	h.staticMaxAge = maxAge
+	return nil
+}
+
+// Routes returns every route the handler can serve, keyed by the pattern
+// it should be registered under on an http.ServeMux.
+func (h handler) Routes() map[string]http.HandlerFunc {
+	routes := map[string]http.HandlerFunc{
+		"/":                       h.MainHandler,
+		"/GET":                    h.GETHandler,
+		"/static/":                h.StaticHandler,
+		"/health":                 h.HealthHandler,
+		"/tls":                    h.TLSHandler,
+		"/tls/fingerprint":        h.FingerprintHandler,
+		"/tls/client-certificate": h.ClientCertHandler,
+	}
+	for _, p := range staticRootFiles {
+		routes[p] = h.StaticHandler
+	}
+	return routes
+}
+
 // isTrustedProxy checks whether the remote address (host:port) matches
 // any of the configured trusted proxy IPs or CIDR ranges.
 func (h handler) isTrustedProxy(remoteAddr string) bool {
@@ -111,57 +185,94 @@ func (h handler) isTrustedProxy(remoteAddr string) bool {
 	return false
 }
 
-func (h handler) MainHandler(w http.ResponseWriter, r *http.Request) {
+// TrustedPeer reports whether r was sent by a trusted proxy, whose
+// forwarding headers may be believed.
+func (h handler) TrustedPeer(r *http.Request) bool {
+	if info := ConnInfoFromContext(r.Context()); info != nil && info.Unix {
+		return h.trustUnix
+	}
+	return h.isTrustedProxy(r.RemoteAddr)
+}
 
-	ip, _, e := net.SplitHostPort(r.RemoteAddr)
-	if e != nil {
-		h.renderError(w, r, path.Join(h.templateDir, "error"), "Error while parsing host and port", http.StatusInternalServerError)
-		logger.Error("[%d] error while parsing host and port %s", http.StatusInternalServerError, r.URL.Path)
-		return
+// ClientIP returns the IP address of the client that sent r. Peers on a
+// unix domain socket have no address and are reported as "unix" unless
+// they are trusted and forward the client address.
+func (h handler) ClientIP(r *http.Request) (string, error) {
+	var ip string
+	if info := ConnInfoFromContext(r.Context()); info != nil && info.Unix {
+		ip = "unix"
+	} else {
+		host, _, err := net.SplitHostPort(r.RemoteAddr)
+		if err != nil {
+			return "", err
+		}
+		ip = host
 	}
+	trusted := h.TrustedPeer(r)
 
 	// Only trust X-Forwarded-For when the connection comes from a
 	// configured trusted proxy. This prevents direct clients from
 	// spoofing their IP address via the header.
 	// When multiple proxies are chained, the leftmost IP is the client.
-	if xff := r.Header.Get("X-Forwarded-For"); xff != "" && h.isTrustedProxy(r.RemoteAddr) {
+	if xff := r.Header.Get("X-Forwarded-For"); xff != "" && trusted {
 		if idx := strings.Index(xff, ","); idx != -1 {
 			ip = strings.TrimSpace(xff[:idx])
 		} else {
 			ip = strings.TrimSpace(xff)
 		}
 	}
+	return ip, nil
+}
+
+// rawEndpoints maps the paths MainHandler answers with a single value as
+// plain text to the function returning that value for a request from ip.
+var rawEndpoints = map[string]func(r *http.Request, ip string) string{
+	"/ip":              func(r *http.Request, ip string) string { return ip },
+	"/user-agent":      requestHeader("User-Agent"),
+	"/host":            func(r *http.Request, ip string) string { return r.Host },
+	"/proto":           func(r *http.Request, ip string) string { return r.Proto },
+	"/accept":          requestHeader("Accept"),
+	"/accept-encoding": requestHeader("Accept-Encoding"),
+	"/accept-language": requestHeader("Accept-Language"),
+	"/method":          func(r *http.Request, ip string) string { return r.Method },
+	"/content-type":    requestHeader("Content-Type"),
+	"/origin":          requestHeader("Origin"),
+	"/referer":         requestHeader("Referer"),
+	"/x-forwarded-for": requestHeader("X-Forwarded-For"),
+}
+
+func requestHeader(name string) func(*http.Request, string) string {
+	return func(r *http.Request, _ string) string { return r.Header.Get(name) }
+}
+
+// IsRawEndpoint reports whether MainHandler answers path with a single
+// plain text value, the endpoints scripts use.
+func IsRawEndpoint(path string) bool {
+	_, ok := rawEndpoints[strings.ToLower(path)]
+	return ok
+}
+
+func (h handler) MainHandler(w http.ResponseWriter, r *http.Request) {
+
+	ip, e := h.ClientIP(r)
+	if e != nil {
+		h.renderError(w, r, "error", http.StatusInternalServerError, "error.hostPort")
+		logger.Error("[%d] error while parsing host and port %s", http.StatusInternalServerError, r.URL.Path)
+		return
+	}
 
 	w.Header().Set("Server", h.server)
 	w.Header().Set("Content-Type", "text/plain; charset=utf-8")
 
 	s := strings.ToLower(r.URL.Path)
 
+	if value, ok := rawEndpoints[s]; ok {
+		io.WriteString(w, value(r, ip))
+		logger.Access(r, http.StatusOK)
+		return
+	}
+
 	switch s {
-	case "/ip":
-		io.WriteString(w, ip)
-	case "/user-agent":
-		io.WriteString(w, r.Header.Get("User-Agent"))
-	case "/host":
-		io.WriteString(w, r.Host)
-	case "/proto":
-		io.WriteString(w, r.Proto)
-	case "/accept":
-		io.WriteString(w, r.Header.Get("Accept"))
-	case "/accept-encoding":
-		io.WriteString(w, r.Header.Get("Accept-Encoding"))
-	case "/accept-language":
-		io.WriteString(w, r.Header.Get("Accept-Language"))
-	case "/method":
-		io.WriteString(w, r.Method)
-	case "/content-type":
-		io.WriteString(w, r.Header.Get("Content-Type"))
-	case "/origin":
-		io.WriteString(w, r.Header.Get("Origin"))
-	case "/referer":
-		io.WriteString(w, r.Header.Get("Referer"))
-	case "/x-forwarded-for":
-		io.WriteString(w, r.Header.Get("X-Forwarded-For"))
 	case "/":
 		info := []head{
 			{"Ip", ip},
@@ -177,8 +288,29 @@ func (h handler) MainHandler(w http.ResponseWriter, r *http.Request) {
 			{"Referer", r.Header.Get("Referer")},
 			{"X-Forwarded-For", r.Header.Get("X-Forwarded-For")},
 		}
+		switch VHostFromContext(r.Context()).format() {
+		case FormatText:
+			io.WriteString(w, ip+"\n")
+			logger.Access(r, http.StatusOK)
+			return
+		case FormatJSON:
+			fields := make(map[string]string, len(info))
+			for _, hd := range info {
+				fields[strings.ToLower(hd.Key)] = hd.Val
+			}
+			maps.Copy(fields, tlsFields(r))
+			w.Header().Set("Content-Type", "application/json")
+			json.NewEncoder(w).Encode(fields)
+			logger.Access(r, http.StatusOK)
+			return
+		}
+		theme := h.theme(r)
+		title := "IPConf"
+		if theme.Site.Name != "" {
+			title = theme.Site.Name
+		}
 		data := page{
-			Title:      "IPConf",
+			Title:      title,
 			Clientinfo: info,
 			IP:         ip,
 			Hostname:   r.Host,
@@ -187,45 +319,53 @@ func (h handler) MainHandler(w http.ResponseWriter, r *http.Request) {
 			CommitDate: h.date,
 			Author:     h.author,
 			Email:      h.email,
+			Site:       theme.Site,
 		}
-		h.renderTemplate(w, r, path.Join(h.templateDir, "index"), data)
+		h.renderTemplate(w, r, "index", data)
 	default:
-		h.renderError(w, r, path.Join(h.templateDir, "error"), fmt.Sprintf("%s not found", r.URL.Path), http.StatusNotFound)
+		h.renderError(w, r, "error", http.StatusNotFound, "error.notFound", r.URL.Path)
 		logger.Access(r, http.StatusNotFound)
 		return
 	}
 	logger.Access(r, http.StatusOK)
 }
 
-// safeTemplatePath validates that the given path stays within the configured
-// template directory to prevent directory traversal attacks.
-func (h handler) safeTemplatePath(fullPath string) (string, error) {
-	absPath, err := filepath.Abs(fullPath)
-	if err != nil {
-		return "", err
-	}
-	absDir, err := filepath.Abs(h.templateDir)
-	if err != nil {
-		return "", err
-	}
-	if !strings.HasPrefix(absPath, absDir) {
-		return "", fmt.Errorf("template path %q escapes template directory %q", absPath, absDir)
+// theme returns the theme of r, which is the one of its virtual host if
+// that sets one.
+func (h handler) theme(r *http.Request) *Theme {
+	if vh := VHostFromContext(r.Context()); vh != nil && vh.Theme != "" {
+		if t := h.themes.Named(vh.Theme); t != nil {
+			return t
+		}
 	}
-	return fullPath, nil
+	return h.themes.ForHost(r.Host)
 }
 
+// NotFoundHandler renders the error page for a route that does not exist.
+func (h handler) NotFoundHandler(w http.ResponseWriter, r *http.Request) {
+	h.renderError(w, r, "error", http.StatusNotFound, "error.notFound", r.URL.Path)
+	logger.Access(r, http.StatusNotFound)
+}
+
+// language returns the language pages for r are rendered in, and the
+// templates of its theme.
+func (h handler) language(r *http.Request) (string, *Templates) {
+	tmpls := h.theme(r).templates
+	return negotiateLanguage(r, tmpls.HasLanguage), tmpls
+}
+
+// renderTemplate renders the template tmpl, e.g. "index", with the data m
+// in the language negotiated for r.
 func (h handler) renderTemplate(w http.ResponseWriter, r *http.Request, tmpl string, m page) {
-	safeTmpl, err := h.safeTemplatePath(tmpl)
-	if err != nil {
-		logger.Error("Template path validation failed: %v", err)
-		w.Header().Set("Content-Type", "text/plain; charset=utf-8")
-		w.WriteHeader(http.StatusInternalServerError)
-		fmt.Fprintf(w, "500 Internal Server Error")
-		return
-	}
+	_, span := tracing.Start(r.Context(), "renderTemplate", tracing.String("goip.template", tmpl))
+	defer span.End()
 	var tw io.Writer = w
-	t, err := template.ParseFiles(safeTmpl + ".html")
+	lang, tmpls := h.language(r)
+	m.Lang = lang
+	w.Header().Add("Vary", "Accept-Language")
+	t, err := tmpls.Lookup(tmpl, lang)
 	if err != nil {
+		span.SetStatus(tracing.StatusError, err.Error())
 		logger.Error("Failed to parse template %s: %v", tmpl, err)
 		w.Header().Set("Content-Type", "text/plain; charset=utf-8")
 		w.WriteHeader(http.StatusInternalServerError)
@@ -242,28 +382,38 @@ func (h handler) renderTemplate(w http.ResponseWriter, r *http.Request, tmpl str
 	t.Execute(tw, m)
 }
 
-func (h handler) renderError(w http.ResponseWriter, r *http.Request, tmpl string, s string, code int) {
-	safeTmpl, err := h.safeTemplatePath(tmpl)
-	if err != nil {
-		logger.Error("Template path validation failed: %v", err)
-		w.Header().Set("Content-Type", "text/plain; charset=utf-8")
-		w.WriteHeader(http.StatusInternalServerError)
-		fmt.Fprintf(w, "500 Internal Server Error")
-		return
-	}
+// renderError renders the template tmpl with status code and the message
+// key, formatted with args, in the language negotiated for r. A plain error
+// page is written if the template cannot be parsed.
+func (h handler) renderError(w http.ResponseWriter, r *http.Request, tmpl string, code int, key string, args ...any) {
+	_, span := tracing.Start(r.Context(), "renderError",
+		tracing.String("goip.template", tmpl), tracing.Int("http.response.status_code", code))
+	defer span.End()
 	var tw io.Writer = w
+	lang, tmpls := h.language(r)
+	tr := tmpls.Translator(lang)
+	status := "status." + strconv.Itoa(code)
+	text := tr(status)
+	if text == status {
+		text = http.StatusText(code)
+	}
+	s := tr(key, args...)
 	p := page{
-		Title:   fmt.Sprintf("%d: %s", code, http.StatusText(code)),
-		Header:  http.StatusText(code),
+		Title:   fmt.Sprintf("%d: %s", code, text),
+		Header:  text,
 		Message: s,
 		Code:    strconv.Itoa(code),
+		Site:    h.theme(r).Site,
+		Lang:    lang,
 	}
-	t, err := template.ParseFiles(safeTmpl + ".html")
+	w.Header().Add("Vary", "Accept-Language")
+	t, err := tmpls.Lookup(tmpl, lang)
 	if err != nil {
+		span.SetStatus(tracing.StatusError, err.Error())
 		logger.Error("Failed to parse error template %s: %v", tmpl, err)
 		w.Header().Set("Content-Type", "text/html; charset=utf-8")
 		w.WriteHeader(code)
-		fmt.Fprintf(w, "<h1>%d: %s</h1><p>%s</p>", code, http.StatusText(code), html.EscapeString(s))
+		fmt.Fprintf(w, "<h1>%d: %s</h1><p>%s</p>", code, html.EscapeString(text), html.EscapeString(s))
 		return
 	}
 	w.Header().Set("Content-Type", "text/html; charset=utf-8")
@@ -282,10 +432,23 @@ func (h handler) renderError(w http.ResponseWriter, r *http.Request, tmpl string
 	t.Execute(tw, p)
 }
 
+// CheckTemplates parses the templates rendered by the handlers and their
+// message catalogs so a missing or broken template shows up in readiness
+// checks instead of in failed requests.
+func CheckTemplates(fsys fs.FS) error {
+	for _, name := range templateNames {
+		if _, err := parsePage(fsys, name); err != nil {
+			return err
+		}
+	}
+	_, err := loadCatalogs(fsys)
+	return err
+}
+
 func (h handler) GETHandler(w http.ResponseWriter, r *http.Request) {
 
 	if r.Method != "GET" {
-		h.renderError(w, r, path.Join(h.templateDir, "error"), "method not GET", http.StatusBadRequest)
+		h.renderError(w, r, "error", http.StatusBadRequest, "error.methodNotGet")
 		logger.Error("[Error] [%d] method not GET %s", http.StatusBadRequest, r.URL.Path)
 		return
 	}
@@ -295,44 +458,14 @@ func (h handler) GETHandler(w http.ResponseWriter, r *http.Request) {
 
 }
 
-func (h handler) FaviconHandler(w http.ResponseWriter, r *http.Request) {
-	favPath := path.Join(h.templateDir, "/favicon.ico")
-	if _, err := h.safeTemplatePath(favPath); err != nil {
-		logger.Error("Favicon path validation failed: %v", err)
-		http.Error(w, "Not Found", http.StatusNotFound)
-		return
-	}
-	file, err := os.Open(favPath)
-	if err != nil {
-		h.renderError(w, r, path.Join(h.templateDir, "error"), fmt.Sprintf("Could not find %s", r.URL.Path), http.StatusNotFound)
-		logger.Access(r, http.StatusNotFound)
-		return
-	}
-	defer file.Close()
-	io.Copy(w, file)
-	logger.Access(r, http.StatusOK)
-}
-
-func (h handler) RobotsHandler(w http.ResponseWriter, r *http.Request) {
-	robPath := path.Join(h.templateDir, "/robots.txt")
-	if _, err := h.safeTemplatePath(robPath); err != nil {
-		logger.Error("Robots path validation failed: %v", err)
-		http.Error(w, "Not Found", http.StatusNotFound)
-		return
-	}
-	file, err := os.Open(robPath)
-	if err != nil {
-		h.renderError(w, r, path.Join(h.templateDir, "error"), fmt.Sprintf("Could not find %s", r.URL.Path), http.StatusNotFound)
-		logger.Access(r, http.StatusNotFound)
-		return
-	}
-	defer file.Close()
-	io.Copy(w, file)
-	logger.Access(r, http.StatusOK)
-}
-
 func (h handler) HealthHandler(w http.ResponseWriter, r *http.Request) {
 	w.Header().Set("Content-Type", "text/plain; charset=utf-8")
+	if h.draining != nil && h.draining.Load() {
+		w.WriteHeader(http.StatusServiceUnavailable)
+		io.WriteString(w, "Draining\n")
+		logger.Access(r, http.StatusServiceUnavailable)
+		return
+	}
 	w.WriteHeader(http.StatusOK)
 	io.WriteString(w, "OK\n")
 	logger.Access(r, http.StatusOK)
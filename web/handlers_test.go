@@ -8,6 +8,7 @@ import (
 	"net/http/httptest"
 	"os"
 	"strings"
+	"sync/atomic"
 	"testing"
 
 	"github.com/tuggan/goip/logger"
@@ -306,6 +307,25 @@ func TestMainHandler_XForwardedFor_Raw(t *testing.T) {
 	}
 }
 
+func TestIsRawEndpoint(t *testing.T) {
+	h := testHandler()
+	for path := range rawEndpoints {
+		req := httptest.NewRequest(http.MethodGet, path, nil)
+		req.RemoteAddr = "1.2.3.4:5678"
+		w := httptest.NewRecorder()
+		h.MainHandler(w, req)
+		if w.Code != http.StatusOK || w.Header().Get("Content-Type") != "text/plain; charset=utf-8" {
+			t.Errorf("expected %s to be served as plain text, got %d %q", path, w.Code, w.Header().Get("Content-Type"))
+		}
+	}
+	if !IsRawEndpoint("/IP") {
+		t.Error("expected raw endpoints to match regardless of case")
+	}
+	if IsRawEndpoint("/") || IsRawEndpoint("/json") {
+		t.Error("expected pages not to be raw endpoints")
+	}
+}
+
 func TestMainHandler_NotFound(t *testing.T) {
 	h := testHandler()
 	req := httptest.NewRequest(http.MethodGet, "/nonexistent", nil)
@@ -417,6 +437,53 @@ func TestMainHandler_NoXForwardedFor(t *testing.T) {
 	}
 }
 
+func TestClientIP_UnixSocketUntrusted(t *testing.T) {
+	h := testHandler()
+	req := httptest.NewRequest(http.MethodGet, "/ip", nil)
+	req.RemoteAddr = "@"
+	req.Header.Set("X-Forwarded-For", "203.0.113.50")
+	req = req.WithContext(WithConnInfo(req.Context(), &ConnInfo{Unix: true}))
+
+	ip, err := h.ClientIP(req)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if ip != "unix" {
+		t.Errorf("expected untrusted unix peer to be reported as 'unix', got %q", ip)
+	}
+}
+
+func TestClientIP_UnixSocketTrusted(t *testing.T) {
+	h := NewHandler(false, "../html", "v", "b", "d", "a", "e", []string{"unix"})
+	req := httptest.NewRequest(http.MethodGet, "/ip", nil)
+	req.RemoteAddr = "@"
+	req.Header.Set("X-Forwarded-For", "203.0.113.50, 10.0.0.1")
+	req = req.WithContext(WithConnInfo(req.Context(), &ConnInfo{Unix: true}))
+
+	ip, err := h.ClientIP(req)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if ip != "203.0.113.50" {
+		t.Errorf("expected forwarded client IP, got %q", ip)
+	}
+}
+
+func TestClientIP_UnixTrustDoesNotTrustTCP(t *testing.T) {
+	h := NewHandler(false, "../html", "v", "b", "d", "a", "e", []string{"unix"})
+	req := httptest.NewRequest(http.MethodGet, "/ip", nil)
+	req.RemoteAddr = "198.51.100.1:1234"
+	req.Header.Set("X-Forwarded-For", "203.0.113.50")
+
+	ip, err := h.ClientIP(req)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if ip != "198.51.100.1" {
+		t.Errorf("expected RemoteAddr IP for TCP peer, got %q", ip)
+	}
+}
+
 // ----------------
 // Gzip
 // ----------------
@@ -577,82 +644,6 @@ func TestGETHandler_EmptyQuery(t *testing.T) {
 	}
 }
 
-// ----------------
-// FaviconHandler
-// ----------------
-
-func TestFaviconHandler_Success(t *testing.T) {
-	h := testHandler()
-	req := httptest.NewRequest(http.MethodGet, "/favicon.ico", nil)
-	req.RemoteAddr = "1.2.3.4:5678"
-	w := httptest.NewRecorder()
-
-	h.FaviconHandler(w, req)
-	resp := w.Result()
-	defer resp.Body.Close()
-
-	if resp.StatusCode != http.StatusOK {
-		t.Errorf("expected 200, got %d", resp.StatusCode)
-	}
-	body := mustReadBody(t, resp.Body)
-	if len(body) == 0 {
-		t.Error("expected non-empty favicon body")
-	}
-}
-
-func TestFaviconHandler_Missing(t *testing.T) {
-	h := NewHandler(false, t.TempDir(), "v", "b", "d", "a", "e", nil)
-	req := httptest.NewRequest(http.MethodGet, "/favicon.ico", nil)
-	req.RemoteAddr = "1.2.3.4:5678"
-	w := httptest.NewRecorder()
-
-	h.FaviconHandler(w, req)
-	resp := w.Result()
-	defer resp.Body.Close()
-
-	if resp.StatusCode != http.StatusNotFound {
-		t.Errorf("expected 404 for missing favicon, got %d", resp.StatusCode)
-	}
-}
-
-// ----------------
-// RobotsHandler
-// ----------------
-
-func TestRobotsHandler_Success(t *testing.T) {
-	h := testHandler()
-	req := httptest.NewRequest(http.MethodGet, "/robots.txt", nil)
-	req.RemoteAddr = "1.2.3.4:5678"
-	w := httptest.NewRecorder()
-
-	h.RobotsHandler(w, req)
-	resp := w.Result()
-	defer resp.Body.Close()
-
-	if resp.StatusCode != http.StatusOK {
-		t.Errorf("expected 200, got %d", resp.StatusCode)
-	}
-	body := mustReadBody(t, resp.Body)
-	if !strings.Contains(body, "User-agent:") {
-		t.Errorf("expected robots.txt content, got:\n%s", body)
-	}
-}
-
-func TestRobotsHandler_Missing(t *testing.T) {
-	h := NewHandler(false, t.TempDir(), "v", "b", "d", "a", "e", nil)
-	req := httptest.NewRequest(http.MethodGet, "/robots.txt", nil)
-	req.RemoteAddr = "1.2.3.4:5678"
-	w := httptest.NewRecorder()
-
-	h.RobotsHandler(w, req)
-	resp := w.Result()
-	defer resp.Body.Close()
-
-	if resp.StatusCode != http.StatusNotFound {
-		t.Errorf("expected 404 for missing robots.txt, got %d", resp.StatusCode)
-	}
-}
-
 // ----------------
 // RemoteAddr parsing failure (edge case)
 // ----------------
@@ -713,6 +704,38 @@ func TestHealthHandler_AnyMethod(t *testing.T) {
 	}
 }
 
+func TestHealthHandler_Draining(t *testing.T) {
+	var draining atomic.Bool
+	h := testHandler()
+	h.SetDrainFlag(&draining)
+
+	w := httptest.NewRecorder()
+	h.HealthHandler(w, httptest.NewRequest(http.MethodGet, "/health", nil))
+	if w.Code != http.StatusOK {
+		t.Errorf("expected 200 before draining, got %d", w.Code)
+	}
+
+	draining.Store(true)
+	w = httptest.NewRecorder()
+	h.HealthHandler(w, httptest.NewRequest(http.MethodGet, "/health", nil))
+	if w.Code != http.StatusServiceUnavailable {
+		t.Errorf("expected 503 while draining, got %d", w.Code)
+	}
+}
+
+// ----------------
+// CheckTemplates
+// ----------------
+
+func TestCheckTemplates(t *testing.T) {
+	if err := CheckTemplates(os.DirFS("../html")); err != nil {
+		t.Errorf("expected bundled templates to parse, got %v", err)
+	}
+	if err := CheckTemplates(os.DirFS(t.TempDir())); err == nil {
+		t.Error("expected an empty template directory to fail")
+	}
+}
+
 // ----------------
 // NewHandler defaults
 // ----------------
@@ -724,3 +747,17 @@ func TestNewHandler_SetsServerField(t *testing.T) {
 		t.Errorf("expected server %q, got %q", expected, h.server)
 	}
 }
+
+// ----------------
+// Routes
+// ----------------
+
+func TestRoutes(t *testing.T) {
+	h := testHandler()
+	routes := h.Routes()
+	for _, pattern := range []string{"/", "/GET", "/static/", "/favicon.ico", "/robots.txt", "/health"} {
+		if routes[pattern] == nil {
+			t.Errorf("expected route %q to be registered", pattern)
+		}
+	}
+}
@@ -0,0 +1,138 @@
+package web
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/fs"
+	"net/http"
+	"path"
+	"slices"
+	"strconv"
+	"strings"
+)
+
+const (
+	// localesDir is the directory of the template file system holding a
+	// message catalog per language, e.g. locales/sv.json.
+	localesDir = "locales"
+	// DefaultLanguage is used when the client accepts none of the
+	// languages there are catalogs for, and for messages missing from the
+	// catalog of the negotiated language.
+	DefaultLanguage = "en"
+)
+
+// catalog maps message keys to messages. Messages are fmt format strings.
+type catalog map[string]string
+
+// loadCatalogs reads every catalog in the locales directory of fsys, keyed
+// by language. Having no locales directory is not an error.
+func loadCatalogs(fsys fs.FS) (map[string]catalog, error) {
+	catalogs := make(map[string]catalog)
+	entries, err := fs.ReadDir(fsys, localesDir)
+	if errors.Is(err, fs.ErrNotExist) {
+		return catalogs, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	for _, e := range entries {
+		if e.IsDir() || path.Ext(e.Name()) != ".json" {
+			continue
+		}
+		b, err := fs.ReadFile(fsys, path.Join(localesDir, e.Name()))
+		if err != nil {
+			return nil, err
+		}
+		var c catalog
+		if err := json.Unmarshal(b, &c); err != nil {
+			return nil, fmt.Errorf("%s: %w", path.Join(localesDir, e.Name()), err)
+		}
+		catalogs[strings.ToLower(strings.TrimSuffix(e.Name(), ".json"))] = c
+	}
+	return catalogs, nil
+}
+
+// translator returns the T template function for lang. Messages missing
+// from the catalog of lang are taken from DefaultLanguage, and the bare key
+// is used, without the arguments, when neither has the message.
+func translator(catalogs map[string]catalog, lang string) func(key string, args ...any) string {
+	return func(key string, args ...any) string {
+		msg, ok := catalogs[lang][key]
+		if !ok {
+			if msg, ok = catalogs[DefaultLanguage][key]; !ok {
+				return key
+			}
+		}
+		if len(args) == 0 {
+			return msg
+		}
+		return fmt.Sprintf(msg, args...)
+	}
+}
+
+// acceptedLanguage is a language range of an Accept-Language header.
+type acceptedLanguage struct {
+	tag string
+	q   float64
+}
+
+// parseAcceptLanguage returns the language ranges of an Accept-Language
+// header, most preferred first. Ranges with a quality of zero or an
+// invalid quality are left out.
+func parseAcceptLanguage(header string) []acceptedLanguage {
+	var langs []acceptedLanguage
+	for part := range strings.SplitSeq(header, ",") {
+		tag, params, _ := strings.Cut(part, ";")
+		tag = strings.ToLower(strings.TrimSpace(tag))
+		if tag == "" {
+			continue
+		}
+		q := 1.0
+		if v, ok := strings.CutPrefix(strings.TrimSpace(params), "q="); ok {
+			var err error
+			if q, err = strconv.ParseFloat(v, 64); err != nil || q < 0 || q > 1 {
+				continue
+			}
+		}
+		if q == 0 {
+			continue
+		}
+		langs = append(langs, acceptedLanguage{tag: tag, q: q})
+	}
+	slices.SortStableFunc(langs, func(a, b acceptedLanguage) int {
+		switch {
+		case a.q > b.q:
+			return -1
+		case a.q < b.q:
+			return 1
+		}
+		return 0
+	})
+	return langs
+}
+
+// negotiateLanguage returns the language of r among those has reports a
+// catalog for. The lang query parameter wins over the Accept-Language
+// header, where a range such as "sv-SE" also matches "sv".
+func negotiateLanguage(r *http.Request, has func(string) bool) string {
+	if lang := strings.ToLower(r.URL.Query().Get("lang")); lang != "" && has(lang) {
+		return lang
+	}
+	for _, l := range parseAcceptLanguage(r.Header.Get("Accept-Language")) {
+		if l.tag == "*" {
+			break
+		}
+		for tag := l.tag; tag != ""; {
+			if has(tag) {
+				return tag
+			}
+			i := strings.LastIndex(tag, "-")
+			if i == -1 {
+				break
+			}
+			tag = tag[:i]
+		}
+	}
+	return DefaultLanguage
+}
@@ -0,0 +1,130 @@
+package web
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"slices"
+	"strings"
+	"testing"
+	"testing/fstest"
+)
+
+func TestParseAcceptLanguage(t *testing.T) {
+	var tags []string
+	for _, l := range parseAcceptLanguage("en;q=0.5, sv-SE, de;q=0, fr;q=0.8, nl;q=x, , *;q=0.1") {
+		tags = append(tags, l.tag)
+	}
+	want := []string{"sv-se", "fr", "en", "*"}
+	if !slices.Equal(tags, want) {
+		t.Errorf("expected %v, got %v", want, tags)
+	}
+}
+
+func TestNegotiateLanguage(t *testing.T) {
+	has := func(lang string) bool { return lang == "en" || lang == "sv" || lang == "pt-br" }
+	for _, tc := range []struct {
+		query, header, want string
+	}{
+		{"", "", "en"},
+		{"", "sv", "sv"},
+		{"", "sv-SE,en;q=0.9", "sv"},
+		{"", "de, en;q=0.5, sv;q=0.7", "sv"},
+		{"", "sv;q=0, en", "en"},
+		{"", "pt-BR", "pt-br"},
+		{"", "pt", "en"},
+		{"", "*, sv;q=0.5", "en"},
+		{"sv", "en", "sv"},
+		{"SV", "", "sv"},
+		{"de", "sv", "sv"},
+	} {
+		req := httptest.NewRequest(http.MethodGet, "/?lang="+tc.query, nil)
+		if tc.header != "" {
+			req.Header.Set("Accept-Language", tc.header)
+		}
+		if got := negotiateLanguage(req, has); got != tc.want {
+			t.Errorf("lang=%q Accept-Language %q: expected %q, got %q", tc.query, tc.header, tc.want, got)
+		}
+	}
+}
+
+func i18nTestFS() fstest.MapFS {
+	return fstest.MapFS{
+		"index.html":      {Data: []byte(`{{T "hello" .IP}} {{T "bye"}} {{T "missing" .IP}}`)},
+		"error.html":      {Data: []byte(`{{.Header}}: {{.Message}}`)},
+		"locales/en.json": {Data: []byte(`{"hello": "Hello %s", "bye": "Bye", "error.notFound": "%s not found"}`)},
+		"locales/sv.json": {Data: []byte(`{"hello": "Hej %s", "status.404": "Hittades inte", "error.notFound": "%s hittades inte"}`)},
+	}
+}
+
+func TestTemplates_Localized(t *testing.T) {
+	tmpls, err := LoadTemplates(i18nTestFS())
+	if err != nil {
+		t.Fatal(err)
+	}
+	for lang, want := range map[string]string{
+		"en": "Hello 1.2.3.4 Bye missing",
+		"sv": "Hej 1.2.3.4 Bye missing",
+		"de": "Hello 1.2.3.4 Bye missing",
+	} {
+		tmpl, err := tmpls.Lookup("index", lang)
+		if err != nil {
+			t.Fatal(err)
+		}
+		var b strings.Builder
+		if err := tmpl.Execute(&b, page{IP: "1.2.3.4"}); err != nil {
+			t.Fatal(err)
+		}
+		if b.String() != want {
+			t.Errorf("%s: expected %q, got %q", lang, want, b.String())
+		}
+	}
+	if !tmpls.HasLanguage("sv") || tmpls.HasLanguage("de") {
+		t.Error("expected catalogs for en and sv only")
+	}
+
+	fsys := i18nTestFS()
+	fsys["locales/sv.json"] = &fstest.MapFile{Data: []byte(`{"hello": `)}
+	if _, err := LoadTemplates(fsys); err == nil {
+		t.Error("expected a broken catalog to fail")
+	}
+	if err := CheckTemplates(fsys); err == nil {
+		t.Error("expected CheckTemplates to report a broken catalog")
+	}
+}
+
+func TestHandler_LocalizedError(t *testing.T) {
+	h := testHandler()
+	h.SetFS(i18nTestFS())
+
+	req := httptest.NewRequest(http.MethodGet, "/missing", nil)
+	req.Header.Set("Accept-Language", "sv-SE, en;q=0.8")
+	w := httptest.NewRecorder()
+	h.NotFoundHandler(w, req)
+	if body := w.Body.String(); body != "Hittades inte: /missing hittades inte" {
+		t.Errorf("expected a Swedish error page, got %q", body)
+	}
+	if v := w.Header().Get("Vary"); v != "Accept-Language" {
+		t.Errorf("expected Vary: Accept-Language, got %q", v)
+	}
+
+	req = httptest.NewRequest(http.MethodGet, "/missing?lang=en", nil)
+	req.Header.Set("Accept-Language", "sv")
+	w = httptest.NewRecorder()
+	h.NotFoundHandler(w, req)
+	if body := w.Body.String(); body != "Not Found: /missing not found" {
+		t.Errorf("expected ?lang=en to win, got %q", body)
+	}
+}
+
+func TestMainHandler_Localized(t *testing.T) {
+	h := testHandler()
+	req := httptest.NewRequest(http.MethodGet, "/", nil)
+	req.Header.Set("Accept-Language", "sv")
+	w := httptest.NewRecorder()
+	h.MainHandler(w, req)
+
+	body := w.Body.String()
+	if !strings.Contains(body, `<html lang="sv">`) || !strings.Contains(body, "Din IP-adress") {
+		t.Errorf("expected the Swedish index page, got:\n%s", body)
+	}
+}
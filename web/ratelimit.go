@@ -1,10 +1,15 @@
 package web
 
 import (
+	"context"
+	"errors"
 	"net"
 	"net/http"
 	"sync"
+	"sync/atomic"
 	"time"
+
+	"github.com/tuggan/goip/tracing"
 )
 
 type visitor struct {
@@ -22,6 +27,12 @@ type RateLimiter struct {
 	cleanupInterval time.Duration
 	done            chan struct{}
 	stopOnce        sync.Once
+	cleaning        atomic.Bool
+	keyFunc         func(*http.Request) (string, error)
+	limitKeyFunc    func(r *http.Request, ip string) string
+	bans            *BanList
+	allowed         atomic.Uint64
+	denied          atomic.Uint64
 }
 
 // NewRateLimiter creates a RateLimiter with the given rate (tokens/sec),
@@ -36,6 +47,7 @@ func NewRateLimiter(rate float64, burst int, cleanupInterval time.Duration) *Rat
 		done:            make(chan struct{}),
 	}
 	if cleanupInterval > 0 {
+		rl.cleaning.Store(true)
 		go rl.cleanup()
 	}
 	return rl
@@ -44,6 +56,7 @@ func NewRateLimiter(rate float64, burst int, cleanupInterval time.Duration) *Rat
 // cleanup runs in a background goroutine and periodically removes stale
 // visitors that have not been seen for more than 2× the cleanup interval.
 func (rl *RateLimiter) cleanup() {
+	defer rl.cleaning.Store(false)
 	ticker := time.NewTicker(rl.cleanupInterval)
 	defer ticker.Stop()
 	for {
@@ -71,6 +84,38 @@ func (rl *RateLimiter) Stop() {
 	})
 }
 
+// Check reports an error when the limiter has a cleanup interval but its
+// cleanup goroutine is no longer running, so stale visitors pile up. The
+// limiter keeps its state in memory, there is no backend to reach.
+func (rl *RateLimiter) Check(ctx context.Context) error {
+	if rl.cleanupInterval > 0 && !rl.cleaning.Load() {
+		return errors.New("rate limiter cleanup stopped")
+	}
+	return nil
+}
+
+// SetBanList makes Middleware refuse clients on bans with 403 Forbidden,
+// whether rate limiting is enabled or not. It must be called before the
+// middleware serves requests.
+func (rl *RateLimiter) SetBanList(bans *BanList) {
+	rl.bans = bans
+}
+
+// SetKeyFunc replaces the function used by Middleware to derive the client
+// key from a request. By default the IP of RemoteAddr is used, which is
+// wrong behind a reverse proxy. It must be called before the middleware
+// serves requests.
+func (rl *RateLimiter) SetKeyFunc(f func(*http.Request) (string, error)) {
+	rl.keyFunc = f
+}
+
+// SetLimitKeyFunc makes Middleware count requests per key f returns for a
+// request and its client IP, instead of per client IP. Bans still apply to
+// the client IP. It must be called before the middleware serves requests.
+func (rl *RateLimiter) SetLimitKeyFunc(f func(r *http.Request, ip string) string) {
+	rl.limitKeyFunc = f
+}
+
 // Allow reports whether a request from the given IP should be permitted.
 // If the rate limiter is disabled (rate <= 0) every call returns true.
 func (rl *RateLimiter) Allow(ip string) bool {
@@ -98,25 +143,62 @@ func (rl *RateLimiter) Allow(ip string) bool {
 
 	if v.tokens >= 1 {
 		v.tokens--
+		rl.allowed.Add(1)
 		return true
 	}
 
+	rl.denied.Add(1)
 	return false
 }
 
+// Stats returns how many requests the limiter has allowed and denied. A
+// disabled limiter makes no decisions and reports zero for both.
+func (rl *RateLimiter) Stats() (allowed, denied uint64) {
+	return rl.allowed.Load(), rl.denied.Load()
+}
+
+// Visitors returns the number of clients currently tracked.
+func (rl *RateLimiter) Visitors() int {
+	rl.mu.Lock()
+	defer rl.mu.Unlock()
+	return len(rl.visitors)
+}
+
 // Middleware returns an http.Handler that rate-limits incoming requests by
 // client IP. When a request is denied it responds with 429 Too Many Requests
-// and a Retry-After header.
+// and a Retry-After header. Banned clients get 403 Forbidden.
 func (rl *RateLimiter) Middleware(next http.Handler) http.Handler {
 	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
-		ip, _, err := net.SplitHostPort(r.RemoteAddr)
+		var ip string
+		var err error
+		if rl.keyFunc != nil {
+			ip, err = rl.keyFunc(r)
+		} else {
+			ip, _, err = net.SplitHostPort(r.RemoteAddr)
+		}
 		if err != nil {
 			// If we cannot parse the remote address, let the request
 			// through rather than rejecting it.
 			next.ServeHTTP(w, r)
 			return
 		}
-		if !rl.Allow(ip) {
+		if rl.bans != nil && rl.bans.Banned(ip) {
+			w.WriteHeader(http.StatusForbidden)
+			w.Write([]byte("403 Forbidden"))
+			return
+		}
+		var span *tracing.Span
+		if rl.rate > 0 {
+			_, span = tracing.Start(r.Context(), "ratelimit", tracing.String("client.address", ip))
+		}
+		key := ip
+		if rl.limitKeyFunc != nil {
+			key = rl.limitKeyFunc(r, ip)
+		}
+		allowed := rl.Allow(key)
+		span.SetAttributes(tracing.Bool("goip.ratelimit.allowed", allowed))
+		span.End()
+		if !allowed {
 			w.Header().Set("Retry-After", "1")
 			w.WriteHeader(http.StatusTooManyRequests)
 			w.Write([]byte("429 Too Many Requests"))
@@ -1,6 +1,7 @@
 package web
 
 import (
+	"context"
 	"net/http"
 	"net/http/httptest"
 	"testing"
@@ -183,3 +184,70 @@ func TestRateLimiter_Middleware_DifferentIPs(t *testing.T) {
 		t.Errorf("10.0.0.2 request 1: expected 200, got %d", w3.Code)
 	}
 }
+
+func TestRateLimiter_Middleware_KeyFunc(t *testing.T) {
+	rl := NewRateLimiter(1, 1, time.Minute)
+	rl.SetKeyFunc(func(r *http.Request) (string, error) {
+		return r.Header.Get("X-Client"), nil
+	})
+	handler := rl.Middleware(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusOK)
+	}))
+
+	// Same RemoteAddr, different keys: each gets its own bucket.
+	for _, client := range []string{"a", "b"} {
+		req := httptest.NewRequest(http.MethodGet, "/", nil)
+		req.RemoteAddr = "@"
+		req.Header.Set("X-Client", client)
+		w := httptest.NewRecorder()
+		handler.ServeHTTP(w, req)
+		if w.Code != http.StatusOK {
+			t.Errorf("client %s: expected 200, got %d", client, w.Code)
+		}
+	}
+
+	req := httptest.NewRequest(http.MethodGet, "/", nil)
+	req.RemoteAddr = "@"
+	req.Header.Set("X-Client", "a")
+	w := httptest.NewRecorder()
+	handler.ServeHTTP(w, req)
+	if w.Code != http.StatusTooManyRequests {
+		t.Errorf("client a again: expected 429, got %d", w.Code)
+	}
+}
+
+func TestRateLimiter_Check(t *testing.T) {
+	rl := NewRateLimiter(10, 10, time.Minute)
+	if err := rl.Check(context.Background()); err != nil {
+		t.Errorf("expected a running limiter to pass, got %v", err)
+	}
+	rl.Stop()
+	deadline := time.Now().Add(time.Second)
+	for rl.Check(context.Background()) == nil && time.Now().Before(deadline) {
+		time.Sleep(time.Millisecond)
+	}
+	if err := rl.Check(context.Background()); err == nil {
+		t.Error("expected a stopped limiter to fail")
+	}
+
+	rl = NewRateLimiter(10, 10, 0)
+	if err := rl.Check(context.Background()); err != nil {
+		t.Errorf("expected a limiter without cleanup to pass, got %v", err)
+	}
+}
+
+func TestRateLimiter_StatsAndVisitors(t *testing.T) {
+	rl := NewRateLimiter(1, 1, 0)
+	defer rl.Stop()
+	rl.Allow("10.0.0.1")
+	rl.Allow("10.0.0.1")
+	rl.Allow("10.0.0.2")
+
+	allowed, denied := rl.Stats()
+	if allowed != 2 || denied != 1 {
+		t.Errorf("expected 2 allowed and 1 denied, got %d and %d", allowed, denied)
+	}
+	if n := rl.Visitors(); n != 2 {
+		t.Errorf("expected 2 visitors, got %d", n)
+	}
+}
@@ -0,0 +1,180 @@
+package web
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"io"
+	"io/fs"
+	"mime"
+	"net/http"
+	"path"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/tuggan/goip/logger"
+)
+
+// staticRootFiles are served from the root of the static tree under their
+// well known URLs, everything else is served under /static/.
+var staticRootFiles = []string{
+	"/favicon.ico",
+	"/robots.txt",
+	"/humans.txt",
+	"/.well-known/security.txt",
+}
+
+// staticTypes are content types for extensions that are missing from, or
+// differ between, the system MIME tables.
+var staticTypes = map[string]string{
+	".ico":   "image/x-icon",
+	".txt":   "text/plain; charset=utf-8",
+	".css":   "text/css; charset=utf-8",
+	".js":    "text/javascript; charset=utf-8",
+	".svg":   "image/svg+xml",
+	".woff2": "font/woff2",
+}
+
+// contentType returns the content type of the file name, or "" if it
+// should be sniffed from the content.
+func contentType(name string) string {
+	ext := strings.ToLower(path.Ext(name))
+	if t, ok := staticTypes[ext]; ok {
+		return t
+	}
+	return mime.TypeByExtension(ext)
+}
+
+// staticName maps the path of a request to the name of a file in the
+// static tree. It reports false for paths that are not valid names or that
+// contain hidden files, other than those in .well-known.
+func staticName(urlPath string) (string, bool) {
+	name := strings.TrimPrefix(path.Clean("/"+urlPath), "/")
+	name = strings.TrimPrefix(name, "static/")
+	if !fs.ValidPath(name) || name == "." {
+		return "", false
+	}
+	for elem := range strings.SplitSeq(name, "/") {
+		if strings.HasPrefix(elem, ".") && elem != ".well-known" {
+			return "", false
+		}
+	}
+	return name, true
+}
+
+// etagCache remembers the ETag of every served file, so a file is only
+// hashed again when its size or modification time changes.
+type etagCache struct {
+	mu    sync.Mutex
+	etags map[string]etagEntry
+}
+
+type etagEntry struct {
+	size    int64
+	modTime time.Time
+	etag    string
+}
+
+func newETagCache() *etagCache {
+	return &etagCache{etags: make(map[string]etagEntry)}
+}
+
+func (c *etagCache) get(name string, fi fs.FileInfo, content io.ReadSeeker) (string, error) {
+	c.mu.Lock()
+	e, ok := c.etags[name]
+	c.mu.Unlock()
+	if ok && e.size == fi.Size() && e.modTime.Equal(fi.ModTime()) {
+		return e.etag, nil
+	}
+
+	h := sha256.New()
+	if _, err := io.Copy(h, content); err != nil {
+		return "", err
+	}
+	if _, err := content.Seek(0, io.SeekStart); err != nil {
+		return "", err
+	}
+	e = etagEntry{
+		size:    fi.Size(),
+		modTime: fi.ModTime(),
+		etag:    `"` + hex.EncodeToString(h.Sum(nil)[:16]) + `"`,
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.etags[name] = e
+	return e.etag, nil
+}
+
+// StaticHandler serves files from the static tree with their content type,
+// an ETag and cache headers. Conditional and range requests are handled by
+// http.ServeContent. Directories are not listed.
+func (h handler) StaticHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet && r.Method != http.MethodHead {
+		w.Header().Set("Allow", "GET, HEAD")
+		h.renderError(w, r, "error", http.StatusMethodNotAllowed, "error.methodNotAllowed", r.Method)
+		logger.Access(r, http.StatusMethodNotAllowed)
+		return
+	}
+
+	name, ok := staticName(r.URL.Path)
+	if !ok {
+		h.renderError(w, r, "error", http.StatusNotFound, "error.notFound", r.URL.Path)
+		logger.Access(r, http.StatusNotFound)
+		return
+	}
+	theme := h.theme(r)
+	f, err := theme.fsys.Open(path.Join(h.staticDir, name))
+	if err != nil {
+		h.renderError(w, r, "error", http.StatusNotFound, "error.notFound", r.URL.Path)
+		logger.Access(r, http.StatusNotFound)
+		return
+	}
+	defer f.Close()
+	fi, err := f.Stat()
+	if err != nil || fi.IsDir() {
+		h.renderError(w, r, "error", http.StatusNotFound, "error.notFound", r.URL.Path)
+		logger.Access(r, http.StatusNotFound)
+		return
+	}
+
+	content, ok := f.(io.ReadSeeker)
+	if !ok {
+		b, err := io.ReadAll(f)
+		if err != nil {
+			logger.Error("Failed to read static file %s: %v", name, err)
+			h.renderError(w, r, "error", http.StatusInternalServerError, "error.readFile")
+			return
+		}
+		content = bytes.NewReader(b)
+	}
+	etag, err := h.etags.get(theme.Name+":"+name, fi, content)
+	if err != nil {
+		logger.Error("Failed to read static file %s: %v", name, err)
+		h.renderError(w, r, "error", http.StatusInternalServerError, "error.readFile")
+		return
+	}
+
+	w.Header().Set("Server", h.server)
+	w.Header().Set("ETag", etag)
+	w.Header().Set("Cache-Control", "public, max-age="+strconv.Itoa(int(h.staticMaxAge.Seconds())))
+	if ct := contentType(name); ct != "" {
+		w.Header().Set("Content-Type", ct)
+	}
+	rec := &statusRecorder{ResponseWriter: w, code: http.StatusOK}
+	http.ServeContent(rec, r, name, fi.ModTime(), content)
+	logger.Access(r, rec.code)
+}
+
+// statusRecorder records the status code written through it, for the
+// access log.
+type statusRecorder struct {
+	http.ResponseWriter
+	code int
+}
+
+func (s *statusRecorder) WriteHeader(code int) {
+	s.code = code
+	s.ResponseWriter.WriteHeader(code)
+}
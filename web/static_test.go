@@ -0,0 +1,203 @@
+package web
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"testing/fstest"
+	"time"
+)
+
+func staticTestHandler(t *testing.T) handler {
+	t.Helper()
+	h := testHandler()
+	h.SetFS(fstest.MapFS{
+		"error.html":                      {Data: []byte("{{.Message}}")},
+		"static/robots.txt":               {Data: []byte("User-agent: *\n")},
+		"static/css/site.css":             {Data: []byte("body { margin: 0 }\n")},
+		"static/.well-known/security.txt": {Data: []byte("Contact: mailto:security@example.com\n")},
+		"static/.hidden":                  {Data: []byte("secret")},
+		"static/img/logo.svg":             {Data: []byte("<svg></svg>")},
+		"static/files/data.bin":           {Data: []byte("0123456789")},
+		"index.html":                      {Data: []byte("index")},
+	})
+	return h
+}
+
+func serveStatic(h handler, req *http.Request) *httptest.ResponseRecorder {
+	w := httptest.NewRecorder()
+	h.StaticHandler(w, req)
+	return w
+}
+
+func TestStaticHandler_Embedded(t *testing.T) {
+	h := testHandler()
+	for path, ct := range map[string]string{
+		"/favicon.ico": "image/x-icon",
+		"/robots.txt":  "text/plain; charset=utf-8",
+	} {
+		w := serveStatic(h, httptest.NewRequest(http.MethodGet, path, nil))
+		if w.Code != http.StatusOK {
+			t.Errorf("%s: expected 200, got %d", path, w.Code)
+		}
+		if got := w.Header().Get("Content-Type"); got != ct {
+			t.Errorf("%s: expected Content-Type %q, got %q", path, ct, got)
+		}
+		if w.Body.Len() == 0 {
+			t.Errorf("%s: expected a body", path)
+		}
+	}
+}
+
+func TestStaticHandler_ContentTypes(t *testing.T) {
+	h := staticTestHandler(t)
+	for path, ct := range map[string]string{
+		"/static/css/site.css":      "text/css; charset=utf-8",
+		"/static/img/logo.svg":      "image/svg+xml",
+		"/.well-known/security.txt": "text/plain; charset=utf-8",
+		"/static/robots.txt":        "text/plain; charset=utf-8",
+	} {
+		w := serveStatic(h, httptest.NewRequest(http.MethodGet, path, nil))
+		if w.Code != http.StatusOK {
+			t.Errorf("%s: expected 200, got %d", path, w.Code)
+			continue
+		}
+		if got := w.Header().Get("Content-Type"); got != ct {
+			t.Errorf("%s: expected Content-Type %q, got %q", path, ct, got)
+		}
+		if got := w.Header().Get("Cache-Control"); got != "public, max-age=3600" {
+			t.Errorf("%s: expected default Cache-Control, got %q", path, got)
+		}
+	}
+}
+
+func TestStaticHandler_NotFound(t *testing.T) {
+	h := staticTestHandler(t)
+	for _, path := range []string{
+		"/static/missing.css",
+		"/static/css",
+		"/static/",
+		"/static/.hidden",
+		"/static/../index.html",
+		"/static/%2e%2e/index.html",
+		"/humans.txt",
+	} {
+		req := httptest.NewRequest(http.MethodGet, "/", nil)
+		req.URL.Path = path
+		if w := serveStatic(h, req); w.Code != http.StatusNotFound {
+			t.Errorf("%s: expected 404, got %d", path, w.Code)
+		}
+	}
+}
+
+func TestStaticHandler_Method(t *testing.T) {
+	h := staticTestHandler(t)
+	w := serveStatic(h, httptest.NewRequest(http.MethodPost, "/robots.txt", nil))
+	if w.Code != http.StatusMethodNotAllowed {
+		t.Errorf("expected 405 for POST, got %d", w.Code)
+	}
+	if got := w.Header().Get("Allow"); got != "GET, HEAD" {
+		t.Errorf("expected Allow header, got %q", got)
+	}
+
+	w = serveStatic(h, httptest.NewRequest(http.MethodHead, "/robots.txt", nil))
+	if w.Code != http.StatusOK || w.Body.Len() != 0 {
+		t.Errorf("expected 200 without a body for HEAD, got %d %q", w.Code, w.Body.String())
+	}
+}
+
+func TestStaticHandler_ETag(t *testing.T) {
+	h := staticTestHandler(t)
+	w := serveStatic(h, httptest.NewRequest(http.MethodGet, "/static/css/site.css", nil))
+	etag := w.Header().Get("ETag")
+	if !strings.HasPrefix(etag, `"`) || len(etag) != 34 {
+		t.Fatalf("expected a strong ETag, got %q", etag)
+	}
+
+	req := httptest.NewRequest(http.MethodGet, "/static/css/site.css", nil)
+	req.Header.Set("If-None-Match", etag)
+	if w := serveStatic(h, req); w.Code != http.StatusNotModified {
+		t.Errorf("expected 304 for a matching If-None-Match, got %d", w.Code)
+	}
+
+	req = httptest.NewRequest(http.MethodGet, "/static/css/site.css", nil)
+	req.Header.Set("If-None-Match", `"other"`)
+	if w := serveStatic(h, req); w.Code != http.StatusOK {
+		t.Errorf("expected 200 for a different If-None-Match, got %d", w.Code)
+	}
+}
+
+func TestStaticHandler_IfModifiedSince(t *testing.T) {
+	dir := t.TempDir()
+	if err := os.Mkdir(filepath.Join(dir, "static"), 0o755); err != nil {
+		t.Fatal(err)
+	}
+	file := filepath.Join(dir, "static", "humans.txt")
+	if err := os.WriteFile(file, []byte("Dennis\n"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	modified := time.Date(2024, 6, 15, 12, 0, 0, 0, time.UTC)
+	if err := os.Chtimes(file, modified, modified); err != nil {
+		t.Fatal(err)
+	}
+	h := NewHandler(false, dir, "v", "b", "d", "a", "e", nil)
+
+	w := serveStatic(h, httptest.NewRequest(http.MethodGet, "/humans.txt", nil))
+	if got := w.Header().Get("Last-Modified"); got != modified.Format(http.TimeFormat) {
+		t.Errorf("expected Last-Modified %q, got %q", modified.Format(http.TimeFormat), got)
+	}
+
+	req := httptest.NewRequest(http.MethodGet, "/humans.txt", nil)
+	req.Header.Set("If-Modified-Since", modified.Format(http.TimeFormat))
+	if w := serveStatic(h, req); w.Code != http.StatusNotModified {
+		t.Errorf("expected 304 for an unmodified file, got %d", w.Code)
+	}
+
+	req = httptest.NewRequest(http.MethodGet, "/humans.txt", nil)
+	req.Header.Set("If-Modified-Since", modified.Add(-time.Hour).Format(http.TimeFormat))
+	if w := serveStatic(h, req); w.Code != http.StatusOK {
+		t.Errorf("expected 200 for a modified file, got %d", w.Code)
+	}
+}
+
+func TestStaticHandler_Range(t *testing.T) {
+	h := staticTestHandler(t)
+	req := httptest.NewRequest(http.MethodGet, "/static/files/data.bin", nil)
+	req.Header.Set("Range", "bytes=2-5")
+	w := serveStatic(h, req)
+	if w.Code != http.StatusPartialContent {
+		t.Fatalf("expected 206, got %d", w.Code)
+	}
+	if w.Body.String() != "2345" {
+		t.Errorf("expected bytes 2-5, got %q", w.Body.String())
+	}
+	if got := w.Header().Get("Content-Range"); got != "bytes 2-5/10" {
+		t.Errorf("expected Content-Range bytes 2-5/10, got %q", got)
+	}
+}
+
+func TestSetStatic(t *testing.T) {
+	h := staticTestHandler(t)
+	if err := h.SetStatic("static/css", 24*time.Hour); err != nil {
+		t.Fatal(err)
+	}
+	w := serveStatic(h, httptest.NewRequest(http.MethodGet, "/static/site.css", nil))
+	if w.Code != http.StatusOK {
+		t.Errorf("expected the file from the configured directory, got %d", w.Code)
+	}
+	if got := w.Header().Get("Cache-Control"); got != "public, max-age=86400" {
+		t.Errorf("expected configured Cache-Control, got %q", got)
+	}
+
+	for _, dir := range []string{"../static", "/srv/static"} {
+		if err := h.SetStatic(dir, time.Hour); err == nil {
+			t.Errorf("expected %q to be rejected", dir)
+		}
+	}
+	if err := h.SetStatic("static", -time.Second); err == nil {
+		t.Error("expected a negative max age to be rejected")
+	}
+}
@@ -0,0 +1,200 @@
+package web
+
+import (
+	"html/template"
+	"io/fs"
+	"sync"
+)
+
+// templateNames are the templates rendered by the handlers, without the
+// .html extension.
+var templateNames = []string{"index", "error"}
+
+const (
+	// layoutFile is the base layout the page templates extend.
+	layoutFile = "layout.html"
+	// blocksFile holds block definitions that override those of the
+	// layout and of every page, so a theme can change a single block
+	// without copying the page templates.
+	blocksFile = "blocks.html"
+)
+
+// parsePage parses the template name together with the layout and block
+// overrides, if fsys has them. T is a placeholder until the template is
+// localized by Lookup.
+func parsePage(fsys fs.FS, name string) (*template.Template, error) {
+	var files []string
+	if _, err := fs.Stat(fsys, layoutFile); err == nil {
+		files = append(files, layoutFile)
+	}
+	files = append(files, name+".html")
+	if _, err := fs.Stat(fsys, blocksFile); err == nil {
+		files = append(files, blocksFile)
+	}
+	return template.New(name+".html").
+		Funcs(template.FuncMap{"T": translator(nil, DefaultLanguage)}).
+		ParseFS(fsys, files...)
+}
+
+// Templates is the set of parsed templates the handlers render, and the
+// message catalogs they are localized with. It is safe for concurrent use
+// and shared between listeners.
+type Templates struct {
+	fsys fs.FS
+
+	mu       sync.RWMutex
+	set      map[string]*template.Template
+	catalogs map[string]catalog
+	// localized caches the templates with T bound to a language, keyed
+	// by template name and language.
+	localized map[[2]string]*template.Template
+	// gen counts reloads, so templates localized before a reload are not
+	// cached after it.
+	gen uint64
+	// err is the error of the last reload, nil once one succeeds.
+	err error
+}
+
+// NewTemplates creates a template set reading from fsys. Templates are
+// parsed on first use, use LoadTemplates to parse them up front.
+func NewTemplates(fsys fs.FS) *Templates {
+	return &Templates{
+		fsys:      fsys,
+		set:       make(map[string]*template.Template),
+		localized: make(map[[2]string]*template.Template),
+	}
+}
+
+// LoadTemplates parses every template and catalog in fsys, so syntax errors
+// are found at startup instead of on the first request.
+func LoadTemplates(fsys fs.FS) (*Templates, error) {
+	t := NewTemplates(fsys)
+	if err := t.Reload(); err != nil {
+		return nil, err
+	}
+	return t, nil
+}
+
+// Reload parses every template and catalog again. If any of them fails to
+// parse the previously parsed set is kept.
+func (t *Templates) Reload() error {
+	set, catalogs, err := t.parse()
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	t.err = err
+	if err != nil {
+		return err
+	}
+	t.set = set
+	t.catalogs = catalogs
+	t.localized = make(map[[2]string]*template.Template)
+	t.gen++
+	return nil
+}
+
+// parse parses every template and catalog in the file system.
+func (t *Templates) parse() (map[string]*template.Template, map[string]catalog, error) {
+	catalogs, err := loadCatalogs(t.fsys)
+	if err != nil {
+		return nil, nil, err
+	}
+	set := make(map[string]*template.Template, len(templateNames))
+	for _, name := range templateNames {
+		tmpl, err := parsePage(t.fsys, name)
+		if err != nil {
+			return nil, nil, err
+		}
+		set[name] = tmpl
+	}
+	return set, catalogs, nil
+}
+
+// Err returns the error of the last reload, or nil if it succeeded. It
+// does not read the templates again.
+func (t *Templates) Err() error {
+	t.mu.RLock()
+	defer t.mu.RUnlock()
+	return t.err
+}
+
+// languages returns the catalogs, loading them if they have not been
+// loaded yet.
+func (t *Templates) languages() (map[string]catalog, error) {
+	t.mu.RLock()
+	catalogs := t.catalogs
+	t.mu.RUnlock()
+	if catalogs != nil {
+		return catalogs, nil
+	}
+
+	catalogs, err := loadCatalogs(t.fsys)
+	if err != nil {
+		return nil, err
+	}
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if t.catalogs == nil {
+		t.catalogs = catalogs
+	}
+	return t.catalogs, nil
+}
+
+// HasLanguage reports whether there is a catalog for lang.
+func (t *Templates) HasLanguage(lang string) bool {
+	catalogs, err := t.languages()
+	if err != nil {
+		return false
+	}
+	_, ok := catalogs[lang]
+	return ok
+}
+
+// Translator returns the T function for lang, for messages rendered
+// outside of templates.
+func (t *Templates) Translator(lang string) func(key string, args ...any) string {
+	catalogs, _ := t.languages()
+	return translator(catalogs, lang)
+}
+
+// Lookup returns the template name, e.g. "index", with the T function
+// translating into lang. Templates are parsed and localized on first use.
+func (t *Templates) Lookup(name, lang string) (*template.Template, error) {
+	key := [2]string{name, lang}
+	t.mu.RLock()
+	tmpl, ok := t.localized[key]
+	base := t.set[name]
+	gen := t.gen
+	t.mu.RUnlock()
+	if ok {
+		return tmpl, nil
+	}
+
+	catalogs, err := t.languages()
+	if err != nil {
+		return nil, err
+	}
+	if base == nil {
+		if base, err = parsePage(t.fsys, name); err != nil {
+			return nil, err
+		}
+	}
+	// Templates are cloned before they are executed, so the parsed one
+	// can be cloned again for every language.
+	tmpl, err = base.Clone()
+	if err != nil {
+		return nil, err
+	}
+	tmpl.Funcs(template.FuncMap{"T": translator(catalogs, lang)})
+
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	// After a Reload in the meantime the template may be outdated, so it
+	// is not cached.
+	if gen == t.gen {
+		if _, ok := t.set[name]; !ok {
+			t.set[name] = base
+		}
+		t.localized[key] = tmpl
+	}
+	return tmpl, nil
+}
@@ -0,0 +1,110 @@
+package web
+
+import (
+	"bytes"
+	"testing"
+	"testing/fstest"
+)
+
+func testTemplateFS(index string) fstest.MapFS {
+	return fstest.MapFS{
+		"index.html": {Data: []byte(index)},
+		"error.html": {Data: []byte("{{.Message}}")},
+	}
+}
+
+func render(t *testing.T, tmpls *Templates, name string, data any) string {
+	t.Helper()
+	tmpl, err := tmpls.Lookup(name, DefaultLanguage)
+	if err != nil {
+		t.Fatalf("Lookup(%q): %v", name, err)
+	}
+	var buf bytes.Buffer
+	if err := tmpl.Execute(&buf, data); err != nil {
+		t.Fatal(err)
+	}
+	return buf.String()
+}
+
+func TestLoadTemplates(t *testing.T) {
+	tmpls, err := LoadTemplates(testTemplateFS("hello {{.IP}}"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := render(t, tmpls, "index", page{IP: "1.2.3.4"}); got != "hello 1.2.3.4" {
+		t.Errorf("expected rendered index, got %q", got)
+	}
+
+	if _, err := LoadTemplates(testTemplateFS("{{.IP")); err == nil {
+		t.Error("expected a syntax error to fail")
+	}
+	if _, err := LoadTemplates(fstest.MapFS{}); err == nil {
+		t.Error("expected missing templates to fail")
+	}
+}
+
+func TestTemplates_LookupCaches(t *testing.T) {
+	fsys := testTemplateFS("first")
+	tmpls := NewTemplates(fsys)
+	if got := render(t, tmpls, "index", nil); got != "first" {
+		t.Fatalf("expected %q, got %q", "first", got)
+	}
+	fsys["index.html"] = &fstest.MapFile{Data: []byte("second")}
+	if got := render(t, tmpls, "index", nil); got != "first" {
+		t.Errorf("expected the parsed template to be cached, got %q", got)
+	}
+	if err := tmpls.Reload(); err != nil {
+		t.Fatal(err)
+	}
+	if got := render(t, tmpls, "index", nil); got != "second" {
+		t.Errorf("expected %q after Reload, got %q", "second", got)
+	}
+}
+
+func TestTemplates_ReloadKeepsSetOnError(t *testing.T) {
+	fsys := testTemplateFS("good")
+	tmpls, err := LoadTemplates(fsys)
+	if err != nil {
+		t.Fatal(err)
+	}
+	fsys["index.html"] = &fstest.MapFile{Data: []byte("{{if}}")}
+	if err := tmpls.Reload(); err == nil {
+		t.Error("expected Reload to fail on a broken template")
+	}
+	if got := render(t, tmpls, "index", nil); got != "good" {
+		t.Errorf("expected the previous template to be kept, got %q", got)
+	}
+	if tmpls.Err() == nil {
+		t.Error("expected Err to report the failed reload")
+	}
+	fsys["index.html"] = &fstest.MapFile{Data: []byte("fixed")}
+	if err := tmpls.Reload(); err != nil || tmpls.Err() != nil {
+		t.Errorf("expected a successful reload to clear the error, got %v", tmpls.Err())
+	}
+}
+
+func TestTemplates_Layout(t *testing.T) {
+	fsys := fstest.MapFS{
+		"layout.html": {Data: []byte(`<main>{{block "body" .}}default{{end}}</main>{{block "footer" .}}footer{{end}}`)},
+		"index.html":  {Data: []byte(`{{template "layout.html" .}}{{define "body"}}index{{end}}`)},
+		"error.html":  {Data: []byte(`standalone {{.Message}}`)},
+	}
+	tmpls, err := LoadTemplates(fsys)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := render(t, tmpls, "index", nil); got != "<main>index</main>footer" {
+		t.Errorf("expected the page to fill the layout, got %q", got)
+	}
+	if got := render(t, tmpls, "error", page{Message: "x"}); got != "standalone x" {
+		t.Errorf("expected a page without the layout to render on its own, got %q", got)
+	}
+
+	fsys["blocks.html"] = &fstest.MapFile{Data: []byte(`{{define "footer"}}custom{{end}}`)}
+	if err := tmpls.Reload(); err != nil {
+		t.Fatal(err)
+	}
+	if got := render(t, tmpls, "index", nil); got != "<main>index</main>custom" {
+		t.Errorf("expected blocks.html to override the footer, got %q", got)
+	}
+}
@@ -0,0 +1,282 @@
+package web
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"maps"
+	"net"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/fsnotify/fsnotify"
+	"github.com/tuggan/goip/logger"
+)
+
+// themesDir is the directory of the template file system holding a
+// directory per theme.
+const themesDir = "themes"
+
+// Link is a link shown by the templates, e.g. in the footer.
+type Link struct {
+	Name string
+	URL  string
+}
+
+// Site holds the config defined variables available to templates as
+// .Site.
+type Site struct {
+	Name   string
+	Footer string
+	Links  []Link
+	Vars   map[string]string
+}
+
+// merge returns s with the fields set in o replacing its own. Vars are
+// merged key by key.
+func (s Site) merge(o Site) Site {
+	if o.Name != "" {
+		s.Name = o.Name
+	}
+	if o.Footer != "" {
+		s.Footer = o.Footer
+	}
+	if o.Links != nil {
+		s.Links = o.Links
+	}
+	if len(o.Vars) > 0 {
+		vars := make(map[string]string, len(s.Vars)+len(o.Vars))
+		maps.Copy(vars, s.Vars)
+		maps.Copy(vars, o.Vars)
+		s.Vars = vars
+	}
+	return s
+}
+
+// Theme is a named set of templates and static files. A theme reads from
+// themes/<name>/ of the template file system, and takes every file it does
+// not have from the template file system itself.
+type Theme struct {
+	Name      string
+	Site      Site
+	fsys      fs.FS
+	templates *Templates
+}
+
+func newTheme(fsys fs.FS, name string, site Site) (*Theme, error) {
+	t := &Theme{Name: name, Site: site, fsys: fsys}
+	if name != "" {
+		if strings.Contains(name, "/") || !fs.ValidPath(name) || name == "." {
+			return nil, fmt.Errorf("invalid theme name %q", name)
+		}
+		dir := path.Join(themesDir, name)
+		fi, err := fs.Stat(fsys, dir)
+		if err != nil {
+			return nil, fmt.Errorf("theme %q: %w", name, err)
+		}
+		if !fi.IsDir() {
+			return nil, fmt.Errorf("theme %q: %s is not a directory", name, dir)
+		}
+		sub, err := fs.Sub(fsys, dir)
+		if err != nil {
+			return nil, err
+		}
+		t.fsys = NewOverlayFS(sub, fsys)
+	}
+	t.templates = NewTemplates(t.fsys)
+	return t, nil
+}
+
+// ThemeConfig configures a theme, the hosts it is used for and the site
+// variables that differ from the global ones.
+type ThemeConfig struct {
+	Name  string
+	Hosts []string
+	Site  Site
+}
+
+// Themes selects the theme of a request by its host. It is safe for
+// concurrent use and shared between listeners.
+type Themes struct {
+	def   *Theme
+	all   []*Theme
+	hosts map[string]*Theme
+}
+
+// NewThemes creates a theme set using the templates in fsys for every host.
+// Templates are parsed on first use.
+func NewThemes(fsys fs.FS) *Themes {
+	t, _ := newTheme(fsys, "", Site{})
+	return &Themes{def: t, all: []*Theme{t}, hosts: make(map[string]*Theme)}
+}
+
+// LoadThemes creates the themes of fsys and parses their templates. def is
+// the theme of hosts without one of their own, "" for the templates in
+// fsys itself. site holds the variables of every theme, configs the themes
+// used for specific hosts or with variables of their own.
+func LoadThemes(fsys fs.FS, def string, site Site, configs []ThemeConfig) (*Themes, error) {
+	themes := &Themes{hosts: make(map[string]*Theme)}
+	byName := make(map[string]*Theme)
+	add := func(name string, s Site) (*Theme, error) {
+		t, err := newTheme(fsys, name, site.merge(s))
+		if err != nil {
+			return nil, err
+		}
+		byName[name] = t
+		themes.all = append(themes.all, t)
+		return t, nil
+	}
+
+	for _, c := range configs {
+		if _, ok := byName[c.Name]; ok {
+			return nil, fmt.Errorf("theme %q is configured more than once", c.Name)
+		}
+		t, err := add(c.Name, c.Site)
+		if err != nil {
+			return nil, err
+		}
+		for _, h := range c.Hosts {
+			h = strings.ToLower(strings.TrimSpace(h))
+			if other, ok := themes.hosts[h]; ok {
+				return nil, fmt.Errorf("host %q is used by themes %q and %q", h, other.Name, t.Name)
+			}
+			themes.hosts[h] = t
+		}
+	}
+	themes.def = byName[def]
+	if themes.def == nil {
+		t, err := add(def, Site{})
+		if err != nil {
+			return nil, err
+		}
+		themes.def = t
+	}
+
+	if err := themes.Reload(); err != nil {
+		return nil, err
+	}
+	return themes, nil
+}
+
+// ForHost returns the theme for the Host header host.
+func (t *Themes) ForHost(host string) *Theme {
+	if len(t.hosts) > 0 {
+		if h, _, err := net.SplitHostPort(host); err == nil {
+			host = h
+		}
+		if theme, ok := t.hosts[strings.ToLower(strings.TrimSuffix(host, "."))]; ok {
+			return theme
+		}
+	}
+	return t.def
+}
+
+// Named returns the theme name, or nil if there is no such theme.
+func (t *Themes) Named(name string) *Theme {
+	for _, theme := range t.all {
+		if theme.Name == name {
+			return theme
+		}
+	}
+	return nil
+}
+
+// Reload parses the templates of every theme again. A theme whose
+// templates fail to parse keeps its previous ones.
+func (t *Themes) Reload() error {
+	var errs []error
+	for _, theme := range t.all {
+		if err := theme.templates.Reload(); err != nil {
+			errs = append(errs, themeError(theme, err))
+		}
+	}
+	return errors.Join(errs...)
+}
+
+// Check reports the themes whose templates failed to parse on their last
+// reload, so templates broken while running show up in readiness checks.
+// The templates are not parsed again.
+func (t *Themes) Check() error {
+	var errs []error
+	for _, theme := range t.all {
+		if err := theme.templates.Err(); err != nil {
+			errs = append(errs, themeError(theme, err))
+		}
+	}
+	return errors.Join(errs...)
+}
+
+func themeError(theme *Theme, err error) error {
+	if theme.Name == "" {
+		return err
+	}
+	return fmt.Errorf("theme %q: %w", theme.Name, err)
+}
+
+// reloadDelay is how long Watch waits for further changes before
+// reloading, editors often write a file in several steps.
+const reloadDelay = 100 * time.Millisecond
+
+// Watch reloads the templates whenever a file in dir, the template
+// directory, in the directory of one of the themes or in their locales
+// directories changes, which is meant for developing templates. Closing the
+// returned watcher stops it.
+func (t *Themes) Watch(dir string) (io.Closer, error) {
+	w, err := fsnotify.NewWatcher()
+	if err != nil {
+		return nil, err
+	}
+	if err := w.Add(dir); err != nil {
+		w.Close()
+		return nil, err
+	}
+	dirs := []string{filepath.Join(dir, localesDir)}
+	for _, theme := range t.all {
+		if theme.Name == "" {
+			continue
+		}
+		themeDir := filepath.Join(dir, themesDir, theme.Name)
+		dirs = append(dirs, themeDir, filepath.Join(themeDir, localesDir))
+	}
+	for _, d := range dirs {
+		// Catalogs and themes that are only built into the binary have no
+		// directory.
+		if err := w.Add(d); err != nil && !errors.Is(err, os.ErrNotExist) {
+			w.Close()
+			return nil, err
+		}
+	}
+
+	go func() {
+		var reload <-chan time.Time
+		for {
+			select {
+			case ev, ok := <-w.Events:
+				if !ok {
+					return
+				}
+				if ev.Has(fsnotify.Chmod) && !ev.Has(fsnotify.Write) {
+					continue
+				}
+				reload = time.After(reloadDelay)
+			case <-reload:
+				reload = nil
+				if err := t.Reload(); err != nil {
+					logger.Error("Failed to reload templates: %v", err)
+					continue
+				}
+				logger.Info("Reloaded templates from %s", dir)
+			case err, ok := <-w.Errors:
+				if !ok {
+					return
+				}
+				logger.Warning("Template watcher: %v", err)
+			}
+		}
+	}()
+	return w, nil
+}
@@ -0,0 +1,187 @@
+package web
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"testing/fstest"
+	"time"
+)
+
+func themeTestFS() fstest.MapFS {
+	return fstest.MapFS{
+		"layout.html":              {Data: []byte(`<title>{{.Title}}</title>{{block "body" .}}{{end}}{{block "footer" .}}{{.Site.Footer}}{{end}}`)},
+		"index.html":               {Data: []byte(`{{template "layout.html" .}}{{define "body"}}default {{.IP}}{{end}}`)},
+		"error.html":               {Data: []byte(`{{template "layout.html" .}}{{define "body"}}{{.Code}}{{end}}`)},
+		"static/robots.txt":        {Data: []byte("default robots\n")},
+		"themes/acme/blocks.html":  {Data: []byte(`{{define "footer"}}acme {{.Site.Vars.team}}{{end}}`)},
+		"themes/acme/static/a.css": {Data: []byte("body{}\n")},
+		"themes/other/index.html":  {Data: []byte(`other`)},
+		"themes/file":              {Data: []byte(`not a directory`)},
+	}
+}
+
+func TestLoadThemes(t *testing.T) {
+	site := Site{Name: "GoIP", Footer: "global", Vars: map[string]string{"team": "ops", "env": "prod"}}
+	themes, err := LoadThemes(themeTestFS(), "other", site, []ThemeConfig{{
+		Name:  "acme",
+		Hosts: []string{"IP.Acme.Example"},
+		Site:  Site{Name: "Acme", Vars: map[string]string{"team": "acme-ops"}},
+	}})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for host, want := range map[string]string{
+		"ip.acme.example":      "acme",
+		"IP.ACME.EXAMPLE:8080": "acme",
+		"ip.acme.example.":     "acme",
+		"ip.example":           "other",
+		"":                     "other",
+	} {
+		if got := themes.ForHost(host).Name; got != want {
+			t.Errorf("ForHost(%q): expected theme %q, got %q", host, want, got)
+		}
+	}
+
+	acme := themes.ForHost("ip.acme.example").Site
+	if acme.Name != "Acme" || acme.Footer != "global" {
+		t.Errorf("expected the theme site to override the global one, got %+v", acme)
+	}
+	if acme.Vars["team"] != "acme-ops" || acme.Vars["env"] != "prod" {
+		t.Errorf("expected vars to be merged, got %v", acme.Vars)
+	}
+	if site.Vars["team"] != "ops" {
+		t.Error("expected the global vars to be left alone")
+	}
+}
+
+func TestLoadThemes_Errors(t *testing.T) {
+	for name, configs := range map[string][]ThemeConfig{
+		"missing theme":   {{Name: "missing"}},
+		"file theme":      {{Name: "file"}},
+		"invalid name":    {{Name: "../acme"}},
+		"nested name":     {{Name: "acme/static"}},
+		"duplicate theme": {{Name: "acme"}, {Name: "acme"}},
+		"duplicate host":  {{Name: "acme", Hosts: []string{"a"}}, {Name: "other", Hosts: []string{"A"}}},
+	} {
+		if _, err := LoadThemes(themeTestFS(), "", Site{}, configs); err == nil {
+			t.Errorf("%s: expected an error", name)
+		}
+	}
+	if _, err := LoadThemes(themeTestFS(), "missing", Site{}, nil); err == nil {
+		t.Error("expected a missing default theme to fail")
+	}
+
+	fsys := themeTestFS()
+	fsys["themes/acme/index.html"] = &fstest.MapFile{Data: []byte(`{{.IP`)}
+	if _, err := LoadThemes(fsys, "", Site{}, []ThemeConfig{{Name: "acme"}}); err == nil {
+		t.Error("expected a theme with a broken template to fail")
+	}
+}
+
+func TestThemes_Check(t *testing.T) {
+	fsys := themeTestFS()
+	themes, err := LoadThemes(fsys, "", Site{}, []ThemeConfig{{Name: "acme"}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	fsys["themes/acme/blocks.html"] = &fstest.MapFile{Data: []byte(`{{define "footer"}}`)}
+	if err := themes.Check(); err != nil {
+		t.Errorf("expected Check not to parse the files again, got %v", err)
+	}
+	themes.Reload()
+	if err := themes.Check(); err == nil || !strings.Contains(err.Error(), `theme "acme"`) {
+		t.Errorf("expected the failed reload of acme, got %v", err)
+	}
+}
+
+func TestHandler_Themes(t *testing.T) {
+	themes, err := LoadThemes(themeTestFS(), "", Site{Name: "Brand", Footer: "global"}, []ThemeConfig{
+		{Name: "acme", Hosts: []string{"acme.example"}, Site: Site{Vars: map[string]string{"team": "ops"}}},
+		{Name: "other", Hosts: []string{"other.example"}},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	h := testHandler()
+	h.SetThemes(themes)
+
+	get := func(host, path string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(http.MethodGet, path, nil)
+		req.Host = host
+		req.RemoteAddr = "1.2.3.4:5678"
+		w := httptest.NewRecorder()
+		h.Routes()[path](w, req)
+		return w
+	}
+
+	for host, want := range map[string]string{
+		"ip.example":    "<title>Brand</title>default 1.2.3.4global",
+		"acme.example":  "<title>Brand</title>default 1.2.3.4acme ops",
+		"other.example": "other",
+	} {
+		if got := get(host, "/").Body.String(); got != want {
+			t.Errorf("%s: expected %q, got %q", host, want, got)
+		}
+	}
+
+	if w := get("acme.example", "/static/"); w.Code != http.StatusNotFound {
+		t.Errorf("expected 404, got %d", w.Code)
+	}
+	req := httptest.NewRequest(http.MethodGet, "/static/a.css", nil)
+	req.Host = "acme.example"
+	w := httptest.NewRecorder()
+	h.StaticHandler(w, req)
+	if w.Code != http.StatusOK {
+		t.Errorf("expected the theme's static file, got %d", w.Code)
+	}
+	req.Host = "ip.example"
+	w = httptest.NewRecorder()
+	h.StaticHandler(w, req)
+	if w.Code != http.StatusNotFound {
+		t.Errorf("expected other themes not to have the file, got %d", w.Code)
+	}
+	if body := get("acme.example", "/robots.txt").Body.String(); !strings.Contains(body, "default robots") {
+		t.Errorf("expected the theme to fall back to the default static files, got %q", body)
+	}
+}
+
+func TestThemes_Watch(t *testing.T) {
+	dir := t.TempDir()
+	write := func(name, data string) {
+		t.Helper()
+		if err := os.MkdirAll(filepath.Dir(filepath.Join(dir, name)), 0o755); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(filepath.Join(dir, name), []byte(data), 0o644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	write("index.html", "before")
+	write("error.html", "{{.Message}}")
+	write("themes/acme/index.html", "acme before")
+	themes, err := LoadThemes(os.DirFS(dir), "", Site{}, []ThemeConfig{{Name: "acme", Hosts: []string{"acme"}}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	w, err := themes.Watch(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer w.Close()
+
+	write("index.html", "after")
+	write("themes/acme/index.html", "acme after")
+	deadline := time.Now().Add(5 * time.Second)
+	for render(t, themes.ForHost("").templates, "index", nil) != "after" ||
+		render(t, themes.ForHost("acme").templates, "index", nil) != "acme after" {
+		if time.Now().After(deadline) {
+			t.Fatal("templates were not reloaded after a change")
+		}
+		time.Sleep(20 * time.Millisecond)
+	}
+}
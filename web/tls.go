@@ -0,0 +1,206 @@
+package web
+
+import (
+	"crypto/tls"
+	"encoding/json"
+	"net/http"
+	"slices"
+	"strconv"
+
+	"github.com/tuggan/goip/logger"
+)
+
+// ClientHello is what a client offered in the ClientHello message of its
+// TLS handshake.
+type ClientHello struct {
+	ServerName       string
+	Versions         []uint16
+	CipherSuites     []uint16
+	Curves           []tls.CurveID
+	PointFormats     []uint8
+	SignatureSchemes []tls.SignatureScheme
+	ALPN             []string
+	// Extensions lists the extension IDs in the order the client sent
+	// them.
+	Extensions []uint16
+	// Fingerprint is computed from the fields above by NewClientHello.
+	Fingerprint Fingerprint
+}
+
+// NewClientHello copies the fields of hello describing the client, as
+// hello itself must not be kept after the handshake.
+func NewClientHello(hello *tls.ClientHelloInfo) *ClientHello {
+	c := &ClientHello{
+		ServerName:       hello.ServerName,
+		Versions:         slices.Clone(hello.SupportedVersions),
+		CipherSuites:     slices.Clone(hello.CipherSuites),
+		Curves:           slices.Clone(hello.SupportedCurves),
+		PointFormats:     slices.Clone(hello.SupportedPoints),
+		SignatureSchemes: slices.Clone(hello.SignatureSchemes),
+		ALPN:             slices.Clone(hello.SupportedProtos),
+		Extensions:       slices.Clone(hello.Extensions),
+	}
+	c.Fingerprint = newFingerprint(c)
+	return c
+}
+
+// tlsExtensionNames are the names of the TLS extensions clients commonly
+// send, from the IANA registry.
+var tlsExtensionNames = map[uint16]string{
+	0:     "server_name",
+	1:     "max_fragment_length",
+	5:     "status_request",
+	10:    "supported_groups",
+	11:    "ec_point_formats",
+	13:    "signature_algorithms",
+	15:    "heartbeat",
+	16:    "application_layer_protocol_negotiation",
+	18:    "signed_certificate_timestamp",
+	21:    "padding",
+	22:    "encrypt_then_mac",
+	23:    "extended_master_secret",
+	27:    "compress_certificate",
+	28:    "record_size_limit",
+	34:    "delegated_credential",
+	35:    "session_ticket",
+	41:    "pre_shared_key",
+	42:    "early_data",
+	43:    "supported_versions",
+	44:    "cookie",
+	45:    "psk_key_exchange_modes",
+	49:    "post_handshake_auth",
+	50:    "signature_algorithms_cert",
+	51:    "key_share",
+	57:    "quic_transport_parameters",
+	17513: "application_settings",
+	17613: "application_settings",
+	65037: "encrypted_client_hello",
+	65281: "renegotiation_info",
+}
+
+// isGREASE reports whether v is one of the reserved values clients send to
+// keep servers tolerant of unknown values (RFC 8701).
+func isGREASE(v uint16) bool {
+	return v&0x0f0f == 0x0a0a && v>>8 == v&0xff
+}
+
+// tlsValue is a numeric TLS protocol value together with its name.
+type tlsValue struct {
+	ID   uint16 `json:"id"`
+	Name string `json:"name"`
+}
+
+// tlsValues names every value of vs with name, or "GREASE" for reserved
+// values.
+func tlsValues[T ~uint16](vs []T, name func(T) string) []tlsValue {
+	named := make([]tlsValue, len(vs))
+	for i, v := range vs {
+		named[i] = tlsValue{ID: uint16(v), Name: name(v)}
+		if isGREASE(uint16(v)) {
+			named[i].Name = "GREASE"
+		}
+	}
+	return named
+}
+
+func extensionName(id uint16) string {
+	if name, ok := tlsExtensionNames[id]; ok {
+		return name
+	}
+	return strconv.Itoa(int(id))
+}
+
+// clientHelloReport is the JSON form of a ClientHello.
+type clientHelloReport struct {
+	ServerName       string     `json:"serverName,omitempty"`
+	Versions         []tlsValue `json:"versions"`
+	CipherSuites     []tlsValue `json:"cipherSuites"`
+	Curves           []tlsValue `json:"curves"`
+	PointFormats     []int      `json:"pointFormats"`
+	SignatureSchemes []tlsValue `json:"signatureSchemes"`
+	ALPN             []string   `json:"alpn"`
+	Extensions       []tlsValue `json:"extensions"`
+}
+
+// tlsReport is the JSON served on /tls.
+type tlsReport struct {
+	TLS         bool               `json:"tls"`
+	Version     string             `json:"version,omitempty"`
+	CipherSuite string             `json:"cipherSuite,omitempty"`
+	ServerName  string             `json:"serverName,omitempty"`
+	ALPN        string             `json:"alpn,omitempty"`
+	Resumed     bool               `json:"resumed"`
+	ClientHello *clientHelloReport `json:"clientHello,omitempty"`
+}
+
+// newTLSReport describes the TLS session of r. The ClientHello is only
+// known for connections whose listener captured it.
+func newTLSReport(r *http.Request) tlsReport {
+	if r.TLS == nil {
+		return tlsReport{}
+	}
+	report := tlsReport{
+		TLS:         true,
+		Version:     tls.VersionName(r.TLS.Version),
+		CipherSuite: tls.CipherSuiteName(r.TLS.CipherSuite),
+		ServerName:  r.TLS.ServerName,
+		ALPN:        r.TLS.NegotiatedProtocol,
+		Resumed:     r.TLS.DidResume,
+	}
+	if info := ConnInfoFromContext(r.Context()); info != nil && info.Hello != nil {
+		hello := info.Hello
+		points := make([]int, len(hello.PointFormats))
+		for i, p := range hello.PointFormats {
+			points[i] = int(p)
+		}
+		report.ClientHello = &clientHelloReport{
+			ServerName:       hello.ServerName,
+			Versions:         tlsValues(hello.Versions, tls.VersionName),
+			CipherSuites:     tlsValues(hello.CipherSuites, tls.CipherSuiteName),
+			Curves:           tlsValues(hello.Curves, tls.CurveID.String),
+			PointFormats:     points,
+			SignatureSchemes: tlsValues(hello.SignatureSchemes, tls.SignatureScheme.String),
+			ALPN:             hello.ALPN,
+			Extensions:       tlsValues(hello.Extensions, extensionName),
+		}
+	}
+	return report
+}
+
+// TLSHandler reports the TLS session of the request as JSON: the
+// negotiated parameters and what the client offered in its ClientHello.
+// Plain HTTP requests get "tls": false.
+func (h handler) TLSHandler(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Server", h.server)
+	w.Header().Set("Content-Type", "application/json")
+	enc := json.NewEncoder(w)
+	enc.SetIndent("", "  ")
+	if err := enc.Encode(newTLSReport(r)); err != nil {
+		logger.Error("failed to write TLS report: %s", err)
+		return
+	}
+	logger.Access(r, http.StatusOK)
+}
+
+// tlsFields returns the TLS session of r and the fingerprints of the
+// client as the flat fields of the JSON output format, or nil for plain
+// HTTP.
+func tlsFields(r *http.Request) map[string]string {
+	if r.TLS == nil {
+		return nil
+	}
+	fields := map[string]string{
+		"tls-version":      tls.VersionName(r.TLS.Version),
+		"tls-cipher-suite": tls.CipherSuiteName(r.TLS.CipherSuite),
+		"tls-server-name":  r.TLS.ServerName,
+		"tls-alpn":         r.TLS.NegotiatedProtocol,
+		"tls-resumed":      strconv.FormatBool(r.TLS.DidResume),
+	}
+	if fp := RequestFingerprint(r); fp != nil {
+		fields["tls-ja3"] = fp.JA3
+		fields["tls-ja3-hash"] = fp.JA3Hash
+		fields["tls-ja4"] = fp.JA4
+		fields["tls-ja4-raw"] = fp.JA4Raw
+	}
+	return fields
+}
@@ -0,0 +1,100 @@
+package web
+
+import (
+	"crypto/tls"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestTLSHandler(t *testing.T) {
+	h := testHandler()
+	get := func(state *tls.ConnectionState, hello *ClientHello) tlsReport {
+		t.Helper()
+		req := httptest.NewRequest(http.MethodGet, "/tls", nil)
+		req.TLS = state
+		req = req.WithContext(WithConnInfo(req.Context(), &ConnInfo{Listener: "https", Hello: hello}))
+		w := httptest.NewRecorder()
+		h.TLSHandler(w, req)
+		if ct := w.Header().Get("Content-Type"); ct != "application/json" {
+			t.Errorf("expected JSON, got %s", ct)
+		}
+		var report tlsReport
+		if err := json.Unmarshal(w.Body.Bytes(), &report); err != nil {
+			t.Fatal(err)
+		}
+		return report
+	}
+
+	if report := get(nil, nil); report.TLS || report.ClientHello != nil {
+		t.Errorf("expected a plain HTTP request to report no TLS, got %+v", report)
+	}
+
+	report := get(&tls.ConnectionState{
+		Version:            tls.VersionTLS13,
+		CipherSuite:        tls.TLS_AES_128_GCM_SHA256,
+		ServerName:         "ip.example.com",
+		NegotiatedProtocol: "h2",
+		DidResume:          true,
+	}, &ClientHello{
+		ServerName:   "ip.example.com",
+		Versions:     []uint16{0x3a3a, tls.VersionTLS13, tls.VersionTLS12},
+		CipherSuites: []uint16{0x0a0a, tls.TLS_AES_128_GCM_SHA256, 0x1234},
+		Curves:       []tls.CurveID{tls.X25519},
+		PointFormats: []uint8{0},
+		ALPN:         []string{"h2", "http/1.1"},
+		Extensions:   []uint16{0xfafa, 0, 16, 4242},
+	})
+	if !report.TLS || report.Version != "TLS 1.3" || report.CipherSuite != "TLS_AES_128_GCM_SHA256" ||
+		report.ServerName != "ip.example.com" || report.ALPN != "h2" || !report.Resumed {
+		t.Errorf("unexpected session %+v", report)
+	}
+	hello := report.ClientHello
+	if hello == nil {
+		t.Fatal("expected the ClientHello to be reported")
+	}
+	want := []tlsValue{{0x0a0a, "GREASE"}, {tls.TLS_AES_128_GCM_SHA256, "TLS_AES_128_GCM_SHA256"}, {0x1234, "0x1234"}}
+	for i, w := range want {
+		if i >= len(hello.CipherSuites) || hello.CipherSuites[i] != w {
+			t.Errorf("expected cipher suites %v, got %v", want, hello.CipherSuites)
+			break
+		}
+	}
+	want = []tlsValue{{0xfafa, "GREASE"}, {0, "server_name"}, {16, "application_layer_protocol_negotiation"}, {4242, "4242"}}
+	for i, w := range want {
+		if i >= len(hello.Extensions) || hello.Extensions[i] != w {
+			t.Errorf("expected extensions %v, got %v", want, hello.Extensions)
+			break
+		}
+	}
+	if hello.Versions[1].Name != "TLS 1.3" || hello.Curves[0].Name != "X25519" || len(hello.PointFormats) != 1 {
+		t.Errorf("unexpected ClientHello %+v", hello)
+	}
+}
+
+func TestMainHandler_JSONTLSFields(t *testing.T) {
+	h := testHandler()
+	get := func(state *tls.ConnectionState) map[string]string {
+		t.Helper()
+		req := httptest.NewRequest(http.MethodGet, "/", nil)
+		req.TLS = state
+		req = req.WithContext(WithVHost(req.Context(), &VHost{Format: FormatJSON}))
+		w := httptest.NewRecorder()
+		h.MainHandler(w, req)
+		var fields map[string]string
+		if err := json.Unmarshal(w.Body.Bytes(), &fields); err != nil {
+			t.Fatal(err)
+		}
+		return fields
+	}
+
+	if _, ok := get(nil)["tls-version"]; ok {
+		t.Error("expected no TLS fields for plain HTTP")
+	}
+	fields := get(&tls.ConnectionState{Version: tls.VersionTLS12, CipherSuite: tls.TLS_ECDHE_RSA_WITH_AES_128_GCM_SHA256, NegotiatedProtocol: "http/1.1"})
+	if fields["tls-version"] != "TLS 1.2" || fields["tls-cipher-suite"] != "TLS_ECDHE_RSA_WITH_AES_128_GCM_SHA256" ||
+		fields["tls-alpn"] != "http/1.1" || fields["tls-resumed"] != "false" {
+		t.Errorf("unexpected TLS fields %v", fields)
+	}
+}
@@ -0,0 +1,175 @@
+package web
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"net/http"
+	"slices"
+	"strings"
+
+	"github.com/tuggan/goip/logger"
+)
+
+// Response formats of the root page.
+const (
+	FormatHTML = "html"
+	FormatText = "text"
+	FormatJSON = "json"
+)
+
+// VHost holds the settings of a group of hosts served by the same
+// listeners.
+type VHost struct {
+	// Hosts are exact host names, wildcards such as "*.example.com"
+	// matching any subdomain, or "*" matching every host.
+	Hosts []string
+	// Theme is the name of the theme, or "" to select it as usual.
+	Theme string
+	// Routes are the route patterns served, every route when nil.
+	Routes []string
+	// Format is the response format of the root page, html by default.
+	Format string
+	// Redirect is the canonical host. Requests for any other host are
+	// redirected to it.
+	Redirect string
+}
+
+// Allows reports whether the route registered under pattern is served.
+func (vh *VHost) Allows(pattern string) bool {
+	return vh == nil || vh.Routes == nil || slices.Contains(vh.Routes, pattern)
+}
+
+// format returns the response format of the root page.
+func (vh *VHost) format() string {
+	if vh == nil || vh.Format == "" {
+		return FormatHTML
+	}
+	return vh.Format
+}
+
+type wildcardVHost struct {
+	suffix string
+	vhost  *VHost
+}
+
+// VHosts selects the virtual host of a request by its Host header.
+type VHosts struct {
+	exact    map[string]*VHost
+	wildcard []wildcardVHost
+	fallback *VHost
+}
+
+// NewVHosts validates vhosts and prepares them for matching.
+func NewVHosts(vhosts []VHost) (*VHosts, error) {
+	v := &VHosts{exact: make(map[string]*VHost)}
+	seen := make(map[string]bool)
+	for i := range vhosts {
+		vh := &vhosts[i]
+		switch vh.Format {
+		case "", FormatHTML, FormatText, FormatJSON:
+		default:
+			return nil, fmt.Errorf("vhost %d: unknown format %q", i, vh.Format)
+		}
+		if strings.ContainsAny(vh.Redirect, "/?#@ ") {
+			return nil, fmt.Errorf("vhost %d: redirect %q is not a host name", i, vh.Redirect)
+		}
+		if len(vh.Hosts) == 0 {
+			return nil, fmt.Errorf("vhost %d: no hosts", i)
+		}
+		for _, h := range vh.Hosts {
+			h = strings.ToLower(strings.TrimSuffix(strings.TrimSpace(h), "."))
+			if seen[h] {
+				return nil, fmt.Errorf("vhost %d: host %q is used more than once", i, h)
+			}
+			seen[h] = true
+			switch {
+			case h == "*":
+				v.fallback = vh
+			case strings.HasPrefix(h, "*."):
+				v.wildcard = append(v.wildcard, wildcardVHost{suffix: h[1:], vhost: vh})
+			case h == "" || strings.Contains(h, "*"):
+				return nil, fmt.Errorf("vhost %d: invalid host %q", i, h)
+			default:
+				v.exact[h] = vh
+			}
+		}
+	}
+	// The most specific wildcard wins.
+	slices.SortStableFunc(v.wildcard, func(a, b wildcardVHost) int {
+		return len(b.suffix) - len(a.suffix)
+	})
+	return v, nil
+}
+
+// Match returns the virtual host of the Host header host, or nil if no
+// virtual host matches.
+func (v *VHosts) Match(host string) *VHost {
+	if h, _, err := net.SplitHostPort(host); err == nil {
+		host = h
+	}
+	host = strings.ToLower(strings.TrimSuffix(host, "."))
+	if vh, ok := v.exact[host]; ok {
+		return vh
+	}
+	for _, w := range v.wildcard {
+		if strings.HasSuffix(host, w.suffix) {
+			return w.vhost
+		}
+	}
+	return v.fallback
+}
+
+// isHost reports whether the Host header host is canonical, with or
+// without a port.
+func isHost(host, canonical string) bool {
+	if strings.EqualFold(host, canonical) {
+		return true
+	}
+	h, _, err := net.SplitHostPort(host)
+	return err == nil && strings.EqualFold(h, canonical)
+}
+
+type vhostKey struct{}
+
+// WithVHost returns a copy of ctx carrying vh.
+func WithVHost(ctx context.Context, vh *VHost) context.Context {
+	return context.WithValue(ctx, vhostKey{}, vh)
+}
+
+// VHostFromContext returns the virtual host of the request, or nil.
+func VHostFromContext(ctx context.Context) *VHost {
+	vh, _ := ctx.Value(vhostKey{}).(*VHost)
+	return vh
+}
+
+// Middleware redirects requests for a virtual host with a canonical host to
+// that host, and attaches the virtual host to the request context of every
+// other request.
+func (v *VHosts) Middleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		vh := v.Match(r.Host)
+		if vh == nil {
+			next.ServeHTTP(w, r)
+			return
+		}
+		if vh.Redirect != "" && !isHost(r.Host, vh.Redirect) {
+			scheme := "http"
+			if r.TLS != nil {
+				scheme = "https"
+			}
+			code := http.StatusMovedPermanently
+			if r.Method != http.MethodGet && r.Method != http.MethodHead {
+				code = http.StatusPermanentRedirect
+			}
+			http.Redirect(w, r, scheme+"://"+vh.Redirect+r.URL.RequestURI(), code)
+			logger.Access(r, code)
+			return
+		}
+		vr := r.WithContext(WithVHost(r.Context(), vh))
+		next.ServeHTTP(w, vr)
+		// The mux sets the matched pattern on the request it was handed,
+		// the middlewares around this one read it from theirs.
+		r.Pattern = vr.Pattern
+	})
+}
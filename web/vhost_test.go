@@ -0,0 +1,172 @@
+package web
+
+import (
+	"crypto/tls"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestNewVHosts_Errors(t *testing.T) {
+	for name, vhosts := range map[string][]VHost{
+		"no hosts":       {{Format: FormatText}},
+		"unknown format": {{Hosts: []string{"a"}, Format: "xml"}},
+		"duplicate host": {{Hosts: []string{"a"}}, {Hosts: []string{"A."}}},
+		"inner wildcard": {{Hosts: []string{"ip.*.example"}}},
+		"empty host":     {{Hosts: []string{" "}}},
+		"redirect url":   {{Hosts: []string{"a"}, Redirect: "https://b/"}},
+	} {
+		if _, err := NewVHosts(vhosts); err == nil {
+			t.Errorf("%s: expected an error", name)
+		}
+	}
+}
+
+func TestVHosts_Match(t *testing.T) {
+	v, err := NewVHosts([]VHost{
+		{Hosts: []string{"ip.example.com"}, Theme: "exact"},
+		{Hosts: []string{"*.example.com"}, Theme: "wildcard"},
+		{Hosts: []string{"*.v6.example.com"}, Theme: "v6"},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	for host, want := range map[string]string{
+		"ip.example.com":      "exact",
+		"IP.Example.com:8080": "exact",
+		"ip.example.com.":     "exact",
+		"ipv4.example.com":    "wildcard",
+		"a.v6.example.com":    "v6",
+		"example.com":         "",
+		"ip.example.org":      "",
+		"[2001:db8::1]:443":   "",
+		"notexample.com":      "",
+	} {
+		got := ""
+		if vh := v.Match(host); vh != nil {
+			got = vh.Theme
+		}
+		if got != want {
+			t.Errorf("Match(%q): expected %q, got %q", host, want, got)
+		}
+	}
+
+	v, err = NewVHosts([]VHost{{Hosts: []string{"*"}, Theme: "fallback"}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if vh := v.Match("anything.example"); vh == nil || vh.Theme != "fallback" {
+		t.Errorf("expected * to match every host, got %+v", vh)
+	}
+}
+
+func TestVHosts_Middleware(t *testing.T) {
+	v, err := NewVHosts([]VHost{
+		{Hosts: []string{"example.com", "www.example.com"}, Redirect: "example.com"},
+		{Hosts: []string{"ipv4.example.com"}, Format: FormatText},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	var got *VHost
+	h := v.Middleware(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		got = VHostFromContext(r.Context())
+	}))
+
+	serve := func(method, url string, tlsState *tls.ConnectionState) *httptest.ResponseRecorder {
+		got = nil
+		req := httptest.NewRequest(method, url, nil)
+		req.TLS = tlsState
+		w := httptest.NewRecorder()
+		h.ServeHTTP(w, req)
+		return w
+	}
+
+	w := serve(http.MethodGet, "http://www.example.com/ip?x=1", nil)
+	if w.Code != http.StatusMovedPermanently || w.Header().Get("Location") != "http://example.com/ip?x=1" {
+		t.Errorf("expected a permanent redirect to the canonical host, got %d %q", w.Code, w.Header().Get("Location"))
+	}
+	w = serve(http.MethodPost, "https://www.example.com/GET", &tls.ConnectionState{})
+	if w.Code != http.StatusPermanentRedirect || w.Header().Get("Location") != "https://example.com/GET" {
+		t.Errorf("expected a 308 redirect keeping the scheme, got %d %q", w.Code, w.Header().Get("Location"))
+	}
+	for _, url := range []string{"http://example.com/", "http://EXAMPLE.com:8080/"} {
+		if w := serve(http.MethodGet, url, nil); w.Code != http.StatusOK || got == nil {
+			t.Errorf("%s: expected the canonical host to be served, got %d", url, w.Code)
+		}
+	}
+	serve(http.MethodGet, "http://ipv4.example.com/", nil)
+	if got == nil || got.Format != FormatText {
+		t.Errorf("expected the vhost in the request context, got %+v", got)
+	}
+	serve(http.MethodGet, "http://other.example/", nil)
+	if got != nil {
+		t.Errorf("expected no vhost for an unknown host, got %+v", got)
+	}
+}
+
+func TestMainHandler_VHostFormats(t *testing.T) {
+	h := testHandler()
+	get := func(vh *VHost) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(http.MethodGet, "/", nil)
+		req.RemoteAddr = "1.2.3.4:5678"
+		req.Header.Set("User-Agent", "curl/8")
+		req = req.WithContext(WithVHost(req.Context(), vh))
+		w := httptest.NewRecorder()
+		h.MainHandler(w, req)
+		return w
+	}
+
+	w := get(&VHost{Format: FormatText})
+	if w.Body.String() != "1.2.3.4\n" || !strings.HasPrefix(w.Header().Get("Content-Type"), "text/plain") {
+		t.Errorf("expected the plain IP, got %q (%s)", w.Body.String(), w.Header().Get("Content-Type"))
+	}
+
+	w = get(&VHost{Format: FormatJSON})
+	if w.Header().Get("Content-Type") != "application/json" {
+		t.Errorf("expected JSON, got %s", w.Header().Get("Content-Type"))
+	}
+	var fields map[string]string
+	if err := json.Unmarshal(w.Body.Bytes(), &fields); err != nil {
+		t.Fatal(err)
+	}
+	if fields["ip"] != "1.2.3.4" || fields["user-agent"] != "curl/8" {
+		t.Errorf("expected the client info, got %v", fields)
+	}
+
+	w = get(&VHost{})
+	if !strings.Contains(w.Body.String(), "<html") {
+		t.Error("expected the HTML page by default")
+	}
+}
+
+func TestVHost_Theme(t *testing.T) {
+	themes, err := LoadThemes(themeTestFS(), "", Site{}, []ThemeConfig{{Name: "other"}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	h := testHandler()
+	h.SetThemes(themes)
+
+	req := httptest.NewRequest(http.MethodGet, "/", nil)
+	req.RemoteAddr = "1.2.3.4:5678"
+	req = req.WithContext(WithVHost(req.Context(), &VHost{Theme: "other"}))
+	w := httptest.NewRecorder()
+	h.MainHandler(w, req)
+	if w.Body.String() != "other" {
+		t.Errorf("expected the vhost theme, got %q", w.Body.String())
+	}
+}
+
+func TestVHost_Allows(t *testing.T) {
+	var none *VHost
+	if !none.Allows("/") || !(&VHost{}).Allows("/") {
+		t.Error("expected every route without a route list")
+	}
+	vh := &VHost{Routes: []string{"/", "/health"}}
+	if !vh.Allows("/health") || vh.Allows("/GET") {
+		t.Error("expected only the listed routes")
+	}
+}